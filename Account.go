@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -41,7 +40,7 @@ type Account struct {
 	CustName   string  `json:CustName`    //客戶名稱
 	CustType   string  `json:CustType`    //存戶類別編號
 	Status     string  `json:"Status"`    // Status values ( NORMAL, PAUSED )
-	Assets     []Asset `json:"Assets"`
+	Assets     []Asset `json:"Assets,omitempty"`
 }
 
 /*
@@ -51,7 +50,7 @@ type Account struct {
 4.客戶名稱
 5.存戶類別編號
 6.帳戶狀態
-7.客戶持有公債
+7.客戶持有公債(由Position投影)
 */
 
 //peer chaincode invoke -n mycc2 -c '{"Args":["initAccount", "002000000001" , "002" , "BANK002" , "CUST001" , "00001", "A06101", "1000000" , "1000000" , "1000000", "0" ]}' -C myc
@@ -62,37 +61,37 @@ func (s *SmartContract) initAccount(
 	// AccountID, BankID,SecurityID, Balance, Status
 	err := checkArgArrayLength(args, 10)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("BankID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankID must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("BankName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankName must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return shim.Error("CustName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "CustName must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
-		return shim.Error("CustType must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "CustType must be a non-empty string")
 	}
 	if len(args[5]) <= 0 {
-		return shim.Error("SecurityID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
 	}
 	if len(args[6]) <= 0 {
-		return shim.Error("SecurityAmount must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityAmount must be a non-empty string")
 	}
 	if len(args[7]) <= 0 {
-		return shim.Error("Balance must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Balance must be a non-empty string")
 	}
 	if len(args[8]) <= 0 {
-		return shim.Error("Position must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Position must be a non-empty string")
 	}
 	if len(args[9]) <= 0 {
-		return shim.Error("Status must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Status must be a non-empty string")
 	}
 
 	AccountID := args[0]
@@ -103,33 +102,32 @@ func (s *SmartContract) initAccount(
 	SecurityID := strings.ToUpper(args[5])
 	SecurityAmount, err := strconv.ParseInt(args[6], 10, 64)
 	if err != nil {
-		return shim.Error("SecurityAmount must be a numeric string")
+		return errorResponse(codeArgumentFormat, "SecurityAmount must be a numeric string")
 	}
 	Balance, err := strconv.ParseInt(args[7], 10, 64)
 	if err != nil {
-		return shim.Error("Balance must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Balance must be a numeric string")
 	}
 	Position, err := strconv.ParseInt(args[8], 10, 64)
 	if err != nil {
-		return shim.Error("Position must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Position must be a numeric string")
 	}
 	Status := strings.ToUpper(args[9])
 	accountAsBytes, err := stub.GetState(AccountID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	} else if accountAsBytes != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This account already exists (%s)",
 			AccountID)
-		return shim.Error(errMsg)
+		return errorResponse(codeAlreadyExists, errMsg)
 	}
-	var asset Asset
-	asset.SecurityID = SecurityID
-	asset.SecurityAmount = SecurityAmount
-	asset.Balance = Balance
-	asset.Position = Position
-	asset.TotalPayment = 0
-	asset.PendingBalance = Balance
+	position := newPosition(AccountID, BankID, SecurityID)
+	position.SecurityAmount = SecurityAmount
+	position.Balance = Balance
+	position.Position = Position
+	position.TotalPayment = 0
+	position.PendingBalance = Balance
 
 	account := Account{}
 	account.ObjectType = accountObjectType
@@ -139,21 +137,24 @@ func (s *SmartContract) initAccount(
 	account.CustName = CustName
 	account.CustType = CustType
 	account.Status = Status
-	account.Assets = append(account.Assets, asset)
 
 	err = updateBankTotals(stub, BankID, SecurityID, AccountID, Balance, SecurityAmount, false)
 	if err != nil {
-		return shim.Error("Failed to change banktotal state")
+		return errorResponse(codeStateError, "Failed to change banktotal state")
 	}
 
 	accountAsBytes, err = json.Marshal(account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	err = stub.PutState(AccountID, accountAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = putPositionStruct(stub, position)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	//err = updateBankAccounts(stub, BankID, AccountID)
@@ -161,6 +162,11 @@ func (s *SmartContract) initAccount(
 	//	return shim.Error(err.Error())
 	//}
 
+	account.Assets = append(account.Assets, positionToAsset(*position))
+	accountAsBytes, err = json.Marshal(account)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
 	return shim.Success(accountAsBytes)
 }
 
@@ -174,37 +180,37 @@ func (s *SmartContract) updateAccount(
 	// AccountID, BankID, Balance, Status
 	err := checkArgArrayLength(args, 10)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("BankID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankID must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("BankName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankName must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return shim.Error("CustName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "CustName must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
-		return shim.Error("CustType must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "CustType must be a non-empty string")
 	}
 	if len(args[5]) <= 0 {
-		return shim.Error("SecurityID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
 	}
 	if len(args[6]) <= 0 {
-		return shim.Error("SecurityAmount must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityAmount must be a non-empty string")
 	}
 	if len(args[7]) <= 0 {
-		return shim.Error("Balance must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Balance must be a non-empty string")
 	}
 	if len(args[8]) <= 0 {
-		return shim.Error("Position must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Position must be a non-empty string")
 	}
 	if len(args[9]) <= 0 {
-		return shim.Error("Status must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Status must be a non-empty string")
 	}
 
 	AccountID := args[0]
@@ -215,25 +221,25 @@ func (s *SmartContract) updateAccount(
 	SecurityID := strings.ToUpper(args[5])
 	SecurityAmount, err := strconv.ParseInt(args[6], 10, 64)
 	if err != nil {
-		return shim.Error("SecurityAmount must be a numeric string")
+		return errorResponse(codeArgumentFormat, "SecurityAmount must be a numeric string")
 	}
 	Balance, err := strconv.ParseInt(args[7], 10, 64)
 	if err != nil {
-		return shim.Error("Balance must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Balance must be a numeric string")
 	}
 	Position, err := strconv.ParseInt(args[8], 10, 64)
 	if err != nil {
-		return shim.Error("Position must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Position must be a numeric string")
 	}
 	Status := strings.ToUpper(args[9])
 	accountAsBytes, err := stub.GetState(AccountID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	} else if accountAsBytes == nil {
 		errMsg := fmt.Sprintf(
 			"Error: This account does not exist (%s)",
 			AccountID)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
 	account := Account{}
@@ -246,39 +252,38 @@ func (s *SmartContract) updateAccount(
 	account.CustType = CustType
 	account.Status = Status
 
-	var doflg bool
-	doflg = false
-	for key, val := range account.Assets {
-		if val.SecurityID == SecurityID {
-			account.Assets[key].SecurityID = SecurityID
-			account.Assets[key].SecurityAmount = SecurityAmount
-			account.Assets[key].Balance = Balance
-			account.Assets[key].Position = Position
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
-		var asset Asset
-		asset.SecurityID = SecurityID
-		asset.SecurityAmount = SecurityAmount
-		asset.Balance = Balance
-		asset.Position = Position
-		asset.TotalPayment = 0
-		asset.PendingBalance = Balance
-		account.Assets = append(account.Assets, asset)
+	position, err := getPositionStruct(stub, AccountID, SecurityID)
+	if err != nil {
+		position = newPosition(AccountID, BankID, SecurityID)
+		position.TotalPayment = 0
+		position.PendingBalance = Balance
 	}
+	position.SecurityAmount = SecurityAmount
+	position.Balance = Balance
+	position.Position = Position
 
 	accountAsBytes, err = json.Marshal(account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	err = stub.PutState(AccountID, accountAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = putPositionStruct(stub, position)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
 	}
 
+	account.Assets, err = getAccountAssets(stub, AccountID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	accountAsBytes, err = json.Marshal(account)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
 	return shim.Success(accountAsBytes)
 }
 
@@ -288,7 +293,7 @@ func (s *SmartContract) deleteAccount(
 
 	err := checkArgArrayLength(args, 2)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 
 	AccountID := args[0]
@@ -305,12 +310,12 @@ func (s *SmartContract) deleteAccount(
 		errMsg := fmt.Sprintf(
 			"Error: Failed to get state for account (%s)",
 			AccountID)
-		return shim.Error(errMsg)
+		return errorResponse(codeStateError, errMsg)
 	} else if accountAsBytes == nil {
 		errMsg := fmt.Sprintf(
 			"Error: Account does not exist (%s)",
 			AccountID)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
 	account := Account{}
@@ -321,12 +326,27 @@ func (s *SmartContract) deleteAccount(
 			"bankID set for account [%s] does not match BankID provided [%s]",
 			account.BankID,
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeUnauthorized, errMsg)
 	}
 
+	positions, err := getAccountPositions(stub, AccountID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	for _, val := range positions {
+		if errMsg := checkNoPledge(&val); errMsg != "" {
+			return errorResponse(codePledgeActive, errMsg)
+		}
+	}
 	err = stub.DelState(AccountID)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errorResponse(codeStateError, "Failed to delete state:"+err.Error())
+	}
+	for _, val := range positions {
+		err = delPositionStruct(stub, AccountID, val.SecurityID)
+		if err != nil {
+			return errorResponse(codeStateError, "Failed to delete state:"+err.Error())
+		}
 	}
 	return shim.Success(nil)
 }
@@ -339,13 +359,13 @@ func (s *SmartContract) updateAccountStatus(
 
 	err := checkArgArrayLength(args, 2)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("Status must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Status must be a non-empty string")
 	}
 
 	AccountID := args[0]
@@ -354,35 +374,56 @@ func (s *SmartContract) updateAccountStatus(
 
 	accountAsBytes, err := json.Marshal(account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = stub.PutState(AccountID, accountAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	return shim.Success(nil)
 }
 
 //peer chaincode query -n mycc2 -c '{"Args":["readAccount","002000000001"]}' -C myc
+func (s *SmartContract) readAccount(
+	stub shim.ChaincodeStubInterface,
+	args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 1)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+
+	account, err := getAccountStructFromID(stub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	account.Assets, err = getAccountAssets(stub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(account)
+}
+
+//peer chaincode query -n mycc2 -c '{"Args":["readBank","BANK002"]}' -C myc
 func (s *SmartContract) getStateAsBytes(
 	stub shim.ChaincodeStubInterface,
 	args []string) peer.Response {
 
 	err := checkArgArrayLength(args, 1)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 
 	key := args[0]
 	valAsbytes, err := stub.GetState(key)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	} else if valAsbytes == nil {
 		errMsg := fmt.Sprintf("Error: Key does not exist (%s)", key)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
-	return shim.Success(valAsbytes)
+	return dataResponse(KeyRecord{key, getRecordValue(valAsbytes)})
 }
 
 func getAccountStructFromID(
@@ -428,70 +469,63 @@ func (s *SmartContract) updateAsset(stub shim.ChaincodeStubInterface, args []str
 
 	err := checkArgArrayLength(args, 5)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("SecurityID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("SecurityAmount must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityAmount must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return shim.Error("Balance must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Balance must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
-		return shim.Error("Position must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Position must be a non-empty string")
 	}
 
 	AccountID := args[0]
 	SecurityID := args[1]
 	SecurityAmount, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		return shim.Error("SecurityAmount must be a numeric string")
+		return errorResponse(codeArgumentFormat, "SecurityAmount must be a numeric string")
 	}
 	Balance, err := strconv.ParseInt(args[3], 10, 64)
 	if err != nil {
-		return shim.Error("Balance must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Balance must be a numeric string")
 	}
 	Position, err := strconv.ParseInt(args[4], 10, 64)
 	if err != nil {
-		return shim.Error("Position must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Position must be a numeric string")
 	}
 
 	account, err := getAccountStructFromID(stub, AccountID)
-	if account.Status == "PAUSED" {
-		return shim.Error("Account Status is : " + account.Status)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
 	}
-
-	var doflg bool
-	doflg = false
-	for key, val := range account.Assets {
-		if val.SecurityID == args[1] {
-			account.Assets[key].SecurityAmount = SecurityAmount
-			account.Assets[key].Balance = Balance
-			account.Assets[key].Position = Position
-			doflg = true
-		}
+	if account.Status == "PAUSED" {
+		return errorResponse(codeInvalidStatus, "Account Status is : "+account.Status)
 	}
 
-	if doflg != true {
-		var asset Asset
-		asset.SecurityID = SecurityID
-		asset.SecurityAmount = SecurityAmount
-		asset.Balance = Balance
-		asset.Position = Position
-		asset.TotalPayment = 0
-	}
-	accountAsBytes, err := json.Marshal(account)
+	position, err := getPositionStruct(stub, AccountID, SecurityID)
 	if err != nil {
-		return shim.Error(err.Error())
+		position = newPosition(AccountID, account.BankID, SecurityID)
+		position.TotalPayment = 0
+		position.PendingBalance = Balance
 	}
-	err = stub.PutState(AccountID, accountAsBytes)
+	position.SecurityAmount = SecurityAmount
+	position.Balance = Balance
+	position.Position = Position
+	if errMsg := checkPledgedBalance(position); errMsg != "" {
+		return errorResponse(codeInsufficientFreeBalance, errMsg)
+	}
+
+	err = putPositionStruct(stub, position)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(nil)
@@ -501,22 +535,22 @@ func (s *SmartContract) updateAssetBalance(stub shim.ChaincodeStubInterface, arg
 
 	err := checkArgArrayLength(args, 5)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("SecurityID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("BUY or SELL must be a non-empty string") //BUY , SELL
+		return errorResponse(codeArgumentEmpty, "BUY or SELL must be a non-empty string") //BUY , SELL
 	}
 	if len(args[3]) <= 0 {
-		return shim.Error("Balance must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Balance must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
-		return shim.Error("Position must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Position must be a non-empty string")
 	}
 
 	AccountID := strings.ToUpper(args[0])
@@ -524,46 +558,42 @@ func (s *SmartContract) updateAssetBalance(stub shim.ChaincodeStubInterface, arg
 	BuyOrSell := strings.ToUpper(args[2])
 	Balance, err := strconv.ParseInt(args[3], 10, 64)
 	if err != nil {
-		return shim.Error("Balance must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Balance must be a numeric string")
 	}
 	Position, err := strconv.ParseInt(args[4], 10, 64)
 	if err != nil {
-		return shim.Error("Position must be a numeric string")
+		return errorResponse(codeArgumentFormat, "Position must be a numeric string")
 	}
 
 	account, err := getAccountStructFromID(stub, AccountID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
 	if account.Status == "PAUSED" {
-		return shim.Error("Account Status is : " + account.Status)
-	}
-
-	var doflg bool
-	doflg = false
-	for key, val := range account.Assets {
-		if val.SecurityID == SecurityID {
-			if BuyOrSell == "S" {
-				account.Assets[key].Balance -= Balance
-				account.Assets[key].Position -= Position
-				account.Assets[key].TotalPayment += Balance
-			}
-			if BuyOrSell == "B" {
-				account.Assets[key].Balance += Balance
-				account.Assets[key].Position += Position
-				account.Assets[key].TotalPayment -= Balance
-			}
-			doflg = true
-		}
+		return errorResponse(codeInvalidStatus, "Account Status is : "+account.Status)
 	}
 
-	if doflg != true {
-		return shim.Error("Failed to query assets state")
-	}
-	accountAsBytes, err := json.Marshal(account)
+	position, err := getPositionStruct(stub, AccountID, SecurityID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, "Failed to query assets state")
 	}
-	err = stub.PutState(AccountID, accountAsBytes)
+	if BuyOrSell == "S" {
+		position.Balance -= Balance
+		position.Position -= Position
+		position.TotalPayment += Balance
+	}
+	if BuyOrSell == "B" {
+		position.Balance += Balance
+		position.Position += Position
+		position.TotalPayment -= Balance
+	}
+	if errMsg := checkPledgedBalance(position); errMsg != "" {
+		return errorResponse(codeInsufficientFreeBalance, errMsg)
+	}
+
+	err = putPositionStruct(stub, position)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(nil)
@@ -574,40 +604,30 @@ func (s *SmartContract) deleteAsset(stub shim.ChaincodeStubInterface, args []str
 
 	err := checkArgArrayLength(args, 2)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("AccountID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("SecurityID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
 	}
 
 	AccountID := args[0]
-	account, err := getAccountStructFromID(stub, AccountID)
-
-	var doflg bool
-	doflg = false
-	var assets []Asset
-	filteredAssets := assets[:0]
-	for _, val := range account.Assets {
-		if val.SecurityID == args[1] {
-			doflg = true
-		} else {
-			filteredAssets = append(filteredAssets, val)
-		}
-	}
-
-	if doflg == true {
-		account.Assets = filteredAssets
-	}
-	accountAsBytes, err := json.Marshal(account)
+	_, err = getAccountStructFromID(stub, AccountID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeNotFound, err.Error())
 	}
-	err = stub.PutState(AccountID, accountAsBytes)
-	if err != nil {
-		return shim.Error(err.Error())
+
+	position, err := getPositionStruct(stub, AccountID, args[1])
+	if err == nil {
+		if errMsg := checkNoPledge(position); errMsg != "" {
+			return errorResponse(codePledgeActive, errMsg)
+		}
+		err = delPositionStruct(stub, AccountID, args[1])
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
 	}
 
 	return shim.Success(nil)
@@ -617,269 +637,106 @@ func (s *SmartContract) deleteAsset(stub shim.ChaincodeStubInterface, args []str
 func (s *SmartContract) queryAsset(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
-	AccountAsBytes, _ := APIstub.GetState(args[0])
-	Account := Account{}
-	json.Unmarshal(AccountAsBytes, &Account)
-
-	OwnerAsBytes, err := json.Marshal(Account.Assets)
+	Assets, err := getAccountAssets(APIstub, args[0])
 	if err != nil {
-		return shim.Error("Failed to query assets state")
+		return errorResponse(codeStateError, "Failed to query assets state")
 	}
 
-	return shim.Success(OwnerAsBytes)
+	return dataResponse(Assets)
 }
 
 //peer chaincode query -n mycc2 -c '{"Args":["queryAssetLength","002000000001"]}' -C myc
 func (s *SmartContract) queryAssetLength(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
-	AccountAsBytes, _ := APIstub.GetState(args[0])
-	Account := Account{}
-	json.Unmarshal(AccountAsBytes, &Account)
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"AccountID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Account.AccountID)
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"OwnersLength\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(strconv.Itoa(len(Account.Assets)))
-	buffer.WriteString("\"")
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	Account, err := getAccountStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	Assets, err := getAccountAssets(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query assets state")
+	}
 
+	return dataResponse(AccountAssetLength{Account.AccountID, len(Assets)})
 }
 
 //peer chaincode query -n mycc2 -c '{"Args":["queryAssetInfo","002000000001" , "A06101" ]}' -C myc
 func (s *SmartContract) queryAssetInfo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
-	AccountAsBytes, _ := APIstub.GetState(args[0])
-	Account := Account{}
-	json.Unmarshal(AccountAsBytes, &Account)
-
-	var doflg bool
-	doflg = false
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"AccountID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Account.AccountID)
-	buffer.WriteString("\"")
-	buffer.WriteString(",\"Records\":[")
-	bArrayMemberAlreadyWritten := false
-	for key, val := range Account.Assets {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
+	}
+
+	Account, err := getAccountStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	Assets, err := getAccountAssets(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query assets state")
+	}
+
+	AssetInfo := AccountAssetInfo{AccountID: Account.AccountID, Records: []AccountAssetRecord{}}
+	for key, val := range Assets {
 		if val.SecurityID == args[1] {
-			// Add a comma before array members, suppress it for the first array member
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			buffer.WriteString("{\"AssetKey\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(key + 1))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.SecurityID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityAmount\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.SecurityAmount, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Balance\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.Balance, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Position\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.Position, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TotalPayment\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.TotalPayment, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"PendingBalance\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.PendingBalance, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
-			doflg = true
+			AssetInfo.Records = append(AssetInfo.Records, AccountAssetRecord{key + 1, val})
 		}
 	}
-	buffer.WriteString("]")
-	if doflg != true {
-		//return shim.Error("Failed to find SecurityID ")
-		buffer.WriteString(", \"Value\":")
-		buffer.WriteString("Failed to find SecurityID")
+	if len(AssetInfo.Records) == 0 {
+		return errorResponse(codeNotFound, "Failed to find SecurityID")
 	}
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return dataResponse(AssetInfo)
 }
 
 //peer chaincode query -n mycc2 -c '{"Args":["queryAccountStatus","002000000001"]}' -C myc
 func (s *SmartContract) queryAccountStatus(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
-	AccountAsBytes, _ := APIstub.GetState(args[0])
-	Account := Account{}
-	json.Unmarshal(AccountAsBytes, &Account)
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"AccountID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Account.AccountID)
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"AccountStatus\":")
-	buffer.WriteString(Account.Status)
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	Account, err := getAccountStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
 
+	return dataResponse(AccountStatus{Account.AccountID, Account.Status})
 }
 
 //peer chaincode query -n mycc2 -c '{"Args":["queryAllAccounts","000000000001" , "999999999999"]}' -C myc
+//peer chaincode query -n mycc2 -c '{"Args":["queryAllAccounts","000000000001" , "999999999999" , "100"]}' -C myc
 func (s *SmartContract) queryAllAccounts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as/is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryForAccount","002000000001"]}' -C myc
 func (s *SmartContract) getHistoryForAccount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	AccountID := args[0]
 
 	fmt.Printf("- start getHistoryForAccount: %s\n", AccountID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(AccountID)
-
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForAccount returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, AccountID, "")
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryTXIDForAccount","002000000001","a4723f60d5c85d29a2107382fb8e3c8c1624924b970efa04f313727a0dfaa0ff"]}' -C myc
 func (s *SmartContract) getHistoryTXIDForAccount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	AccountID := args[0]
@@ -888,65 +745,16 @@ func (s *SmartContract) getHistoryTXIDForAccount(APIstub shim.ChaincodeStubInter
 	fmt.Printf("- start getHistoryTXIDForAccount: %s\n", AccountID)
 	fmt.Printf("- start getHistoryTXIDForAccount: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(AccountID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		if response.TxId == TXID {
-			buffer.WriteString("{\"TxId\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(response.TxId)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Value\":")
-			// if it was a delete operation on given key, then we need to set the
-			//corresponding value null. Else, we will write the response.Value
-			//as-is (as the Value itself a JSON marble)
-			if response.IsDelete {
-				buffer.WriteString("null")
-			} else {
-				buffer.WriteString(string(response.Value))
-			}
-
-			buffer.WriteString(", \"Timestamp\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-			buffer.WriteString("\"")
-
-			buffer.WriteString(", \"IsDelete\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatBool(response.IsDelete))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-
-			break
-		}
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryTXIDForAccount returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, AccountID, TXID)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryAllAccountKeys","002000000001" , "004000000009"]}' -C myc
-//Query Result: ["002000000001","002000000002","004000000001","004000000002"]
+//Query Result: {"Data":["002000000001","002000000002","004000000001","004000000002"],"Pagination":{"StartKey":"002000000001","EndKey":"004000000009","PageSize":0,"Count":4,"NextKey":""},"Warnings":[]}
 
 func (s *SmartContract) queryAllAccountKeys(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Keys operation must include two arguments, startKey and endKey")
+		return errorResponse(codeArgumentCount, "Keys operation must include two arguments, startKey and endKey")
 	}
 	startKey := args[0]
 	endKey := args[1]
@@ -959,11 +767,11 @@ func (s *SmartContract) queryAllAccountKeys(APIstub shim.ChaincodeStubInterface,
 
 	keysIter, err := APIstub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+		return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 	}
 	defer keysIter.Close()
 
-	var keys []string
+	keys := []string{}
 	for keysIter.HasNext() {
 		//if sleeptime is specied, take a nap
 		if stime > 0 {
@@ -972,7 +780,7 @@ func (s *SmartContract) queryAllAccountKeys(APIstub shim.ChaincodeStubInterface,
 
 		response, iterErr := keysIter.Next()
 		if iterErr != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 		}
 		keys = append(keys, response.Key)
 	}
@@ -981,11 +789,6 @@ func (s *SmartContract) queryAllAccountKeys(APIstub shim.ChaincodeStubInterface,
 		fmt.Printf("key %d contains %s\n", key, value)
 	}
 
-	jsonKeys, err := json.Marshal(keys)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error marshaling JSON: %s", err))
-	}
-
-	return shim.Success(jsonKeys)
+	return envelopeResponse(keys, &Pagination{StartKey: startKey, EndKey: endKey, Count: len(keys)}, nil)
 
 }
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const AuctionObjectType string = "Auction"
+const AuctionBidObjectType string = "AuctionBid"
+const AuctionResultObjectType string = "AuctionResult"
+
+const auctionOpen string = "Open"
+const auctionAllotted string = "Allotted"
+
+const auctionUniform string = "UNIFORM"   //單一價格：得標者皆以最低得標價格計價
+const auctionMultiple string = "MULTIPLE" //複數價格：得標者以各自投標價格計價
+
+const bidCompetitive string = "C"    //競標
+const bidNonCompetitive string = "N" //非競標
+
+const bidSubmitted string = "Submitted"
+const bidAllotted string = "Allotted"
+const bidPartial string = "Partial"
+const bidRejected string = "Rejected"
+
+const parPrice float64 = 100 //無競標得標價格時，以面額計價
+
+type Auction struct {
+	ObjectType     string  `json:"docType"`        // default set to "Auction"
+	AuctionID      string  `json:"AuctionID"`      // openAuction 的 Fabric TxID
+	SecurityID     string  `json:"SecurityID"`     // 公債代號
+	OfferingAmount int64   `json:"OfferingAmount"` // 發行額
+	CloseTime      string  `json:"CloseTime"`      // 截止時間(YYYY/MM/DD HH:MM:SS)
+	AllotmentRule  string  `json:"AllotmentRule"`  // UNIFORM, MULTIPLE
+	TrancheNo      int     `json:"TrancheNo"`      // 標售之發行次序，0 表示未指定
+	AuctionStatus  string  `json:"AuctionStatus"`  // Open, Allotted
+	AllottedAmount int64   `json:"AllottedAmount"` // 得標總額
+	StopPrice      float64 `json:"StopPrice"`      // 最低得標價格
+	CreateTime     string  `json:"createTime"`
+	UpdateTime     string  `json:"updateTime"`
+}
+
+/*
+CBC 開標，銀行於截止時間前以自己的客戶帳號投標，截止後由 CBC 執行配售：
+得標券數撥入 Position、扣減 Security.Balance 並自 Position.SecurityAmount 扣款，配售結果另存一筆 AuctionResult。
+款項(帳號各持有部位 SecurityAmount 的合計)不足以繳付應繳價款的投標不予配售(記錄未配售原因)，其餘投標重新配售。
+Key: Auction~AuctionID
+
+1.標售序號
+2.公債代號
+3.發行額
+4.截止時間
+5.配售方式
+6.發行次序
+7.標售狀態
+8.得標總額
+9.最低得標價格
+10.建立時間
+11.更新時間
+*/
+
+type AuctionBid struct {
+	ObjectType     string  `json:"docType"`        // default set to "AuctionBid"
+	AuctionID      string  `json:"AuctionID"`      // 標售序號
+	BidID          string  `json:"BidID"`          // submitBid 的 Fabric TxID
+	BankID         string  `json:"BankID"`         // 投標銀行
+	AccountID      string  `json:"AccountID"`      // 客戶帳號
+	BidType        string  `json:"BidType"`        // C:競標, N:非競標
+	Amount         int64   `json:"Amount"`         // 投標金額(面額)
+	Price          float64 `json:"Price"`          // 投標價格(每百元面額)，非競標為 0
+	AllottedAmount int64   `json:"AllottedAmount"` // 得標金額(面額)
+	AllottedPrice  float64 `json:"AllottedPrice"`  // 得標價格
+	Cost           int64   `json:"Cost"`           // 應繳價款
+	BidStatus      string  `json:"BidStatus"`      // Submitted, Allotted, Partial, Rejected
+	RejectReason   string  `json:"RejectReason"`   // 因款項不足未配售的原因
+	CreateTime     string  `json:"createTime"`
+	UpdateTime     string  `json:"updateTime"`
+}
+
+/*
+Key: AuctionBid~AuctionID~BidID
+
+1.標售序號
+2.投標序號
+3.投標銀行
+4.客戶帳號
+5.競標或非競標
+6.投標金額
+7.投標價格
+8.得標金額
+9.得標價格
+10.應繳價款
+11.投標狀態
+12.未配售原因
+13.建立時間
+14.更新時間
+*/
+
+type AuctionResult struct {
+	ObjectType           string       `json:"docType"`              // default set to "AuctionResult"
+	AuctionID            string       `json:"AuctionID"`            // 標售序號
+	SecurityID           string       `json:"SecurityID"`           // 公債代號
+	AllotmentRule        string       `json:"AllotmentRule"`        // UNIFORM, MULTIPLE
+	OfferingAmount       int64        `json:"OfferingAmount"`       // 發行額
+	CompetitiveAmount    int64        `json:"CompetitiveAmount"`    // 競標投標總額
+	NonCompetitiveAmount int64        `json:"NonCompetitiveAmount"` // 非競標投標總額
+	AllottedAmount       int64        `json:"AllottedAmount"`       // 得標總額
+	StopPrice            float64      `json:"StopPrice"`            // 最低得標價格
+	AveragePrice         float64      `json:"AveragePrice"`         // 競標加權平均得標價格
+	TotalCost            int64        `json:"TotalCost"`            // 應繳價款合計
+	Bids                 []AuctionBid `json:"Bids"`                 // 各投標配售結果
+	CreateTime           string       `json:"createTime"`
+}
+
+/*
+Key: AuctionResult~AuctionID
+
+1.標售序號
+2.公債代號
+3.配售方式
+4.發行額
+5.競標投標總額
+6.非競標投標總額
+7.得標總額
+8.最低得標價格
+9.競標加權平均得標價格
+10.應繳價款合計
+11.各投標配售結果
+12.建立時間
+*/
+
+func getAuctionStruct(stub shim.ChaincodeStubInterface, AuctionID string) (*Auction, error) {
+
+	auctionKey, err := stub.CreateCompositeKey(AuctionObjectType, []string{AuctionID})
+	if err != nil {
+		return nil, err
+	}
+	auctionAsBytes, err := stub.GetState(auctionKey)
+	if err != nil {
+		return nil, err
+	}
+	if auctionAsBytes == nil {
+		return nil, fmt.Errorf("AuctionID does not exist: %s", AuctionID)
+	}
+	auction := Auction{}
+	err = json.Unmarshal(auctionAsBytes, &auction)
+	if err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+func putAuctionStruct(stub shim.ChaincodeStubInterface, auction *Auction) error {
+
+	auctionKey, err := stub.CreateCompositeKey(AuctionObjectType, []string{auction.AuctionID})
+	if err != nil {
+		return err
+	}
+	auction.ObjectType = AuctionObjectType
+	auction.UpdateTime = getTxTime(stub).Format(timelayout2)
+	auctionAsBytes, err := json.Marshal(auction)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(auctionKey, auctionAsBytes)
+}
+
+func putAuctionBidStruct(stub shim.ChaincodeStubInterface, bid *AuctionBid) error {
+
+	bidKey, err := stub.CreateCompositeKey(AuctionBidObjectType, []string{bid.AuctionID, bid.BidID})
+	if err != nil {
+		return err
+	}
+	bid.ObjectType = AuctionBidObjectType
+	bid.UpdateTime = getTxTime(stub).Format(timelayout2)
+	bidAsBytes, err := json.Marshal(bid)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(bidKey, bidAsBytes)
+}
+
+//依投標時間排序
+func getAuctionBids(stub shim.ChaincodeStubInterface, AuctionID string) ([]AuctionBid, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(AuctionBidObjectType, []string{AuctionID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	bids := []AuctionBid{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		bid := AuctionBid{}
+		err = json.Unmarshal(queryResponse.Value, &bid)
+		if err != nil {
+			return nil, err
+		}
+		bids = append(bids, bid)
+	}
+	sort.SliceStable(bids, func(i, j int) bool {
+		if bids[i].CreateTime != bids[j].CreateTime {
+			return bids[i].CreateTime < bids[j].CreateTime
+		}
+		return bids[i].BidID < bids[j].BidID
+	})
+	return bids, nil
+}
+
+func getOpenAuctionID(stub shim.ChaincodeStubInterface, SecurityID string) (string, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(AuctionObjectType, []string{})
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+		auction := Auction{}
+		err = json.Unmarshal(queryResponse.Value, &auction)
+		if err != nil {
+			return "", err
+		}
+		if auction.SecurityID == SecurityID && auction.AuctionStatus == auctionOpen {
+			return auction.AuctionID, nil
+		}
+	}
+	return "", nil
+}
+
+//依投標金額比例分配 Amount，餘數依投標先後補足
+func allotProRata(bids []*AuctionBid, Amount int64) {
+
+	var total int64
+	for _, bid := range bids {
+		total += bid.Amount
+	}
+	if total <= Amount {
+		for _, bid := range bids {
+			bid.AllottedAmount = bid.Amount
+		}
+		return
+	}
+	var allotted int64
+	for _, bid := range bids {
+		bid.AllottedAmount = int64(float64(Amount) * float64(bid.Amount) / float64(total))
+		allotted += bid.AllottedAmount
+	}
+	for _, bid := range bids {
+		if allotted >= Amount {
+			break
+		}
+		extra := bid.Amount - bid.AllottedAmount
+		if extra > Amount-allotted {
+			extra = Amount - allotted
+		}
+		bid.AllottedAmount += extra
+		allotted += extra
+	}
+}
+
+/*
+配售：
+1.非競標全數得標，超過發行額時依比例分配
+2.其餘發行額由競標依價格由高至低得標，最低得標價格之投標依比例分配
+3.單一價格以最低得標價格計價；複數價格之競標以投標價格計價，非競標以競標加權平均得標價格計價
+*/
+func allotAuction(auction *Auction, bids []AuctionBid) AuctionResult {
+
+	result := AuctionResult{}
+	result.ObjectType = AuctionResultObjectType
+	result.AuctionID = auction.AuctionID
+	result.SecurityID = auction.SecurityID
+	result.AllotmentRule = auction.AllotmentRule
+	result.OfferingAmount = auction.OfferingAmount
+
+	var nonCompetitive []*AuctionBid
+	var competitive []*AuctionBid
+	for key, val := range bids {
+		bids[key].AllottedAmount = 0
+		bids[key].AllottedPrice = 0
+		bids[key].Cost = 0
+		if val.BidType == bidNonCompetitive {
+			nonCompetitive = append(nonCompetitive, &bids[key])
+			result.NonCompetitiveAmount += val.Amount
+		} else {
+			competitive = append(competitive, &bids[key])
+			result.CompetitiveAmount += val.Amount
+		}
+	}
+	sort.SliceStable(competitive, func(i, j int) bool {
+		return competitive[i].Price > competitive[j].Price
+	})
+
+	Remaining := auction.OfferingAmount
+	allotProRata(nonCompetitive, Remaining)
+	for _, bid := range nonCompetitive {
+		Remaining -= bid.AllottedAmount
+	}
+	var AcceptedAmount int64
+	var AcceptedValue float64
+	i := 0
+	for i < len(competitive) && Remaining > 0 {
+		j := i
+		for j < len(competitive) && competitive[j].Price == competitive[i].Price {
+			j = j + 1
+		}
+		allotProRata(competitive[i:j], Remaining)
+		for _, bid := range competitive[i:j] {
+			Remaining -= bid.AllottedAmount
+			AcceptedAmount += bid.AllottedAmount
+			AcceptedValue += float64(bid.AllottedAmount) * bid.Price
+		}
+		result.StopPrice = competitive[i].Price
+		i = j
+	}
+	result.AveragePrice = parPrice
+	if AcceptedAmount > 0 {
+		result.AveragePrice = round(AcceptedValue/float64(AcceptedAmount), 4)
+	} else {
+		result.StopPrice = parPrice
+	}
+
+	for key, val := range bids {
+		if val.AllottedAmount == 0 {
+			bids[key].BidStatus = bidRejected
+			continue
+		}
+		if val.AllottedAmount < val.Amount {
+			bids[key].BidStatus = bidPartial
+		} else {
+			bids[key].BidStatus = bidAllotted
+		}
+		if auction.AllotmentRule == auctionUniform {
+			bids[key].AllottedPrice = result.StopPrice
+		} else if val.BidType == bidNonCompetitive {
+			bids[key].AllottedPrice = result.AveragePrice
+		} else {
+			bids[key].AllottedPrice = val.Price
+		}
+		bids[key].Cost = int64(round(float64(val.AllottedAmount)*bids[key].AllottedPrice/100, 0))
+		result.AllottedAmount += val.AllottedAmount
+		result.TotalCost += bids[key].Cost
+	}
+	result.Bids = bids
+	return result
+}
+
+//客戶帳號的款項為其各持有部位 SecurityAmount 的合計，帳號不存在時回傳 errMsg
+func getAccountCash(stub shim.ChaincodeStubInterface, AccountID string) (int64, string, error) {
+
+	_, err := getAccountStructFromID(stub, AccountID)
+	if err != nil {
+		return 0, err.Error(), nil
+	}
+	positions, err := getAccountPositions(stub, AccountID)
+	if err != nil {
+		return 0, "", err
+	}
+	var Cash int64
+	for _, val := range positions {
+		Cash += val.SecurityAmount
+	}
+	return Cash, "", nil
+}
+
+//依配售結果檢核各客戶帳號的款項(同一帳號累計)，回傳款項不足的 BidID 及原因。
+//新發行的債券尚無持有部位，款項以帳號的各持有部位計算，不以該債券的部位檢核
+func getUncoveredBids(stub shim.ChaincodeStubInterface, bids []AuctionBid) (map[string]string, error) {
+
+	uncovered := map[string]string{}
+	Cash := map[string]int64{}
+	Costs := map[string]int64{}
+	for _, val := range bids {
+		if val.AllottedAmount == 0 {
+			continue
+		}
+		if _, ok := Cash[val.AccountID]; ok != true {
+			AccountCash, errMsg, err := getAccountCash(stub, val.AccountID)
+			if err != nil {
+				return nil, err
+			}
+			if errMsg != "" {
+				uncovered[val.BidID] = errMsg
+				continue
+			}
+			Cash[val.AccountID] = AccountCash
+		}
+		Cost := Costs[val.AccountID] + val.Cost
+		if Cost > Cash[val.AccountID] {
+			uncovered[val.BidID] = fmt.Sprintf("Error: Amount: (%d)  > SecurityAmount: (%d)", Cost, Cash[val.AccountID])
+			continue
+		}
+		Costs[val.AccountID] = Cost
+	}
+	return uncovered, nil
+}
+
+//得標券數撥入 Position 並扣款，同時記錄總計數 delta
+func creditAuctionBid(stub shim.ChaincodeStubInterface, timeline []SecurityTerms, bid *AuctionBid, SecurityID string, Today string) error {
+
+	BankCode := SubString(bid.AccountID, 0, 3)
+	position, err := getPositionStruct(stub, bid.AccountID, SecurityID)
+	if err != nil {
+		position = newPosition(bid.AccountID, BankCode, SecurityID)
+	}
+	position.Balance += bid.AllottedAmount
+	position.Position += bid.AllottedAmount
+	position.PendingBalance += bid.AllottedAmount
+	position.OwnedAmount += bid.AllottedAmount
+	position.SecurityAmount -= bid.Cost
+	setPositionInterest(timeline, position, Today)
+	err = putPositionStruct(stub, position)
+	if err != nil {
+		return err
+	}
+	err = updateBankTotals(stub, bid.AccountID, SecurityID, bid.AccountID, bid.AllottedAmount, bid.Cost, false)
+	if err != nil {
+		return err
+	}
+	return putTotalDelta(stub, reconcileScopeSecurity, BankCode, SecurityID, bid.AllottedAmount, bid.AllottedAmount)
+}
+
+//第 6 個參數為增額發行的發行次序(見 SecurityTranche.go)，標售額不可超過該次尚未配售的發行額
+//peer chaincode invoke -n mycc -c '{"Args":["openAuction","A07103","10000000000","2018/03/01 12:00:00","UNIFORM","BANKCBC"]}' -C myc
+//peer chaincode invoke -n mycc -c '{"Args":["openAuction","A07103","10000000000","2018/06/01 12:00:00","MULTIPLE","BANKCBC","2"]}' -C myc
+func (s *SmartContract) openAuction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := getTxTime(APIstub).Format(timelayout2)
+
+	var err error
+	var TrancheNo int
+	if len(args) == 6 {
+		if args[5] != "" {
+			TrancheNo, err = strconv.Atoi(args[5])
+			if err != nil || TrancheNo <= 0 {
+				return errorResponse(codeArgumentFormat, "TrancheNo must be a positive integer")
+			}
+		}
+		args = args[:5]
+	}
+	err = checkArgArrayLength(args, 5)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[4]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	SecurityID := strings.ToUpper(args[0])
+	OfferingAmount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || OfferingAmount <= 0 {
+		return errorResponse(codeArgumentFormat, "OfferingAmount must be a positive integer")
+	}
+	_, err = time.Parse(timelayout2, args[2])
+	if err != nil {
+		return errorResponse(codeArgumentFormat, "CloseTime must be a YYYY/MM/DD HH:MM:SS string")
+	}
+	if args[2] <= TimeNow2 {
+		return errorResponse(codeAuctionClosed, "CloseTime must be later than now: "+args[2])
+	}
+	AllotmentRule := strings.ToUpper(args[3])
+	if AllotmentRule != auctionUniform && AllotmentRule != auctionMultiple {
+		return errorResponse(codeArgumentFormat, "AllotmentRule must be UNIFORM or MULTIPLE")
+	}
+
+	security, err := getSecurityStructFromID(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := checkSecurityStatus(security, securityOpAuction); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+	if OfferingAmount > security.Balance {
+		return errorResponse(codeArgumentFormat, "OfferingAmount must not be greater than the unissued Balance "+strconv.FormatInt(security.Balance, 10))
+	}
+	if TrancheNo > 0 {
+		tranches, err := getSecurityTranches(APIstub, security)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		tranche, err := findSecurityTranche(tranches, TrancheNo)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		if OfferingAmount > tranche.Amount-tranche.AllottedAmount {
+			return errorResponse(codeArgumentFormat, "OfferingAmount must not be greater than the unallotted Amount of tranche "+strconv.FormatInt(tranche.Amount-tranche.AllottedAmount, 10))
+		}
+	}
+	OpenAuctionID, err := getOpenAuctionID(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if OpenAuctionID != "" {
+		return errorResponse(codeAlreadyExists, "Auction "+OpenAuctionID+" of "+SecurityID+" is already open")
+	}
+
+	auction := Auction{}
+	auction.AuctionID = APIstub.GetTxID()
+	auction.SecurityID = SecurityID
+	auction.OfferingAmount = OfferingAmount
+	auction.CloseTime = args[2]
+	auction.AllotmentRule = AllotmentRule
+	auction.TrancheNo = TrancheNo
+	auction.AuctionStatus = auctionOpen
+	auction.CreateTime = TimeNow2
+	err = putAuctionStruct(APIstub, &auction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(auction)
+}
+
+//非競標之 Price 為空字串
+//peer chaincode invoke -n mycc -c '{"Args":["submitBid","<AuctionID>","002000000001","C","1000000000","99.85","BANK002"]}' -C myc
+//peer chaincode invoke -n mycc -c '{"Args":["submitBid","<AuctionID>","004000000001","N","100000000","","BANK004"]}' -C myc
+func (s *SmartContract) submitBid(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := getTxTime(APIstub).Format(timelayout2)
+
+	err := checkArgArrayLength(args, 6)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "AuctionID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
+	}
+	AccountID := args[1]
+	BankID := strings.ToUpper(args[5])
+	if BankID != "BANK"+SubString(AccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "Only BANK"+SubString(AccountID, 0, 3)+" can bid for account "+AccountID)
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	_, err = getAccountStructFromID(APIstub, AccountID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	BidType := strings.ToUpper(args[2])
+	if BidType != bidCompetitive && BidType != bidNonCompetitive {
+		return errorResponse(codeArgumentFormat, "BidType must be C or N")
+	}
+	Amount, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || Amount <= 0 {
+		return errorResponse(codeArgumentFormat, "Amount must be a positive integer")
+	}
+	var Price float64
+	if BidType == bidCompetitive {
+		Price, err = strconv.ParseFloat(args[4], 64)
+		if err != nil || Price <= 0 {
+			return errorResponse(codeArgumentFormat, "Price must be a positive number for a competitive bid")
+		}
+	} else if args[4] != "" {
+		return errorResponse(codeArgumentFormat, "Price must be empty for a non-competitive bid")
+	}
+
+	auction, err := getAuctionStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if auction.AuctionStatus != auctionOpen || TimeNow2 > auction.CloseTime {
+		return errorResponse(codeAuctionClosed, "Auction "+auction.AuctionID+" closed at "+auction.CloseTime)
+	}
+	security, err := getSecurityStructFromID(APIstub, auction.SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := checkSecurityStatus(security, securityOpAuction); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+
+	bid := AuctionBid{}
+	bid.AuctionID = auction.AuctionID
+	bid.BidID = APIstub.GetTxID()
+	bid.BankID = BankID
+	bid.AccountID = AccountID
+	bid.BidType = BidType
+	bid.Amount = Amount
+	bid.Price = Price
+	bid.BidStatus = bidSubmitted
+	bid.CreateTime = TimeNow2
+	err = putAuctionBidStruct(APIstub, &bid)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(bid)
+}
+
+//截止時間後由 CBC 執行配售
+//peer chaincode invoke -n mycc -c '{"Args":["closeAuction","<AuctionID>","BANKCBC"]}' -C myc
+func (s *SmartContract) closeAuction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow := getTxTime(APIstub).Format(timelayout)
+	TimeNow2 := getTxTime(APIstub).Format(timelayout2)
+	Today := SubString(TimeNow, 0, 8)
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "AuctionID must be a non-empty string")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	auction, err := getAuctionStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if auction.AuctionStatus != auctionOpen {
+		return errorResponse(codeInvalidStatus, "AuctionStatus of "+auction.AuctionID+" is "+auction.AuctionStatus)
+	}
+	if TimeNow2 <= auction.CloseTime {
+		return errorResponse(codeAuctionNotClosed, "Auction "+auction.AuctionID+" closes at "+auction.CloseTime)
+	}
+
+	security, err := getSecurityStructFromID(APIstub, auction.SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := checkSecurityStatus(security, securityOpAuction); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+	timeline, err := getSecurityTermsTimeline(APIstub, security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	bids, err := getAuctionBids(APIstub, auction.AuctionID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if auction.OfferingAmount > security.Balance {
+		auction.OfferingAmount = security.Balance
+	}
+
+	//款項不足的投標不予配售，排除後以其餘投標重新配售
+	var rejected []AuctionBid
+	result := allotAuction(auction, bids)
+	for {
+		uncovered, err := getUncoveredBids(APIstub, result.Bids)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if len(uncovered) == 0 {
+			break
+		}
+		var covered []AuctionBid
+		for _, val := range result.Bids {
+			if reason, ok := uncovered[val.BidID]; ok == true {
+				val.AllottedAmount = 0
+				val.AllottedPrice = 0
+				val.Cost = 0
+				val.BidStatus = bidRejected
+				val.RejectReason = reason
+				rejected = append(rejected, val)
+				continue
+			}
+			covered = append(covered, val)
+		}
+		result = allotAuction(auction, covered)
+	}
+	for _, val := range rejected {
+		if val.BidType == bidNonCompetitive {
+			result.NonCompetitiveAmount += val.Amount
+		} else {
+			result.CompetitiveAmount += val.Amount
+		}
+		result.Bids = append(result.Bids, val)
+	}
+	for key, val := range result.Bids {
+		if val.AllottedAmount > 0 {
+			err = creditAuctionBid(APIstub, timeline, &result.Bids[key], auction.SecurityID, Today)
+			if err != nil {
+				return errorResponse(codeStateError, err.Error())
+			}
+		}
+		err = putAuctionBidStruct(APIstub, &result.Bids[key])
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+
+	if auction.TrancheNo > 0 {
+		tranches, err := getSecurityTranches(APIstub, security)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		tranche, err := findSecurityTranche(tranches, auction.TrancheNo)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		tranche.AllottedAmount += result.AllottedAmount
+		tranche.AuctionIDs = append(tranche.AuctionIDs, auction.AuctionID)
+		err = putSecurityTranche(APIstub, tranche)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+
+	security.Balance -= result.AllottedAmount
+	//已公告的債券於第一次配售後發行
+	if security.SecurityStatus == securityAnnounced && result.AllottedAmount > 0 {
+		security.SecurityStatus = securityIssued
+	}
+	securityAsBytes, err := json.Marshal(security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(security.SecurityID, securityAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	auction.AuctionStatus = auctionAllotted
+	auction.AllottedAmount = result.AllottedAmount
+	auction.StopPrice = result.StopPrice
+	err = putAuctionStruct(APIstub, auction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	resultKey, err := APIstub.CreateCompositeKey(AuctionResultObjectType, []string{auction.AuctionID})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	result.CreateTime = TimeNow2
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(resultKey, resultAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(result)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryAuction","<AuctionID>"]}' -C myc
+func (s *SmartContract) queryAuction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	auction, err := getAuctionStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	return dataResponse(auction)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryAuctionBids","<AuctionID>"]}' -C myc
+func (s *SmartContract) queryAuctionBids(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	bids, err := getAuctionBids(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(bids)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryAuctionResult","<AuctionID>"]}' -C myc
+func (s *SmartContract) queryAuctionResult(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	resultKey, err := APIstub.CreateCompositeKey(AuctionResultObjectType, []string{args[0]})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	resultAsBytes, err := APIstub.GetState(resultKey)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if resultAsBytes == nil {
+		return errorResponse(codeNotFound, "Failed to find AuctionResult "+args[0])
+	}
+	result := AuctionResult{}
+	err = json.Unmarshal(resultAsBytes, &result)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(result)
+}
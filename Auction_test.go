@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//以指定的交易時間執行一筆交易
+func invokeAt(stub *shim.MockStub, TXID string, txTime time.Time, fn func(shim.ChaincodeStubInterface, []string) peer.Response, args ...string) peer.Response {
+
+	stub.MockTransactionStart(TXID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: txTime.Unix()}
+	response := fn(newTxStub(stub), args)
+	stub.MockTransactionEnd(TXID)
+	return response
+}
+
+func mustInvokeAt(t *testing.T, stub *shim.MockStub, TXID string, txTime time.Time, fn func(shim.ChaincodeStubInterface, []string) peer.Response, args ...string) {
+
+	response := invokeAt(stub, TXID, txTime, fn, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%s: %s", TXID, response.Message)
+	}
+}
+
+//新發行的債券尚無任何持有部位，投標款項以帳號其他部位的款項檢核
+func TestCloseAuctionOnNewSecurity(t *testing.T) {
+
+	s := new(SmartContract)
+	stub := shim.NewMockStub("mycc", s)
+	openTime := time.Date(2018, 3, 1, 9, 0, 0, 0, time.Local)
+	closeTime := time.Date(2018, 3, 1, 12, 0, 0, 0, time.Local)
+
+	mustInvokeAt(t, stub, "TX01", openTime, s.initBank, "BANKCBC", "BANK CBC", "CBC")
+	mustInvokeAt(t, stub, "TX02", openTime, s.initBank, "BANK002", "BANK 002", "002")
+	mustInvokeAt(t, stub, "TX03", openTime, s.initAccount, "002000000001", "002", "BANK002", "CUST001", "00001", "A06101", "1000000000", "0", "0", "0")
+	mustInvokeAt(t, stub, "TX04", openTime, s.initAccount, "002000000002", "002", "BANK002", "CUST002", "00001", "A06101", "1000", "0", "0", "0")
+	mustInvokeAt(t, stub, "TX05", openTime, s.createSecurity, "A07103", "107A03", "2018/03/02", "2028/03/02", "1", "10", "25000000000", "ANNOUNCED")
+
+	AuctionID := "TX06"
+	mustInvokeAt(t, stub, AuctionID, openTime, s.openAuction, "A07103", "200000000", closeTime.Format(timelayout2), "UNIFORM", "BANKCBC")
+	mustInvokeAt(t, stub, "TX07", openTime.Add(time.Hour), s.submitBid, AuctionID, "002000000001", "C", "100000000", "99.85", "BANK002")
+	mustInvokeAt(t, stub, "TX08", openTime.Add(time.Hour), s.submitBid, AuctionID, "002000000002", "C", "100000000", "99.90", "BANK002")
+
+	//截止時間以交易時間判斷
+	response := invokeAt(stub, "TX09", closeTime, s.closeAuction, AuctionID, "BANKCBC")
+	if response.Status == shim.OK {
+		t.Fatalf("closeAuction must fail at CloseTime")
+	}
+	mustInvokeAt(t, stub, "TX10", closeTime.Add(time.Minute), s.closeAuction, AuctionID, "BANKCBC")
+
+	bids, err := getAuctionBids(stub, AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bids) != 2 {
+		t.Fatalf("got %d bids, want 2", len(bids))
+	}
+	for _, val := range bids {
+		if val.AccountID == "002000000001" && (val.BidStatus != bidAllotted || val.AllottedAmount != 100000000) {
+			t.Errorf("bid of %s: %s %d, want %s 100000000", val.AccountID, val.BidStatus, val.AllottedAmount, bidAllotted)
+		}
+		if val.AccountID == "002000000002" && (val.BidStatus != bidRejected || val.RejectReason == "") {
+			t.Errorf("bid of %s: %s, want %s with a RejectReason", val.AccountID, val.BidStatus, bidRejected)
+		}
+	}
+	position, err := getPositionStruct(stub, "002000000001", "A07103")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Balance != 100000000 || position.SecurityAmount != -99850000 {
+		t.Errorf("position: Balance %d, SecurityAmount %d, want 100000000, -99850000", position.Balance, position.SecurityAmount)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const AuditRecordObjectType string = "AuditRecord"
+
+//audit 紀錄 key 為 AUDIT+YYYYMMDDHHMMSS+TxID，以 GetStateByRange 依時間查詢
+const AuditRecordPrefix string = "AUDIT"
+
+//參數摘要中以 SHA256 取代的敏感欄位(參數位置)
+var auditSensitiveArgs = map[string][]int{
+	"initAccount":        {3, 4, 6, 7}, //CustName, CustType, SecurityAmount, Balance
+	"updateAccount":      {3, 4, 6, 7}, //CustName, CustType, SecurityAmount, Balance
+	"updateAsset":        {2, 3},       //SecurityAmount, Balance
+	"updateAssetBalance": {3},          //Balance
+	"submitBid":          {3, 4},       //Amount, Price
+	"pledgeSecurity":     {4},          //Purpose
+	"submitChange":       {2},          //原管理交易參數
+}
+
+type AuditRecord struct {
+	ObjectType   string   `json:"docType"`      // default set to "AuditRecord"
+	TxID         string   `json:"TxID"`         // Fabric TxID
+	Function     string   `json:"Function"`     // 交易名稱
+	MSPID        string   `json:"MSPID"`        // 呼叫者 MSP
+	Subject      string   `json:"Subject"`      // 呼叫者憑證 Subject
+	AffectedKeys []string `json:"AffectedKeys"` // 寫入或刪除的 key
+	ArgsSummary  []string `json:"ArgsSummary"`  // 參數摘要(敏感欄位為 SHA256)
+	AuditTime    string   `json:"AuditTime"`    // 交易提案時間(YYYYMMDDHHMMSS)
+}
+
+/*
+1.Fabric 交易序號
+2.交易名稱
+3.呼叫者 MSP
+4.呼叫者憑證 Subject
+5.異動的 key
+6.參數摘要
+7.交易提案時間
+*/
+
+//audit 紀錄只能由 putAuditRecord 寫入，不可以 put / remove 異動
+func isAuditKey(key string) bool {
+
+	return strings.HasPrefix(key, AuditRecordPrefix) || strings.HasPrefix(key, "\x00"+AuditRecordObjectType+"\x00")
+}
+
+func getAuditArgsSummary(function string, args []string) []string {
+
+	summary := append([]string(nil), args...)
+	for _, i := range auditSensitiveArgs[function] {
+		if i < len(summary) {
+			summary[i] = "SHA256:" + getSHA256(summary[i])
+		}
+	}
+	return summary
+}
+
+//composite key 以 ObjectType~attr1~attr2 表示
+func getAuditKeyName(stub shim.ChaincodeStubInterface, key string) string {
+
+	if strings.HasPrefix(key, "\x00") != true {
+		return key
+	}
+	objectType, attributes, err := stub.SplitCompositeKey(key)
+	if err != nil {
+		return key
+	}
+	return strings.Join(append([]string{objectType}, attributes...), "~")
+}
+
+//由 Invoke 於交易有寫入或刪除時呼叫，每筆 Fabric 交易一筆紀錄
+func putAuditRecord(stub *txStub, function string, args []string) error {
+
+	if len(stub.writes) == 0 && len(stub.deletes) == 0 {
+		return nil
+	}
+	record := AuditRecord{}
+	record.ObjectType = AuditRecordObjectType
+	record.TxID = stub.GetTxID()
+	record.Function = function
+	record.MSPID, _ = cid.GetMSPID(stub)
+	cert, err := cid.GetX509Certificate(stub)
+	if err == nil && cert != nil {
+		record.Subject = cert.Subject.String()
+	}
+	for key := range stub.writes {
+		record.AffectedKeys = append(record.AffectedKeys, getAuditKeyName(stub, key))
+	}
+	for key := range stub.deletes {
+		record.AffectedKeys = append(record.AffectedKeys, getAuditKeyName(stub, key))
+	}
+	sort.Strings(record.AffectedKeys)
+	record.ArgsSummary = getAuditArgsSummary(function, args)
+	record.AuditTime = time.Now().Format(timelayout)
+	ts, err := stub.GetTxTimestamp()
+	if err == nil && ts != nil {
+		record.AuditTime = time.Unix(ts.Seconds, 0).Format(timelayout)
+	}
+
+	auditKey := AuditRecordPrefix + record.AuditTime + record.TxID
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(auditKey, recordAsBytes)
+}
+
+func isAuditKeyMatched(record AuditRecord, key string) bool {
+
+	for _, val := range record.AffectedKeys {
+		if val == key {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+peer chaincode query -n mycc -c '{"Args":["queryAuditRecords","","","securityTransfer","20180415000000","20180415235959"]}' -C myc
+peer chaincode query -n mycc -c '{"Args":["queryAuditRecords","Org1MSP","A07103","","",""]}' -C myc
+
+參數依序為呼叫者(MSP 或 Subject)、key、交易名稱、起訖時間(YYYYMMDDHHMMSS)，空字串表示不篩選
+*/
+func (s *SmartContract) queryAuditRecords(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 5)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	Caller := args[0]
+	Key := args[1]
+	Function := args[2]
+	FromTime := args[3]
+	ToTime := args[4]
+
+	startKey := AuditRecordPrefix + FromTime
+	endKey := AuditRecordPrefix + "~"
+	if ToTime != "" {
+		endKey = AuditRecordPrefix + ToTime + "~"
+	}
+	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []AuditRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		record := AuditRecord{}
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if Caller != "" && record.MSPID != Caller && strings.Contains(record.Subject, Caller) != true {
+			continue
+		}
+		if Key != "" && isAuditKeyMatched(record, Key) != true {
+			continue
+		}
+		if Function != "" && record.Function != Function {
+			continue
+		}
+		records = append(records, record)
+	}
+	return dataResponse(records)
+}
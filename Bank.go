@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,8 +20,8 @@ type Bank struct {
 	BankID       string      `json:"BankID"`       // BANK002,BANK004,BANK005,BANKCBC
 	BankName     string      `json:"BankName"`     // BANK 002, BANK 004,BANK 005, BANK CBC
 	BankCode     string      `json:"BankCode"`     // BankCode (002,004,005,999)
-	BankTotals   []BankTotal `json:"BankTotals"`   //清算銀行總計數
-	BankAccounts []string    `json:"BankAccounts"` //清算銀行下客戶帳號
+	BankTotals   []BankTotal `json:"BankTotals"`   //清算銀行總計數(checkpoint)
+	BankAccounts []string    `json:"BankAccounts"` //清算銀行下客戶帳號(舊資料,新帳號記於 BankAccount index)
 }
 
 /*
@@ -62,16 +61,16 @@ func (s *SmartContract) initBank(
 	// BankID, BankName, Status
 	err := checkArgArrayLength(args, 3)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("BankID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("BankName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankName must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("BankCode must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankCode must be a non-empty string")
 	}
 
 	BankID := args[0]
@@ -80,12 +79,12 @@ func (s *SmartContract) initBank(
 
 	BankAsBytes, err := stub.GetState(BankID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	} else if BankAsBytes != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This Bank already exists (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeAlreadyExists, errMsg)
 	}
 
 	Bank := Bank{}
@@ -96,12 +95,12 @@ func (s *SmartContract) initBank(
 
 	BankAsBytes, err = json.Marshal(Bank)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	err = stub.PutState(BankID, BankAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(BankAsBytes)
@@ -115,16 +114,16 @@ func (s *SmartContract) updateBank(
 	// BankID, BankName, Status
 	err := checkArgArrayLength(args, 3)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("BankID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("BankName must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankName must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("BankCode must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "BankCode must be a non-empty string")
 	}
 	BankID := args[0]
 	BankName := args[1]
@@ -132,12 +131,12 @@ func (s *SmartContract) updateBank(
 
 	BankAsBytes, err := stub.GetState(BankID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	} else if BankAsBytes == nil {
 		errMsg := fmt.Sprintf(
 			"Error: This Bank does not exist (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
 	Bank := Bank{}
@@ -148,12 +147,12 @@ func (s *SmartContract) updateBank(
 
 	BankAsBytes, err = json.Marshal(Bank)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	err = stub.PutState(BankID, BankAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	return shim.Success(nil)
 }
@@ -164,7 +163,7 @@ func (s *SmartContract) deleteBank(
 
 	err := checkArgArrayLength(args, 1)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 
 	BankID := args[0]
@@ -180,17 +179,17 @@ func (s *SmartContract) deleteBank(
 		errMsg := fmt.Sprintf(
 			"Error: Failed to get state for Bank (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeStateError, errMsg)
 	} else if valAsbytes == nil {
 		errMsg := fmt.Sprintf(
 			"Error: Bank does not exist (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
 	err = stub.DelState(BankID)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errorResponse(codeStateError, "Failed to delete state:"+err.Error())
 	}
 	return shim.Success(nil)
 }
@@ -202,7 +201,7 @@ func (s *SmartContract) verifyBankList(
 
 	err := checkArgArrayLength(args, 1)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 
 	BankID := args[0]
@@ -212,28 +211,15 @@ func (s *SmartContract) verifyBankList(
 		errMsg := fmt.Sprintf(
 			"Error: Failed to get state for BankID (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeStateError, errMsg)
 	} else if valAsbytes == nil {
 		errMsg := fmt.Sprintf(
 			"Error: BankID does not exist (%s)",
 			BankID)
-		return shim.Error(errMsg)
+		return errorResponse(codeNotFound, errMsg)
 	}
 
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"BankID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(BankID)
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"Value\":")
-	buffer.WriteString(string(valAsbytes))
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
-
+	return dataResponse(BankValue{BankID, getRecordValue(valAsbytes)})
 }
 
 func verifyIdentity(
@@ -256,124 +242,46 @@ func verifyIdentity(
 	return ""
 }
 
-//peer chaincode query -n mycc -c '{"Args":["queryAllBanks", "000" , "ZZZ"]}' -C myc
-func (s *SmartContract) queryAllBanks(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-	startKey := args[0]
-	endKey := args[1]
+func verifyAdminIdentity(
+	stub shim.ChaincodeStubInterface,
+	adminID string) string {
 
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
-	if err != nil {
-		return shim.Error(err.Error())
+	if strings.ToUpper(adminID) != "BANK"+AdminBankID {
+		errMsg := fmt.Sprintf(
+			"Error: Only BANK%s can execute this function (%s)",
+			AdminBankID,
+			adminID)
+		return errMsg
 	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
 
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as/is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
+	return verifyIdentity(stub, "BANK"+AdminBankID)
+}
 
-	fmt.Printf("%s", buffer.String())
+//peer chaincode query -n mycc -c '{"Args":["queryAllBanks", "000" , "ZZZ"]}' -C myc
+func (s *SmartContract) queryAllBanks(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryForBank", "001"]}' -C myc
 func (s *SmartContract) getHistoryForBank(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	BankID := args[0]
 
 	fmt.Printf("- start getHistoryForBank: %s\n", BankID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(BankID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForBank returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, BankID, "")
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryTXIDForBank","BANK002","a4723f60d5c85d29a2107382fb8e3c8c1624924b970efa04f313727a0dfaa0ff"]}' -C myc
 func (s *SmartContract) getHistoryTXIDForBank(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	BankID := args[0]
@@ -382,62 +290,13 @@ func (s *SmartContract) getHistoryTXIDForBank(APIstub shim.ChaincodeStubInterfac
 	fmt.Printf("- start getHistoryTXIDForBank: %s\n", BankID)
 	fmt.Printf("- start getHistoryTXIDForBank: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(BankID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		if response.TxId == TXID {
-			buffer.WriteString("{\"TxId\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(response.TxId)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Value\":")
-			// if it was a delete operation on given key, then we need to set the
-			//corresponding value null. Else, we will write the response.Value
-			//as-is (as the Value itself a JSON marble)
-			if response.IsDelete {
-				buffer.WriteString("null")
-			} else {
-				buffer.WriteString(string(response.Value))
-			}
-
-			buffer.WriteString(", \"Timestamp\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-			buffer.WriteString("\"")
-
-			buffer.WriteString(", \"IsDelete\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatBool(response.IsDelete))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-
-			break
-		}
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryTXIDForBank returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, BankID, TXID)
 }
 
 func (s *SmartContract) queryAllBankKeys(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Keys operation must include two arguments, startKey and endKey")
+		return errorResponse(codeArgumentCount, "Keys operation must include two arguments, startKey and endKey")
 	}
 	startKey := args[0]
 	endKey := args[1]
@@ -450,11 +309,11 @@ func (s *SmartContract) queryAllBankKeys(APIstub shim.ChaincodeStubInterface, ar
 
 	keysIter, err := APIstub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+		return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 	}
 	defer keysIter.Close()
 
-	var keys []string
+	keys := []string{}
 	for keysIter.HasNext() {
 		//if sleeptime is specied, take a nap
 		if stime > 0 {
@@ -463,7 +322,7 @@ func (s *SmartContract) queryAllBankKeys(APIstub shim.ChaincodeStubInterface, ar
 
 		response, iterErr := keysIter.Next()
 		if iterErr != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 		}
 		keys = append(keys, response.Key)
 	}
@@ -472,13 +331,7 @@ func (s *SmartContract) queryAllBankKeys(APIstub shim.ChaincodeStubInterface, ar
 		fmt.Printf("key %d contains %s\n", key, value)
 	}
 
-	jsonKeys, err := json.Marshal(keys)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error marshaling JSON: %s", err))
-	}
-
-	return shim.Success(jsonKeys)
-
+	return envelopeResponse(keys, &Pagination{StartKey: startKey, EndKey: endKey, Count: len(keys)}, nil)
 }
 
 func getBankStructFromID(
@@ -501,67 +354,24 @@ func getBankStructFromID(
 	return bank, nil
 }
 
+//總計數以 delta key 記錄，不改寫 Bank 文件(見 Totals.go)
 func updateBankTotals(stub shim.ChaincodeStubInterface, BankID string, SecurityID string, AccountID string, Balance int64, Amount int64, isNegative bool) error {
 	fmt.Printf("updateBankTotals: BankID=%s,SecurityID=%s,AccountID=%s,Balance=%d,Amount=%d\n", BankID, SecurityID, AccountID, Balance, Amount)
-	TimeNow2 := time.Now().Format(timelayout2)
 	newbankid := "BANK" + SubString(BankID, 0, 3)
-	bank, err := getBankStructFromID(stub, newbankid)
+	_, err := getBankStructFromID(stub, newbankid)
 	fmt.Printf("new BankID=%s\n", newbankid)
 	if err != nil {
 		return err
 	}
-	var doflg bool
-	doflg = false
-	var bankTotal BankTotal
-	fmt.Printf("SecurityID=%s\n", SecurityID)
-	for key, val := range bank.BankTotals {
-		fmt.Printf("1.Bkey: %d\n", key)
-		fmt.Printf("2.Bval: %s\n", val)
-		if val.SecurityID == SecurityID {
-			fmt.Printf("3.Bkey: %d\n", key)
-			fmt.Printf("4.Bval: %s\n", val)
-			if isNegative != true {
-				bank.BankTotals[key].TotalBalance += Balance
-				bank.BankTotals[key].TotalAmount += Amount
-			} else if isNegative == true {
-				bank.BankTotals[key].TotalBalance -= Balance
-				bank.BankTotals[key].TotalAmount -= Amount
-			}
-
-			bank.BankTotals[key].UpdateTime = TimeNow2
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
-		bankTotal.SecurityID = SecurityID
-		bankTotal.TotalBalance = Balance
-		bankTotal.TotalAmount = Amount
-		bankTotal.CreateTime = TimeNow2
-		bankTotal.UpdateTime = TimeNow2
-		bank.BankTotals = append(bank.BankTotals, bankTotal)
-	}
-	doflg = false
-	for key, val := range bank.BankAccounts {
-		fmt.Printf("akey1: %d\n", key)
-		fmt.Printf("aval1: %s\n", val)
-
-		if val == AccountID {
-			fmt.Printf("akey2: %d\n", key)
-			fmt.Printf("aval2: %s\n", val)
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
-		bank.BankAccounts = append(bank.BankAccounts, AccountID)
+	if isNegative == true {
+		Balance = -Balance
+		Amount = -Amount
 	}
-
-	bankAsBytes, err := json.Marshal(bank)
+	err = putTotalDelta(stub, reconcileScopeBank, SubString(BankID, 0, 3), SecurityID, Balance, Amount)
 	if err != nil {
 		return err
 	}
-	err = stub.PutState(newbankid, bankAsBytes)
+	err = putBankAccountIndex(stub, BankID, AccountID)
 	if err != nil {
 		return err
 	}
@@ -572,35 +382,13 @@ func updateBankAccounts(stub shim.ChaincodeStubInterface, BankID string, Account
 	fmt.Printf("updateBankAccounts: BankID=%s,AccountID=%s\n", BankID, AccountID)
 
 	newbankid := "BANK" + SubString(BankID, 0, 3)
-	bank, err := getBankStructFromID(stub, newbankid)
+	_, err := getBankStructFromID(stub, newbankid)
 	fmt.Printf("new BankID=%s\n", newbankid)
 	if err != nil {
 		return err
 	}
 
-	var doflg bool
-	doflg = false
-
-	for key, val := range bank.BankAccounts {
-		fmt.Printf("akey1: %d\n", key)
-		fmt.Printf("aval1: %s\n", val)
-
-		if val == AccountID {
-			fmt.Printf("akey2: %d\n", key)
-			fmt.Printf("aval2: %s\n", val)
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
-		bank.BankAccounts = append(bank.BankAccounts, AccountID)
-	}
-
-	bankAsBytes, err := json.Marshal(bank)
-	if err != nil {
-		return err
-	}
-	err = stub.PutState(newbankid, bankAsBytes)
+	err = putBankAccountIndex(stub, BankID, AccountID)
 	if err != nil {
 		return err
 	}
@@ -611,17 +399,16 @@ func updateBankAccounts(stub shim.ChaincodeStubInterface, BankID string, Account
 func (s *SmartContract) queryBankTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	BankAsBytes, _ := APIstub.GetState(args[0])
 	Bank := Bank{}
 	json.Unmarshal(BankAsBytes, &Bank)
-
-	BankTotalsAsBytes, err := json.Marshal(Bank.BankTotals)
+	BankTotals, err := getEffectiveBankTotals(APIstub, &Bank)
 	if err != nil {
-		return shim.Error("Failed to query BankTotals state")
+		return errorResponse(codeStateError, "Failed to query BankTotals state")
 	}
 
-	return shim.Success(BankTotalsAsBytes)
+	return dataResponse(BankTotals)
 }
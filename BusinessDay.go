@@ -0,0 +1,717 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const BusinessDayObjectType string = "BusinessDay"
+const BusinessDayPrefix string = "DAY"
+const OpeningPositionObjectType string = "OpeningPosition"
+const businessDateKey string = "businessdate" //目前營業日(YYYYMMDD)
+const cutOffTimeKey string = "cutofftime"     //截止收件時間(HHMMSS)
+const dayOpening string = "Opening"
+const dayOpen string = "Open"
+const dayCutOff string = "CutOff"
+const dayClosing string = "Closing"
+const dayClosed string = "Closed"
+
+type BusinessDay struct {
+	ObjectType      string            `json:"docType"`         // default set to "BusinessDay"
+	TXKEY           string            `json:"TXKEY"`           //TXDATE(YYYYMMDD)
+	DayStatus       string            `json:"DayStatus"`       // Opening, Open, CutOff, Closing, Closed
+	CutOffTime      string            `json:"CutOffTime"`      //截止收件時間(HHMMSS)
+	Bookmark        int               `json:"Bookmark"`        //日終下一筆待處理的 QueuedTransaction 位置
+	PositionKey     string            `json:"PositionKey"`     //開始營業最後處理的 Position key
+	ReleasedSummary []DayCloseSummary `json:"ReleasedSummary"` //日終取消之交易(依原交易狀態)
+	StatusSummary   []DayCloseSummary `json:"StatusSummary"`   //日終後全部交易(依最終交易狀態)
+	CreateTime      string            `json:"createTime"`
+	UpdateTime      string            `json:"updateTime"`
+}
+
+/*
+1.營業日
+2.營業日狀態
+3.截止收件時間
+4.日終下一筆待處理位置
+5.開始營業最後處理的部位
+6.日終取消之交易統計
+7.日終後全部交易統計
+8.建立時間
+9.更新時間
+*/
+
+type DayCloseSummary struct {
+	TXStatus       string `json:"TXStatus"`
+	TXCount        int64  `json:"TXCount"`
+	Payment        int64  `json:"Payment"`
+	SecurityAmount int64  `json:"SecurityAmount"`
+}
+
+/*
+1.交易狀態
+2.筆數
+3.交易面額合計
+4.交易金額合計
+*/
+
+func getBusinessDayStruct(stub shim.ChaincodeStubInterface, TXKEY string) (*BusinessDay, error) {
+
+	day := &BusinessDay{}
+	dayAsBytes, err := stub.GetState(BusinessDayPrefix + TXKEY)
+	if err != nil {
+		return nil, err
+	} else if dayAsBytes == nil {
+		return nil, nil
+	}
+	err = json.Unmarshal(dayAsBytes, day)
+	if err != nil {
+		return nil, err
+	}
+	return day, nil
+}
+
+func putBusinessDayStruct(stub shim.ChaincodeStubInterface, day *BusinessDay) error {
+
+	dayAsBytes, err := json.Marshal(day)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(BusinessDayPrefix+day.TXKEY, dayAsBytes)
+}
+
+func getCurrentBusinessDate(stub shim.ChaincodeStubInterface) (string, error) {
+
+	ValueAsBytes, err := stub.GetState(businessDateKey)
+	if err != nil {
+		return "", err
+	}
+	return string(ValueAsBytes), nil
+}
+
+//交易提案時間，各背書節點結果一致(同 AuditRecord 的 AuditTime)
+func getTxTime(stub shim.ChaincodeStubInterface) time.Time {
+
+	ts, err := stub.GetTxTimestamp()
+	if err == nil && ts != nil {
+		return time.Unix(ts.Seconds, 0)
+	}
+	return time.Now()
+}
+
+/*
+回傳交易應登錄的營業日(TXKEY)。
+CBC 尚未執行 openBusinessDay 前(未使用營業日，沿用原本以日曆日為佇列的作法)，
+以交易序號中的日期(第 19 碼起 8 碼，早於今日者為準，不可為已日終之日期)登錄，
+使跨午夜送達的交易仍登錄於其交易日期；TXID 不是交易序號格式(例如 Fabric TxID)時以今日為準。
+之後只能登錄於目前為 Open 且未過截止收件時間(依交易提案時間)的營業日，不再使用交易序號中的日期。
+*/
+func getBookingBusinessDate(stub shim.ChaincodeStubInterface, TXID string) (string, string) {
+
+	TimeNow := getTxTime(stub).Format(timelayout)
+	TXKEY, err := getCurrentBusinessDate(stub)
+	if err != nil {
+		return TXKEY, err.Error()
+	}
+	if TXKEY == "" {
+		TXKEY = SubString(TimeNow, 0, 8)
+		TXDAY := SubString(TXID, 18, 8)
+		if _, err := time.Parse("20060102", TXDAY); err == nil && TXDAY < TXKEY {
+			TXKEY = TXDAY
+		}
+		day, err := getBusinessDayStruct(stub, TXKEY)
+		if err != nil {
+			return TXKEY, err.Error()
+		}
+		if day != nil && (day.DayStatus == dayClosing || day.DayStatus == dayClosed) {
+			return TXKEY, "Business day " + TXKEY + " is " + day.DayStatus
+		}
+		return TXKEY, ""
+	}
+
+	day, err := getBusinessDayStruct(stub, TXKEY)
+	if err != nil {
+		return TXKEY, err.Error()
+	}
+	if day == nil || day.DayStatus != dayOpen {
+		return TXKEY, "Business day " + TXKEY + " is not open"
+	}
+	if day.CutOffTime != "" && SubString(TimeNow, 8, 6) >= day.CutOffTime {
+		return TXKEY, "Business day " + TXKEY + " is past the cut-off time " + day.CutOffTime
+	}
+	return TXKEY, ""
+}
+
+//開始營業日時的部位快照
+func putOpeningPosition(stub shim.ChaincodeStubInterface, TXKEY string, position Position) error {
+
+	snapshotKey, err := stub.CreateCompositeKey(OpeningPositionObjectType, []string{TXKEY, position.AccountID, position.SecurityID})
+	if err != nil {
+		return err
+	}
+	positionAsBytes, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(snapshotKey, positionAsBytes)
+}
+
+func addDayCloseSummary(summary []DayCloseSummary, tx Transaction) []DayCloseSummary {
+
+	for key := range summary {
+		if summary[key].TXStatus == tx.TXStatus {
+			summary[key].TXCount += 1
+			summary[key].Payment += tx.Payment
+			summary[key].SecurityAmount += tx.SecurityAmount
+			return summary
+		}
+	}
+	return append(summary, DayCloseSummary{tx.TXStatus, 1, tx.Payment, tx.SecurityAmount})
+}
+
+//Pending 交易於 validateTransaction 時預扣之 PendingBalance 轉回
+func releasePendingBalance(stub shim.ChaincodeStubInterface, SecurityID string, Payment int64, sender string, receiver string) error {
+
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err == nil {
+		senderPosition.PendingBalance += Payment
+		if senderPosition.PendingBalance > senderPosition.Balance {
+			senderPosition.PendingBalance = senderPosition.Balance
+		}
+		err = putPositionStruct(stub, senderPosition)
+		if err != nil {
+			return err
+		}
+	}
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	if err == nil {
+		receiverPosition.PendingBalance -= Payment
+		if receiverPosition.PendingBalance < 0 {
+			receiverPosition.PendingBalance = 0
+		}
+		err = putPositionStruct(stub, receiverPosition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+跨日保留之 Pending 交易及預約交割之 Matched 交易仍保留的賣方券數(AccountID~SecurityID)，
+由比對索引及預約交割索引中的賣方交易(TXType S)累計，openBusinessDay 以 Balance 扣除後作為 PendingBalance。
+*/
+func getOpenReservations(stub shim.ChaincodeStubInterface) (map[string]int64, error) {
+
+	reserved := make(map[string]int64)
+	counted := make(map[string]bool)
+	for _, objectType := range []string{MatchIndexObjectType, SettlementDueIndex} {
+		err := addOpenReservations(stub, objectType, reserved, counted)
+		if err != nil {
+			return reserved, err
+		}
+	}
+	return reserved, nil
+}
+
+func addOpenReservations(stub shim.ChaincodeStubInterface, objectType string, reserved map[string]int64, counted map[string]bool) error {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(compositeKeyParts) == 0 {
+			continue
+		}
+		TXID := compositeKeyParts[len(compositeKeyParts)-1]
+		if counted[TXID] == true {
+			continue
+		}
+		counted[TXID] = true
+		transaction, err := getTransactionStructFromID(stub, TXID)
+		if err != nil || transaction.TXType != "S" {
+			continue
+		}
+		if transaction.TXStatus == "Pending" || transaction.TXStatus == "Matched" {
+			reserved[transaction.TXFrom+"~"+transaction.SecurityID] += transaction.Payment
+		}
+	}
+	return nil
+}
+
+//取消單筆日終交易(含比對成功之另一筆)，回傳被取消的交易
+func closeDayTransaction(stub shim.ChaincodeStubInterface, TXKEY string, TXID string) ([]Transaction, error) {
+
+	var closed []Transaction
+	transaction, err := getTransactionStructFromID(stub, TXID)
+	if err != nil {
+		return closed, err
+	}
+	TXStatus := transaction.TXStatus
+	if TXStatus == "Matched" {
+		//交割日在後之預約交易不取消，留待交割日交割
+		if getTXSettlementDate(*transaction) > TXKEY {
+			return closed, nil
+		}
+		return closeDayMatchedTransaction(stub, TXKEY, transaction)
+	}
+	if TXStatus != "Pending" && TXStatus != "Waiting4Payment" && TXStatus != "PaymentError" {
+		return closed, nil
+	}
+	//仍在跨日比對範圍內的 Pending 交易保留至下一營業日
+	if TXStatus == "Pending" && isCarriedInstruction(stub, TXKEY) == true {
+		return closed, nil
+	}
+
+	MatchedTXID, err := updateEndDayTransactionStatus(stub, TXID)
+	if err != nil {
+		return closed, err
+	}
+	if TXStatus == "Pending" {
+		err = releasePendingBalance(stub, transaction.SecurityID, transaction.Payment, transaction.TXFrom, transaction.TXTo)
+		if err != nil {
+			return closed, err
+		}
+	}
+	err = updateEndDayQueuedTransactionStatus(stub, TXKEY, TXID, MatchedTXID)
+	if err != nil {
+		return closed, err
+	}
+	err = updateEndDayHistoryTransactionStatus(stub, "H"+TXKEY, TXID, MatchedTXID)
+	if err != nil {
+		return closed, err
+	}
+
+	closed = append(closed, *transaction)
+	if MatchedTXID != "" {
+		transaction2, err := getTransactionStructFromID(stub, MatchedTXID)
+		if err == nil {
+			transaction2.TXStatus = TXStatus
+			closed = append(closed, *transaction2)
+		}
+	}
+	return closed, nil
+}
+
+//NET 模式尚未批次交割之 Matched 交易，兩筆皆取消並轉回 PendingBalance
+func closeDayMatchedTransaction(stub shim.ChaincodeStubInterface, TXKEY string, transaction *Transaction) ([]Transaction, error) {
+
+	var closed []Transaction
+	MatchedTXID := transaction.MatchedTXID
+	transaction2, err := getTransactionStructFromID(stub, MatchedTXID)
+	if err != nil {
+		return closed, err
+	}
+	for _, tx := range []*Transaction{transaction, transaction2} {
+		err = updateTransactionStatus(stub, tx.TXID, "Cancelled", tx.MatchedTXID)
+		if err != nil {
+			return closed, err
+		}
+		err = releasePendingBalance(stub, tx.SecurityID, tx.Payment, tx.TXFrom, tx.TXTo)
+		if err != nil {
+			return closed, err
+		}
+		err = updateQueuedTransactionStatus(stub, TXKEY, tx.TXID, "Cancelled")
+		if err != nil {
+			return closed, err
+		}
+		err = updateHistoryTransactionStatus(stub, "H"+TXKEY, tx.TXID, "Cancelled")
+		if err != nil {
+			return closed, err
+		}
+		closed = append(closed, *tx)
+	}
+	return closed, nil
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["openBusinessDay","20180415","500","BANKCBC"]}' -C myc
+
+前一營業日須已日終。依 Position key 順序每次處理 PageSize 筆 Position：PendingBalance 設為 Balance 扣除跨日交易保留的券數(見 getOpenReservations)並寫入開始部位快照，
+記錄最後處理的 key(分頁之間新增或刪除 Position 不會略過或重複其他部位)，重複呼叫直到 DayStatus = Open
+*/
+func (s *SmartContract) openBusinessDay(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "TXKEY must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "PageSize must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	TXKEY := args[0]
+	_, err = time.Parse("20060102", TXKEY)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, "TXKEY must be a YYYYMMDD string")
+	}
+	PageSize, err := strconv.Atoi(args[1])
+	if err != nil || PageSize <= 0 {
+		return errorResponse(codeArgumentFormat, "PageSize must be a positive numeric string")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[2]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+
+	CurrentDate, err := getCurrentBusinessDate(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if CurrentDate != "" && CurrentDate != TXKEY {
+		if TXKEY < CurrentDate {
+			return errorResponse(codeArgumentFormat, "TXKEY must be after the current business day "+CurrentDate)
+		}
+		current, err := getBusinessDayStruct(APIstub, CurrentDate)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if current != nil && current.DayStatus != dayClosed {
+			return errorResponse(codeInvalidStatus, "Business day "+CurrentDate+" must be closed first")
+		}
+	}
+
+	day, err := getBusinessDayStruct(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if day == nil {
+		day = &BusinessDay{}
+		day.ObjectType = BusinessDayObjectType
+		day.TXKEY = TXKEY
+		day.DayStatus = dayOpening
+		day.CreateTime = TimeNow2
+		CutOffAsBytes, err := APIstub.GetState(cutOffTimeKey)
+		if err == nil {
+			day.CutOffTime = string(CutOffAsBytes)
+		}
+	}
+	if day.DayStatus != dayOpening {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is "+day.DayStatus)
+	}
+	day.UpdateTime = TimeNow2
+
+	reserved, err := getOpenReservations(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(PositionObjectType, []string{})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	isDone := true
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if queryResponse.Key <= day.PositionKey {
+			continue
+		}
+		if count >= PageSize {
+			isDone = false
+			break
+		}
+		position := Position{}
+		err = json.Unmarshal(queryResponse.Value, &position)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		//保留跨日交易已占用的券數
+		position.PendingBalance = position.Balance - reserved[position.AccountID+"~"+position.SecurityID]
+		position.UpdateTime = TimeNow2
+		err = putPositionStruct(APIstub, &position)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		err = putOpeningPosition(APIstub, TXKEY, position)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		day.PositionKey = queryResponse.Key
+		count++
+	}
+
+	if isDone == true {
+		day.DayStatus = dayOpen
+		day.PositionKey = ""
+	}
+
+	err = putBusinessDayStruct(APIstub, day)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(businessDateKey, []byte(TXKEY))
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	//開始營業時交割交割日已到之預約交易
+	if day.DayStatus == dayOpen {
+		err = settleDueTransactions(APIstub, TXKEY)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		err = settleDueRepos(APIstub, TXKEY)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	dayAsBytes, err := json.Marshal(day)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(dayAsBytes)
+}
+
+/*
+截止收件時間(HHMMSS)，於 openBusinessDay 時帶入該營業日；空字串表示不設定
+peer chaincode invoke -n mycc -c '{"Args":["setCutOffTime","153000","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) setCutOffTime(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	CutOffTime := args[0]
+	if CutOffTime != "" {
+		_, err = time.Parse("150405", CutOffTime)
+		if err != nil {
+			return errorResponse(codeArgumentFormat, "CutOffTime must be a HHMMSS string")
+		}
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if CutOffTime == "" {
+		err = APIstub.DelState(cutOffTimeKey)
+	} else {
+		err = APIstub.PutState(cutOffTimeKey, []byte(CutOffTime))
+	}
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["cutOffBusinessDay","BANKCBC"]}' -C myc
+
+func (s *SmartContract) cutOffBusinessDay(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 1)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[0]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	TXKEY, err := getCurrentBusinessDate(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	day, err := getBusinessDayStruct(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if day == nil || day.DayStatus != dayOpen {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is not open")
+	}
+	day.DayStatus = dayCutOff
+	day.UpdateTime = time.Now().Format(timelayout2)
+	err = putBusinessDayStruct(APIstub, day)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryOpeningPositions","20180415"]}' -C myc
+//peer chaincode query -n mycc -c '{"Args":["queryOpeningPositions","20180415","004000000001"]}' -C myc
+
+func (s *SmartContract) queryOpeningPositions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(OpeningPositionObjectType, args)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	positions := []Position{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		position := Position{}
+		err = json.Unmarshal(queryResponse.Value, &position)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		positions = append(positions, position)
+	}
+	return dataResponse(positions)
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["closeBusinessDay","20180414","50","BANKCBC"]}' -C myc
+
+每次處理 PageSize 筆當日交易，重複呼叫直到 DayStatus = Closed。
+TXKEY 不可晚於目前營業日(尚未 openBusinessDay 時為今日)，且須為 Open、CutOff 或日終處理中(Closing)
+*/
+func (s *SmartContract) closeBusinessDay(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "TXKEY must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "PageSize must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	TXKEY := args[0]
+	_, err = time.Parse("20060102", TXKEY)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, "TXKEY must be a YYYYMMDD string")
+	}
+	PageSize, err := strconv.Atoi(args[1])
+	if err != nil || PageSize <= 0 {
+		return errorResponse(codeArgumentFormat, "PageSize must be a positive numeric string")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[2]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	CurrentDate, err := getCurrentBusinessDate(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	LatestDate := CurrentDate
+	if LatestDate == "" {
+		LatestDate = SubString(getTxTime(APIstub).Format(timelayout), 0, 8)
+	}
+	if TXKEY > LatestDate {
+		return errorResponse(codeArgumentFormat, "TXKEY must not be after the current business day "+LatestDate)
+	}
+
+	day, err := getBusinessDayStruct(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	//尚未使用營業日時沒有 BusinessDay 紀錄，於日終時建立
+	if day == nil && CurrentDate == "" {
+		day = &BusinessDay{}
+		day.ObjectType = BusinessDayObjectType
+		day.TXKEY = TXKEY
+		day.CreateTime = TimeNow2
+	}
+	if day == nil {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is not open")
+	}
+	if day.DayStatus == dayClosed {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is already closed")
+	}
+	if day.DayStatus != "" && day.DayStatus != dayOpen && day.DayStatus != dayCutOff && day.DayStatus != dayClosing {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is "+day.DayStatus)
+	}
+	day.DayStatus = dayClosing
+	day.UpdateTime = TimeNow2
+
+	var total int
+	queuedTX, err := getQueueStructFromID(APIstub, TXKEY)
+	if err == nil {
+		total = len(queuedTX.TXIDs)
+	}
+	end := day.Bookmark + PageSize
+	if end > total {
+		end = total
+	}
+	for i := day.Bookmark; i < end; i++ {
+		closed, err := closeDayTransaction(APIstub, TXKEY, queuedTX.TXIDs[i])
+		if err != nil {
+			return errorResponse(codeStateError, fmt.Sprintf("%s: %s", queuedTX.TXIDs[i], err.Error()))
+		}
+		for _, tx := range closed {
+			day.ReleasedSummary = addDayCloseSummary(day.ReleasedSummary, tx)
+		}
+	}
+	day.Bookmark = end
+
+	if end >= total {
+		expired, err := closeExpiredInstructions(APIstub, TXKEY)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		for _, tx := range expired {
+			day.ReleasedSummary = addDayCloseSummary(day.ReleasedSummary, tx)
+		}
+		day.StatusSummary = nil
+		if total > 0 {
+			queuedTX, err = getQueueStructFromID(APIstub, TXKEY)
+			if err != nil {
+				return errorResponse(codeNotFound, err.Error())
+			}
+			for _, tx := range queuedTX.Transactions {
+				day.StatusSummary = addDayCloseSummary(day.StatusSummary, tx)
+			}
+		}
+		day.DayStatus = dayClosed
+	}
+
+	err = putBusinessDayStruct(APIstub, day)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	dayAsBytes, err := json.Marshal(day)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(dayAsBytes)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryBusinessDay","20180414"]}' -C myc
+
+func (s *SmartContract) queryBusinessDay(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	day, err := getBusinessDayStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if day == nil {
+		return errorResponse(codeNotFound, "BusinessDay does not exist: "+args[0])
+	}
+	return dataResponse(day)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+錯誤及交易說明代碼。
+peer.Response 的錯誤一律為 JSON：{"code","message","messageZh","detail"}，
+Transaction 的 TXMemo 及 TXErrMsg 存放代碼，原始英文說明存放於 TXErrDetail。
+*/
+
+//一般錯誤
+const codeArgumentCount string = "ARGUMENT_COUNT"
+const codeArgumentEmpty string = "ARGUMENT_EMPTY"
+const codeArgumentFormat string = "ARGUMENT_FORMAT"
+const codeNotFound string = "NOT_FOUND"
+const codeAlreadyExists string = "ALREADY_EXISTS"
+const codeUnauthorized string = "UNAUTHORIZED"
+const codeInvalidStatus string = "INVALID_STATUS"
+const codeDualAuthRequired string = "DUAL_AUTH_REQUIRED"
+const codeBusinessDayClosed string = "BUSINESS_DAY_CLOSED"
+const codeStateError string = "STATE_ERROR"
+const codeInvalidFunction string = "INVALID_FUNCTION"
+const codeInternalError string = "INTERNAL_ERROR"
+
+//交易錯誤
+const codeQueueNotFound string = "QUEUE_NOT_FOUND"
+const codeDuplicateMatch string = "DUPLICATE_MATCH"
+const codeNegativeBalance string = "NEGATIVE_BALANCE"
+const codeSettlementDateInvalid string = "SETTLEMENT_DATE_INVALID"
+
+//債券主檔錯誤
+const codeSecurityIDFormat string = "SECURITY_ID_FORMAT"
+const codeSecurityDateInvalid string = "SECURITY_DATE_INVALID"
+const codeInterestRateRange string = "INTEREST_RATE_OUT_OF_RANGE"
+const codeSecurityTermMismatch string = "SECURITY_TERM_MISMATCH"
+const codeTotalBelowHoldings string = "TOTAL_AMOUNT_BELOW_HOLDINGS"
+const codeSecurityStatusNotAllowed string = "SECURITY_STATUS_NOT_ALLOWED"
+const codeSecurityStatusTransition string = "SECURITY_STATUS_TRANSITION"
+
+//標售錯誤
+const codeAuctionClosed string = "AUCTION_CLOSED"
+const codeAuctionNotClosed string = "AUCTION_NOT_CLOSED"
+
+//緊急暫停錯誤
+const codeTradingHalted string = "TRADING_HALTED"
+
+//質押錯誤
+const codeInsufficientFreeBalance string = "INSUFFICIENT_FREE_BALANCE"
+const codePledgeActive string = "PLEDGE_ACTIVE"
+
+//附買回錯誤
+const codeRepoNotSettled string = "REPO_NOT_SETTLED"
+
+//交易說明(TXMemo)
+const codeNotMatched string = "NOT_MATCHED"
+const codeTXCancelled string = "TX_CANCELLED"
+const codeTXRevoked string = "TX_REVOKED"
+const codeTXCorrected string = "TX_CORRECTED"
+const codeCorrectionFailed string = "CORRECTION_FAILED"
+const codeSystemError string = "SYSTEM_ERROR"
+const codeEndOfDayCancelled string = "END_OF_DAY_CANCELLED"
+const codeInstructionCancelled string = "INSTRUCTION_CANCELLED"
+const codeInsufficientSecurities string = "INSUFFICIENT_SECURITIES"
+const codeReceiverInsufficientSecurities string = "RECEIVER_INSUFFICIENT_SECURITIES"
+const codeInsufficientCash string = "INSUFFICIENT_CASH"
+const codeCashShortfall string = "CASH_SHORTFALL"
+const codeWaitingForCash string = "WAITING_FOR_CASH"
+const codeWaitingForResponse string = "WAITING_FOR_RESPONSE"
+const codeWaitingSettlementDate string = "WAITING_SETTLEMENT_DATE"
+const codeWaitingNetSettlement string = "WAITING_NET_SETTLEMENT"
+const codeNetSettlementShortfall string = "NET_SETTLEMENT_SHORTFALL"
+const codeAmountMismatch string = "AMOUNT_MISMATCH"
+const codeFaceValueMismatch string = "FACE_VALUE_MISMATCH"
+
+type ErrorCode struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`   //英文說明
+	MessageZh string `json:"messageZh"` //中文說明
+	Detail    string `json:"detail"`    //原始錯誤訊息
+}
+
+/*
+1.代碼
+2.英文說明
+3.中文說明
+4.原始錯誤訊息
+*/
+
+var errorCatalogue = map[string]ErrorCode{
+	codeArgumentCount:                  {codeArgumentCount, "Incorrect number of arguments", "參數個數錯誤", ""},
+	codeArgumentEmpty:                  {codeArgumentEmpty, "An argument must be a non-empty string", "參數不可為空白", ""},
+	codeArgumentFormat:                 {codeArgumentFormat, "An argument has an invalid format or value", "參數格式或數值錯誤", ""},
+	codeNotFound:                       {codeNotFound, "The record does not exist", "資料不存在", ""},
+	codeAlreadyExists:                  {codeAlreadyExists, "The record already exists", "資料已存在", ""},
+	codeUnauthorized:                   {codeUnauthorized, "The caller is not allowed to execute this function", "無執行權限", ""},
+	codeInvalidStatus:                  {codeInvalidStatus, "The operation is not allowed in the current status", "目前狀態不可執行", ""},
+	codeDualAuthRequired:               {codeDualAuthRequired, "The function requires maker-checker approval", "須經雙人覆核", ""},
+	codeBusinessDayClosed:              {codeBusinessDayClosed, "The business day is not open for booking", "營業日未開放登錄", ""},
+	codeStateError:                     {codeStateError, "Failed to read or write ledger state", "帳本存取失敗", ""},
+	codeInvalidFunction:                {codeInvalidFunction, "Invalid Smart Contract function name", "無此交易功能", ""},
+	codeInternalError:                  {codeInternalError, "Unclassified error", "系統錯誤", ""},
+	codeQueueNotFound:                  {codeQueueNotFound, "The queue or history of the business date does not exist", "交易暫存檔不存在", ""},
+	codeDuplicateMatch:                 {codeDuplicateMatch, "The instruction matched more than one counterpart", "重複比對", ""},
+	codeNegativeBalance:                {codeNegativeBalance, "Settlement would make a balance negative", "交割後餘額小於零", ""},
+	codeSettlementDateInvalid:          {codeSettlementDateInvalid, "SettlementDate must not be before TradeDate", "交割日不可早於交易日", ""},
+	codeSecurityIDFormat:               {codeSecurityIDFormat, "SecurityID must be one capital letter followed by 5 digits", "債券代號格式錯誤", ""},
+	codeSecurityDateInvalid:            {codeSecurityDateInvalid, "IssueDate and MaturityDate must be valid and MaturityDate after IssueDate", "發行日或到期日錯誤", ""},
+	codeInterestRateRange:              {codeInterestRateRange, "InterestRate is out of range", "票面利率超出範圍", ""},
+	codeSecurityTermMismatch:           {codeSecurityTermMismatch, "RepayPeriod does not match IssueDate and MaturityDate", "年期與發行日、到期日不符", ""},
+	codeTotalBelowHoldings:             {codeTotalBelowHoldings, "TotalAmount is less than the outstanding holdings", "發行總額小於持有餘額", ""},
+	codeSecurityStatusNotAllowed:       {codeSecurityStatusNotAllowed, "The operation is not allowed in the current SecurityStatus", "債券目前狀態不可執行", ""},
+	codeSecurityStatusTransition:       {codeSecurityStatusTransition, "The SecurityStatus change is not allowed", "債券狀態不可如此變更", ""},
+	codeAuctionClosed:                  {codeAuctionClosed, "The auction is closed for bidding", "標售已截止", ""},
+	codeAuctionNotClosed:               {codeAuctionNotClosed, "The auction has not reached its closing time", "標售尚未截止", ""},
+	codeTradingHalted:                  {codeTradingHalted, "Trading and settlement are halted", "交易及交割暫停中", ""},
+	codeInsufficientFreeBalance:        {codeInsufficientFreeBalance, "The quantity exceeds the unpledged balance", "可動用(未質押)券數不足", ""},
+	codePledgeActive:                   {codePledgeActive, "The position has an active pledge", "部位設有質押", ""},
+	codeRepoNotSettled:                 {codeRepoNotSettled, "The repo leg could not be settled", "附買回交割未完成", ""},
+	codeNotMatched:                     {codeNotMatched, "Not matched yet", "尚未比對", ""},
+	codeTXCancelled:                    {codeTXCancelled, "The transaction was cancelled", "交易被取消", ""},
+	codeTXRevoked:                      {codeTXRevoked, "The transaction was withdrawn", "交易取消", ""},
+	codeTXCorrected:                    {codeTXCorrected, "The transaction was corrected", "交易更正", ""},
+	codeCorrectionFailed:               {codeCorrectionFailed, "The correction failed", "更正失敗", ""},
+	codeSystemError:                    {codeSystemError, "System error", "系統錯誤", ""},
+	codeEndOfDayCancelled:              {codeEndOfDayCancelled, "Cancelled by end-of-day processing", "日終交易取消", ""},
+	codeInstructionCancelled:           {codeInstructionCancelled, "Cancelled by the instructing bank", "原始銀行撤銷", ""},
+	codeInsufficientSecurities:         {codeInsufficientSecurities, "The seller has insufficient securities", "轉出方券不足", ""},
+	codeReceiverInsufficientSecurities: {codeReceiverInsufficientSecurities, "The receiver has insufficient securities", "轉入方券不足", ""},
+	codeInsufficientCash:               {codeInsufficientCash, "The buyer has insufficient cash", "轉入方款不足", ""},
+	codeCashShortfall:                  {codeCashShortfall, "Insufficient cash", "款不足", ""},
+	codeWaitingForCash:                 {codeWaitingForCash, "Waiting for the buyer's cash", "款不足等待補款", ""},
+	codeWaitingForResponse:             {codeWaitingForResponse, "Waiting for payment confirmation", "等待回應", ""},
+	codeWaitingSettlementDate:          {codeWaitingSettlementDate, "Waiting for the settlement date", "等待交割日", ""},
+	codeWaitingNetSettlement:           {codeWaitingNetSettlement, "Waiting for the net settlement cycle", "等待批次交割", ""},
+	codeNetSettlementShortfall:         {codeNetSettlementShortfall, "Excluded from the net settlement cycle for insufficient cover", "批次交割額度不足", ""},
+	codeAmountMismatch:                 {codeAmountMismatch, "SecurityAmount differs from the counterpart", "交易金額疑輸錯", ""},
+	codeFaceValueMismatch:              {codeFaceValueMismatch, "Payment differs from the counterpart", "交易面額疑輸錯", ""},
+}
+
+func newErrorCode(code string, detail string) ErrorCode {
+
+	errorCode, ok := errorCatalogue[code]
+	if ok != true {
+		errorCode = errorCatalogue[codeInternalError]
+	}
+	errorCode.Detail = detail
+	return errorCode
+}
+
+func errorResponse(code string, detail string) peer.Response {
+
+	errorAsBytes, err := json.Marshal(newErrorCode(code, detail))
+	if err != nil {
+		return shim.Error(detail)
+	}
+	return shim.Error(string(errorAsBytes))
+}
+
+func isErrorResponse(message string) bool {
+
+	errorCode := ErrorCode{}
+	return json.Unmarshal([]byte(message), &errorCode) == nil && errorCode.Code != ""
+}
+
+//取回 errorResponse 的代碼及原始錯誤訊息，文字錯誤視為 INTERNAL_ERROR
+func getResponseErrorCode(message string) ErrorCode {
+
+	errorCode := ErrorCode{}
+	if json.Unmarshal([]byte(message), &errorCode) != nil || errorCode.Code == "" {
+		return newErrorCode(codeInternalError, message)
+	}
+	return errorCode
+}
+
+//由 Invoke 呼叫，各交易應以 errorResponse 指定代碼，未指定者視為 INTERNAL_ERROR
+func toErrorResponse(response peer.Response) peer.Response {
+
+	if response.Status == shim.OK || isErrorResponse(response.Message) == true {
+		return response
+	}
+	return errorResponse(codeInternalError, response.Message)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryErrorCodes"]}' -C myc
+
+func (s *SmartContract) queryErrorCodes(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 0 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 0")
+	}
+	codes := []ErrorCode{}
+	for _, val := range errorCatalogue {
+		codes = append(codes, val)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return dataResponse(codes)
+}
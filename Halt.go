@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const HaltObjectType string = "Halt"
+const haltScopeGlobal string = "GLOBAL"
+const haltScopeSecurity string = "SECURITY"
+const haltScopeBank string = "BANK"
+const haltGlobalID string = "ALL"
+const haltActive string = "ACTIVE"
+const haltReleased string = "RELEASED"
+
+type Halt struct {
+	ObjectType   string `json:"docType"`      // default set to "Halt"
+	Scope        string `json:"Scope"`        // GLOBAL, SECURITY or BANK
+	ID           string `json:"ID"`           // ALL、公債代號或清算銀行代號(三碼)
+	Reason       string `json:"Reason"`       // 暫停原因
+	HaltStatus   string `json:"HaltStatus"`   // ACTIVE or RELEASED
+	StartTime    string `json:"StartTime"`    // 開始時間(YYYY/MM/DD HH:MM:SS)
+	EndTime      string `json:"EndTime"`      // 自動結束時間，空字串表示至 releaseHalt 為止
+	ActivatedBy  string `json:"ActivatedBy"`  // 啟動者
+	ActivateTxID string `json:"ActivateTxID"` // 啟動的 Fabric TxID
+	ReleasedBy   string `json:"ReleasedBy"`   // 解除者
+	ReleaseTxID  string `json:"ReleaseTxID"`  // 解除的 Fabric TxID
+	ReleaseTime  string `json:"ReleaseTime"`  // 解除時間
+	IsActive     bool   `json:"IsActive"`     // 查詢時計算(已過 EndTime 者為 false)
+}
+
+/*
+緊急暫停：全面、單一公債或單一清算銀行。暫停期間 securityTransfer、securityCorrectTransfer
+及交割(佇列重新檢核、交割批次、預約交割、submitApproveTransaction 核准)不執行，
+查詢及日終取消(submitEndDayTransaction、closeBusinessDay)不受影響。
+啟動及解除皆寫入 AuditRecord(Function 為 activateHalt / releaseHalt)。
+Key: Halt~Scope~ID，同一範圍只保留最近一次，過去的紀錄可由 GetHistoryForKey 追溯
+
+1.範圍
+2.代號
+3.暫停原因
+4.狀態
+5.開始時間
+6.自動結束時間
+7.啟動者
+8.啟動交易序號
+9.解除者
+10.解除交易序號
+11.解除時間
+12.是否仍有效
+*/
+
+func getHaltID(Scope string, ID string) string {
+
+	if Scope == haltScopeGlobal {
+		return haltGlobalID
+	} else if Scope == haltScopeBank {
+		return getBankCode(ID)
+	}
+	return strings.ToUpper(ID)
+}
+
+//以交易提案時間判斷，各背書節點結果一致
+func getHaltTime(stub shim.ChaincodeStubInterface) string {
+
+	return getTxTime(stub).Format(timelayout2)
+}
+
+func isHaltActive(halt *Halt, TimeNow2 string) bool {
+
+	return halt.HaltStatus == haltActive && (halt.EndTime == "" || TimeNow2 < halt.EndTime)
+}
+
+func getHaltStruct(stub shim.ChaincodeStubInterface, Scope string, ID string) (*Halt, error) {
+
+	haltKey, err := stub.CreateCompositeKey(HaltObjectType, []string{Scope, ID})
+	if err != nil {
+		return nil, err
+	}
+	haltAsBytes, err := stub.GetState(haltKey)
+	if err != nil || haltAsBytes == nil {
+		return nil, err
+	}
+	halt := &Halt{}
+	err = json.Unmarshal(haltAsBytes, halt)
+	if err != nil {
+		return nil, err
+	}
+	halt.IsActive = isHaltActive(halt, getHaltTime(stub))
+	return halt, nil
+}
+
+func putHaltStruct(stub shim.ChaincodeStubInterface, halt *Halt) error {
+
+	haltKey, err := stub.CreateCompositeKey(HaltObjectType, []string{halt.Scope, halt.ID})
+	if err != nil {
+		return err
+	}
+	halt.ObjectType = HaltObjectType
+	halt.IsActive = false
+	haltAsBytes, err := json.Marshal(halt)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(haltKey, haltAsBytes)
+}
+
+//SecurityID 及 AccountIDs 所屬清算銀行有任何有效的暫停時回傳說明，否則回傳空字串
+func checkHalt(stub shim.ChaincodeStubInterface, SecurityID string, AccountIDs ...string) string {
+
+	scopes := [][]string{{haltScopeGlobal, haltGlobalID}, {haltScopeSecurity, strings.ToUpper(SecurityID)}}
+	for _, AccountID := range AccountIDs {
+		scopes = append(scopes, []string{haltScopeBank, SubString(AccountID, 0, 3)})
+	}
+	for _, val := range scopes {
+		halt, err := getHaltStruct(stub, val[0], val[1])
+		if err != nil {
+			return err.Error()
+		}
+		if halt != nil && halt.IsActive == true {
+			return "Trading of " + val[0] + " " + val[1] + " is halted since " + halt.StartTime + ": " + halt.Reason
+		}
+	}
+	return ""
+}
+
+/*
+第 4 個參數為自動結束時間(YYYY/MM/DD HH:MM:SS)，空字串表示至 releaseHalt 為止
+peer chaincode invoke -n mycc -c '{"Args":["activateHalt","GLOBAL","","Network incident","","BANKCBC"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["activateHalt","SECURITY","A07103","Corporate action","2018/04/15 17:00:00","BANKCBC"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["activateHalt","BANK","BANK004","Liquidity problem","","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) activateHalt(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := getHaltTime(APIstub)
+
+	err := checkArgArrayLength(args, 5)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	Scope := strings.ToUpper(args[0])
+	if Scope != haltScopeGlobal && Scope != haltScopeSecurity && Scope != haltScopeBank {
+		return errorResponse(codeArgumentFormat, "Scope must be GLOBAL, SECURITY or BANK")
+	}
+	if Scope != haltScopeGlobal && len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ID must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Reason must be a non-empty string")
+	}
+	EndTime := args[3]
+	if EndTime != "" {
+		_, err = time.Parse(timelayout2, EndTime)
+		if err != nil {
+			return errorResponse(codeArgumentFormat, "EndTime must be a YYYY/MM/DD HH:MM:SS string")
+		}
+		if EndTime <= TimeNow2 {
+			return errorResponse(codeArgumentFormat, "EndTime must be later than now: "+EndTime)
+		}
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[4]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	ID := getHaltID(Scope, args[1])
+	if Scope == haltScopeSecurity {
+		_, err = getSecurityStructFromID(APIstub, ID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+	}
+
+	halt, err := getHaltStruct(APIstub, Scope, ID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if halt != nil && halt.IsActive == true {
+		return errorResponse(codeAlreadyExists, "Halt of "+Scope+" "+ID+" is already active")
+	}
+	halt = &Halt{}
+	halt.Scope = Scope
+	halt.ID = ID
+	halt.Reason = args[2]
+	halt.HaltStatus = haltActive
+	halt.StartTime = TimeNow2
+	halt.EndTime = EndTime
+	halt.ActivatedBy = strings.ToUpper(args[4])
+	halt.ActivateTxID = APIstub.GetTxID()
+	err = putHaltStruct(APIstub, halt)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	halt.IsActive = true
+	return dataResponse(halt)
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["releaseHalt","SECURITY","A07103","BANKCBC"]}' -C myc
+func (s *SmartContract) releaseHalt(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	Scope := strings.ToUpper(args[0])
+	if errMsg := verifyAdminIdentity(APIstub, args[2]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	ID := getHaltID(Scope, args[1])
+
+	halt, err := getHaltStruct(APIstub, Scope, ID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if halt == nil || halt.HaltStatus != haltActive {
+		return errorResponse(codeNotFound, "Failed to find an active halt of "+Scope+" "+ID)
+	}
+	halt.HaltStatus = haltReleased
+	halt.ReleasedBy = strings.ToUpper(args[2])
+	halt.ReleaseTxID = APIstub.GetTxID()
+	halt.ReleaseTime = getHaltTime(APIstub)
+	err = putHaltStruct(APIstub, halt)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(halt)
+}
+
+/*
+全部暫停紀錄(含已解除、已自動結束者)，IsActive 表示目前是否有效
+peer chaincode query -n mycc -c '{"Args":["queryHalts"]}' -C myc
+*/
+func (s *SmartContract) queryHalts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 0 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 0")
+	}
+	TimeNow2 := getHaltTime(APIstub)
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(HaltObjectType, []string{})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	halts := []Halt{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		halt := Halt{}
+		err = json.Unmarshal(queryResponse.Value, &halt)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		halt.IsActive = isHaltActive(&halt, TimeNow2)
+		halts = append(halts, halt)
+	}
+	return dataResponse(halts)
+}
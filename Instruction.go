@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const InstructionActionObjectType string = "InstructionAction"
+
+//撤銷/暫停/恢復交割之原因代碼
+var instructionReasonCodes = map[string]string{
+	"CUST": "客戶要求",
+	"DUPL": "重複交易",
+	"KEYE": "輸入錯誤",
+	"INVS": "調查中",
+	"FRAD": "疑似詐欺",
+	"TECH": "系統問題",
+	"OTHR": "其他",
+}
+
+type InstructionAction struct {
+	ObjectType string `json:"docType"`    // default set to "InstructionAction"
+	TXID       string `json:"TXID"`       // Transaction ID
+	Action     string `json:"Action"`     // CANCEL, HOLD, RELEASE
+	ReasonCode string `json:"ReasonCode"` // 原因代碼
+	BankID     string `json:"BankID"`     // 執行銀行
+	TXStatus   string `json:"TXStatus"`   // 執行時的交易狀態
+	CreateTime string `json:"createTime"`
+}
+
+/*
+1.交易序號
+2.動作
+3.原因代碼
+4.執行銀行
+5.執行時的交易狀態
+6.建立時間
+*/
+
+//轉出銀行(BankFrom)或 CBC
+func verifyInstructionBank(stub shim.ChaincodeStubInterface, BankID string, transaction *Transaction) string {
+
+	if verifyAdminIdentity(stub, BankID) == "" {
+		return ""
+	}
+	if BankID != "BANK"+SubString(transaction.TXFrom, 0, 3) {
+		return "Only BankFrom or BANK" + AdminBankID + " can change this transaction"
+	}
+	return verifyIdentity(stub, BankID)
+}
+
+func putInstructionAction(stub shim.ChaincodeStubInterface, transaction *Transaction, Action string, ReasonCode string, BankID string) error {
+
+	actionKey, err := stub.CreateCompositeKey(InstructionActionObjectType, []string{transaction.TXID, stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	action := InstructionAction{}
+	action.ObjectType = InstructionActionObjectType
+	action.TXID = transaction.TXID
+	action.Action = Action
+	action.ReasonCode = ReasonCode
+	action.BankID = BankID
+	action.TXStatus = transaction.TXStatus
+	action.CreateTime = time.Now().Format(timelayout2)
+	actionAsBytes, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(actionKey, actionAsBytes)
+}
+
+//寫入交易，並以交易內容更新佇列及歷史資料中的同一筆
+func putInstructionState(stub shim.ChaincodeStubInterface, transaction *Transaction) error {
+
+	transaction.UpdateTime = time.Now().Format(timelayout2)
+	transactionAsBytes, err := json.Marshal(transaction)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(transaction.TXID, transactionAsBytes)
+	if err != nil {
+		return err
+	}
+
+	queuedTX, err := getTransactionQueue(stub, transaction.TXID)
+	if err != nil {
+		return err
+	}
+	var doflg bool
+	doflg = false
+	for key, val := range queuedTX.TXIDs {
+		if val == transaction.TXID {
+			queuedTX.Transactions[key] = *transaction
+			doflg = true
+		}
+	}
+	if doflg != true {
+		return errors.New("Failed to find Queued TXID " + transaction.TXID)
+	}
+	queuedAsBytes, err := json.Marshal(queuedTX)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(queuedTX.TXKEY, queuedAsBytes)
+	if err != nil {
+		return err
+	}
+
+	historyTX, err := getHistoryTransactionStructFromID(stub, "H"+queuedTX.TXKEY)
+	if err != nil {
+		return err
+	}
+	for key, val := range historyTX.TXIDs {
+		if val == transaction.TXID {
+			historyTX.Transactions[key] = *transaction
+			historyTX.TXStatus[key] = transaction.TXStatus
+		}
+	}
+	historyAsBytes, err := json.Marshal(historyTX)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(historyTX.TXKEY, historyAsBytes)
+}
+
+//args: TXID, ReasonCode, BankID
+func getInstructionArgs(stub shim.ChaincodeStubInterface, args []string) (*Transaction, string, string, string, string) {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return nil, "", "", codeArgumentCount, err.Error()
+	}
+	if len(args[0]) <= 0 {
+		return nil, "", "", codeArgumentEmpty, "TXID must be a non-empty string"
+	}
+	if len(args[1]) <= 0 {
+		return nil, "", "", codeArgumentEmpty, "ReasonCode must be a non-empty string"
+	}
+	if len(args[2]) <= 0 {
+		return nil, "", "", codeArgumentEmpty, "BankID must be a non-empty string"
+	}
+	TXID := strings.ToUpper(args[0])
+	ReasonCode := strings.ToUpper(args[1])
+	BankID := strings.ToUpper(args[2])
+	if _, ok := instructionReasonCodes[ReasonCode]; ok != true {
+		return nil, "", "", codeArgumentFormat, "Unknown ReasonCode: " + ReasonCode
+	}
+	transaction, err := getTransactionStructFromID(stub, TXID)
+	if err != nil {
+		return nil, "", "", codeNotFound, err.Error()
+	}
+	if errMsg := verifyInstructionBank(stub, BankID, transaction); errMsg != "" {
+		return nil, "", "", codeUnauthorized, errMsg
+	}
+	return transaction, ReasonCode, BankID, "", ""
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["cancelInstruction","BK004S00400000000120180415070724","CUST","BANK004"]}' -C myc
+
+轉出銀行撤銷尚未比對(Pending)之交易，並轉回 PendingBalance
+*/
+func (s *SmartContract) cancelInstruction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	transaction, ReasonCode, BankID, errCode, errMsg := getInstructionArgs(APIstub, args)
+	if errMsg != "" {
+		return errorResponse(errCode, errMsg)
+	}
+	if transaction.TXStatus != "Pending" {
+		return errorResponse(codeInvalidStatus, "Only a Pending transaction can be cancelled, TXStatus: "+transaction.TXStatus)
+	}
+	err := putInstructionAction(APIstub, transaction, "CANCEL", ReasonCode, BankID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = releasePendingBalance(APIstub, transaction.SecurityID, transaction.Payment, transaction.TXFrom, transaction.TXTo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	transaction.TXStatus = "Cancelled"
+	transaction.TXMemo = codeInstructionCancelled
+	transaction.IsFrozen = false
+	err = putInstructionState(APIstub, transaction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["holdInstruction","BK004S00400000000120180415070724","INVS","BANK004"]}' -C myc
+
+已比對尚未完成交割(Matched、PaymentError、Waiting4Payment)之交易暫停交割，
+runSettlementCycle 及佇列重新檢核皆略過暫停中的交易
+*/
+func (s *SmartContract) holdInstruction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	transaction, ReasonCode, BankID, errCode, errMsg := getInstructionArgs(APIstub, args)
+	if errMsg != "" {
+		return errorResponse(errCode, errMsg)
+	}
+	if transaction.TXStatus != "Matched" && transaction.TXStatus != "PaymentError" && transaction.TXStatus != "Waiting4Payment" {
+		return errorResponse(codeInvalidStatus, "Only a matched transaction can be held, TXStatus: "+transaction.TXStatus)
+	}
+	if transaction.IsHeld == true {
+		return errorResponse(codeInvalidStatus, "Transaction is already held: "+transaction.TXID)
+	}
+	err := putInstructionAction(APIstub, transaction, "HOLD", ReasonCode, BankID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	transaction.IsHeld = true
+	transaction.HoldReason = ReasonCode
+	err = putInstructionState(APIstub, transaction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["releaseInstruction","BK004S00400000000120180415070724","OTHR","BANK004"]}' -C myc
+
+func (s *SmartContract) releaseInstruction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	transaction, ReasonCode, BankID, errCode, errMsg := getInstructionArgs(APIstub, args)
+	if errMsg != "" {
+		return errorResponse(errCode, errMsg)
+	}
+	if transaction.IsHeld != true {
+		return errorResponse(codeInvalidStatus, "Transaction is not held: "+transaction.TXID)
+	}
+	err := putInstructionAction(APIstub, transaction, "RELEASE", ReasonCode, BankID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	transaction.IsHeld = false
+	transaction.HoldReason = ""
+	err = putInstructionState(APIstub, transaction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	//恢復後的 PaymentError / Waiting4Payment 交易立即重新檢核
+	_, err = retryQueuedTransactions(APIstub, false)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryInstructionActions","BK004S00400000000120180415070724"]}' -C myc
+
+func (s *SmartContract) queryInstructionActions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(InstructionActionObjectType, []string{strings.ToUpper(args[0])})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	actions := []InstructionAction{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		action := InstructionAction{}
+		err = json.Unmarshal(queryResponse.Value, &action)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		actions = append(actions, action)
+	}
+	return dataResponse(actions)
+}
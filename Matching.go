@@ -0,0 +1,382 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//比對索引：MatchIndex~TXIndex~TXKEY~TXID，涵蓋各營業日尚未比對(Pending)的交易
+const MatchIndexObjectType string = "MatchIndex"
+const matchingWindowKey string = "matchingwindow" //可跨日比對的營業日數(含當日)，預設 1
+
+/*
+比對以欄位逐一比較，取代 TXIndex / TXSIndex 雜湊比對(雜湊仍保留於交易及佇列中)：
+對方交易的轉出帳號 = 本交易轉入帳號、轉入帳號 = 本交易轉出帳號，
+公債代號、交易金額、交易面額、交割日相同；雙方皆有共同交易參考編號(TradeRef)時須相同。
+*/
+
+type MatchDiff struct {
+	Field          string `json:"Field"`          //欄位
+	Expected       string `json:"Expected"`       //本交易的值
+	CandidateValue string `json:"CandidateValue"` //對方交易的值
+}
+
+type MatchCandidate struct {
+	TXKEY          string      `json:"TXKEY"`
+	TXID           string      `json:"TXID"`
+	TXType         string      `json:"TXType"`
+	TXStatus       string      `json:"TXStatus"`
+	TXFrom         string      `json:"TXFrom"`
+	TXTo           string      `json:"TXTo"`
+	SecurityID     string      `json:"SecurityID"`
+	SecurityAmount int64       `json:"SecurityAmount"`
+	Payment        int64       `json:"Payment"`
+	TradeRef       string      `json:"TradeRef"`
+	Diffs          []MatchDiff `json:"Diffs"` //不一致的欄位
+}
+
+/*
+1.所在營業日
+2.交易序號
+3.交易型態
+4.交易狀態
+5.轉出帳號
+6.轉入帳號
+7.公債代號
+8.交易金額
+9.交易面額
+10.共同交易參考編號
+11.不一致的欄位
+*/
+
+//欄位名稱：Counterparty(對方轉出帳號)、Account(對方轉入帳號)、SecurityID、SecurityAmount、Payment、SettlementDate、TradeRef
+func getMatchDiffs(transaction Transaction, candidate Transaction) []MatchDiff {
+
+	var diffs []MatchDiff
+	if candidate.TXFrom != transaction.TXTo {
+		diffs = append(diffs, MatchDiff{"Counterparty", transaction.TXTo, candidate.TXFrom})
+	}
+	if candidate.TXTo != transaction.TXFrom {
+		diffs = append(diffs, MatchDiff{"Account", transaction.TXFrom, candidate.TXTo})
+	}
+	if candidate.SecurityID != transaction.SecurityID {
+		diffs = append(diffs, MatchDiff{"SecurityID", transaction.SecurityID, candidate.SecurityID})
+	}
+	if candidate.SecurityAmount != transaction.SecurityAmount {
+		diffs = append(diffs, MatchDiff{"SecurityAmount", strconv.FormatInt(transaction.SecurityAmount, 10), strconv.FormatInt(candidate.SecurityAmount, 10)})
+	}
+	if candidate.Payment != transaction.Payment {
+		diffs = append(diffs, MatchDiff{"Payment", strconv.FormatInt(transaction.Payment, 10), strconv.FormatInt(candidate.Payment, 10)})
+	}
+	if getTXSettlementDate(candidate) != getTXSettlementDate(transaction) {
+		diffs = append(diffs, MatchDiff{"SettlementDate", getTXSettlementDate(transaction), getTXSettlementDate(candidate)})
+	}
+	if candidate.TradeRef != "" && transaction.TradeRef != "" && candidate.TradeRef != transaction.TradeRef {
+		diffs = append(diffs, MatchDiff{"TradeRef", transaction.TradeRef, candidate.TradeRef})
+	}
+	return diffs
+}
+
+func isMatchCounterpart(transaction Transaction, candidate Transaction) bool {
+
+	return candidate.TXID != transaction.TXID && candidate.TXType != transaction.TXType
+}
+
+func isMatchingInstruction(transaction Transaction, candidate Transaction) bool {
+
+	return isMatchCounterpart(transaction, candidate) && len(getMatchDiffs(transaction, candidate)) == 0
+}
+
+//只有交易金額或交易面額不一致(原 TXSIndex 相同、TXIndex 不同)
+func isNearMatchInstruction(transaction Transaction, candidate Transaction) bool {
+
+	if isMatchCounterpart(transaction, candidate) != true {
+		return false
+	}
+	diffs := getMatchDiffs(transaction, candidate)
+	if len(diffs) == 0 {
+		return false
+	}
+	for _, diff := range diffs {
+		if diff.Field != "SecurityAmount" && diff.Field != "Payment" {
+			return false
+		}
+	}
+	return true
+}
+
+func getMatchingWindow(stub shim.ChaincodeStubInterface) int {
+
+	MatchingWindow := 1
+	ValueAsBytes, err := stub.GetState(matchingWindowKey)
+	if err == nil && ValueAsBytes != nil {
+		value, err := strconv.Atoi(string(ValueAsBytes))
+		if err == nil && value > 0 {
+			MatchingWindow = value
+		}
+	}
+	return MatchingWindow
+}
+
+/*
+回傳 TXKEY(含)之前最近 n 個營業日。
+有 BusinessDay 紀錄時依營業日，尚未 openBusinessDay 時依日曆日。
+*/
+func getMatchingDates(stub shim.ChaincodeStubInterface, TXKEY string, n int) []string {
+
+	var dates []string
+	if n <= 0 {
+		return dates
+	}
+	resultsIterator, err := stub.GetStateByRange(BusinessDayPrefix+"00000000", BusinessDayPrefix+TXKEY+"~")
+	if err == nil {
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				break
+			}
+			dates = append(dates, SubString(queryResponse.Key, len(BusinessDayPrefix), 8))
+		}
+	}
+	if len(dates) > 0 {
+		if len(dates) > n {
+			dates = dates[len(dates)-n:]
+		}
+		return dates
+	}
+	day, err := time.Parse("20060102", TXKEY)
+	if err != nil {
+		return []string{TXKEY}
+	}
+	for i := n - 1; i >= 0; i-- {
+		dates = append(dates, day.AddDate(0, 0, -i).Format("20060102"))
+	}
+	return dates
+}
+
+func isMatchingDate(dates []string, TXKEY string) bool {
+
+	for _, date := range dates {
+		if date == TXKEY {
+			return true
+		}
+	}
+	return false
+}
+
+//日終時尚未比對的交易，若下一營業日仍在比對範圍內則保留
+func isCarriedInstruction(stub shim.ChaincodeStubInterface, TXKEY string) bool {
+
+	return isMatchingDate(getMatchingDates(stub, getQueueTXKEY(stub), getMatchingWindow(stub)-1), TXKEY)
+}
+
+func putMatchIndex(stub shim.ChaincodeStubInterface, TXKEY string, transaction Transaction) error {
+
+	indexKey, err := stub.CreateCompositeKey(MatchIndexObjectType, []string{transaction.TXIndex, TXKEY, transaction.TXID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+/*
+securityTransfer 登錄前，於比對範圍內之前營業日(含已日終而保留的交易)找尋可比對的 Pending 交易，
+找到時將其由原營業日的佇列及歷史交易移至登錄營業日(TXKEY)，新交易依原比對流程於登錄營業日比對及交割。
+日終進行中(Closing)的營業日不移動，以免改變其日終處理位置(Bookmark)。
+已不是 Pending 的索引於 closeBusinessDay 時刪除。
+*/
+func carryMatchInstruction(stub shim.ChaincodeStubInterface, transaction Transaction, TXKEY string) error {
+
+	MatchingWindow := getMatchingWindow(stub)
+	if MatchingWindow <= 1 || transaction.TXStatus != "Pending" || transaction.TXIndex == "" {
+		return nil
+	}
+	dates := getMatchingDates(stub, TXKEY, MatchingWindow)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(MatchIndexObjectType, []string{transaction.TXIndex})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			continue
+		}
+		MatchKEY := compositeKeyParts[1]
+		if MatchKEY == TXKEY || isMatchingDate(dates, MatchKEY) != true {
+			continue
+		}
+		day, err := getBusinessDayStruct(stub, MatchKEY)
+		if err != nil || (day != nil && day.DayStatus == dayClosing) {
+			continue
+		}
+		candidate, err := getTransactionStructFromID(stub, compositeKeyParts[2])
+		if err != nil || candidate.TXStatus != "Pending" {
+			continue
+		}
+		if isMatchingInstruction(transaction, *candidate) != true {
+			continue
+		}
+
+		queued, err := removeQueuedTransaction(stub, MatchKEY, candidate.TXID)
+		if err != nil {
+			return err
+		}
+		TXKinds, err := removeHistoryTransaction(stub, "H"+MatchKEY, candidate.TXID)
+		if err != nil {
+			return err
+		}
+		err = appendQueuedTransaction(stub, TXKEY, *queued)
+		if err != nil {
+			return err
+		}
+		err = appendHistoryTransaction(stub, "H"+TXKEY, *queued, TXKinds)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		return putMatchIndex(stub, TXKEY, *candidate)
+	}
+	return nil
+}
+
+//closeBusinessDay 時取消已超出比對範圍的之前營業日 Pending 交易
+func closeExpiredInstructions(stub shim.ChaincodeStubInterface, TXKEY string) ([]Transaction, error) {
+
+	var closed []Transaction
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(MatchIndexObjectType, []string{})
+	if err != nil {
+		return closed, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return closed, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return closed, err
+		}
+		MatchKEY := compositeKeyParts[1]
+		if MatchKEY >= TXKEY {
+			continue
+		}
+		//已比對或已取消的交易只刪除索引
+		transaction, err := getTransactionStructFromID(stub, compositeKeyParts[2])
+		if err == nil && transaction.TXStatus == "Pending" {
+			if isCarriedInstruction(stub, MatchKEY) == true {
+				continue
+			}
+			transactions, err := closeDayTransaction(stub, MatchKEY, transaction.TXID)
+			if err != nil {
+				return closed, err
+			}
+			closed = append(closed, transactions...)
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return closed, err
+		}
+	}
+	return closed, nil
+}
+
+/*
+peer chaincode query -n mycc -c '{"Args":["queryMatchCandidates","BK004S00400000000120180415070724"]}' -C myc
+
+列出同一佇列及比對範圍內各營業日(MatchIndex，同 carryMatchInstruction)尚未比對(Pending)之反向交易，
+不一致欄位 <= 2 或共同交易參考編號相同者，依不一致欄位數由少至多排列
+*/
+func (s *SmartContract) queryMatchCandidates(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	TXID := strings.ToUpper(args[0])
+	transaction, err := getTransactionStructFromID(APIstub, TXID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	queuedTX, err := getTransactionQueue(APIstub, TXID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	TXKEYs := make(map[string]string)
+	var transactions []Transaction
+	for _, val := range queuedTX.Transactions {
+		TXKEYs[val.TXID] = queuedTX.TXKEY
+		transactions = append(transactions, val)
+	}
+	//比對範圍內其他營業日的 Pending 交易
+	dates := getMatchingDates(APIstub, getQueueTXKEY(APIstub), getMatchingWindow(APIstub))
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(MatchIndexObjectType, []string{})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		MatchKEY := compositeKeyParts[1]
+		if _, ok := TXKEYs[compositeKeyParts[2]]; ok == true || isMatchingDate(dates, MatchKEY) != true {
+			continue
+		}
+		val, err := getTransactionStructFromID(APIstub, compositeKeyParts[2])
+		if err != nil {
+			continue
+		}
+		TXKEYs[val.TXID] = MatchKEY
+		transactions = append(transactions, *val)
+	}
+
+	candidates := []MatchCandidate{}
+	for _, val := range transactions {
+		if val.TXStatus != "Pending" || isMatchCounterpart(*transaction, val) != true {
+			continue
+		}
+		diffs := getMatchDiffs(*transaction, val)
+		if len(diffs) > 2 && (transaction.TradeRef == "" || val.TradeRef != transaction.TradeRef) {
+			continue
+		}
+		candidate := MatchCandidate{}
+		candidate.TXKEY = TXKEYs[val.TXID]
+		candidate.TXID = val.TXID
+		candidate.TXType = val.TXType
+		candidate.TXStatus = val.TXStatus
+		candidate.TXFrom = val.TXFrom
+		candidate.TXTo = val.TXTo
+		candidate.SecurityID = val.SecurityID
+		candidate.SecurityAmount = val.SecurityAmount
+		candidate.Payment = val.Payment
+		candidate.TradeRef = val.TradeRef
+		candidate.Diffs = diffs
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].Diffs) < len(candidates[j].Diffs)
+	})
+
+	return dataResponse(candidates)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const PendingChangeObjectType string = "PendingChange"
+const pendingChangeTTL time.Duration = 24 * time.Hour //覆核期限，逾期自動失效
+const changePending string = "Pending"
+const changeApproved string = "Approved"
+const changeRejected string = "Rejected"
+const changeExpired string = "Expired"
+
+//需雙人覆核(maker-checker)的管理交易，不能直接呼叫
+var makerCheckerFunctions = map[string]bool{
+	"changeSecurity":       true,
+	"changeSecurityStatus": true,
+	"deleteSecurity":       true,
+	"updateAccount":        true,
+	"updateAsset":          true,
+	"deleteBank":           true,
+}
+
+type PendingChange struct {
+	ObjectType     string   `json:"docType"`        // default set to "PendingChange"
+	ChangeID       string   `json:"ChangeID"`       // submitChange 的 Fabric TxID
+	Function       string   `json:"Function"`       // 原管理交易名稱
+	Args           []string `json:"Args"`           // 原管理交易參數
+	PayloadHash    string   `json:"PayloadHash"`    // SHA256(Function + Args)
+	MakerID        string   `json:"MakerID"`        // 提出者
+	MakerCreator   string   `json:"MakerCreator"`   // 提出者憑證的 SHA256
+	CheckerID      string   `json:"CheckerID"`      // 覆核者
+	CheckerCreator string   `json:"CheckerCreator"` // 覆核者憑證的 SHA256
+	ChangeStatus   string   `json:"ChangeStatus"`   // Pending, Approved, Rejected, Expired
+	ExpireTime     string   `json:"expireTime"`     // 覆核期限
+	CreateTime     string   `json:"createTime"`
+	UpdateTime     string   `json:"updateTime"`
+}
+
+/*
+1.變更序號
+2.管理交易名稱
+3.管理交易參數
+4.參數雜湊值
+5.提出者
+6.提出者憑證雜湊值
+7.覆核者
+8.覆核者憑證雜湊值
+9.變更狀態
+10.覆核期限
+11.建立時間
+12.更新時間
+*/
+
+func getPayloadHash(Function string, Args []string) (string, error) {
+
+	argsAsBytes, err := json.Marshal(Args)
+	if err != nil {
+		return "", err
+	}
+	return getSHA256(Function + string(argsAsBytes)), nil
+}
+
+//提交交易者憑證的 SHA256，無法取得時為空字串
+func getCreatorHash(stub shim.ChaincodeStubInterface) string {
+
+	creator, err := stub.GetCreator()
+	if err != nil || len(creator) == 0 {
+		return ""
+	}
+	return getSHA256(string(creator))
+}
+
+//逾期(依交易提案時間)的 Pending 變更視為 Expired
+func getPendingChangeStruct(stub shim.ChaincodeStubInterface, ChangeID string) (*PendingChange, error) {
+
+	changeKey, err := stub.CreateCompositeKey(PendingChangeObjectType, []string{ChangeID})
+	if err != nil {
+		return nil, err
+	}
+	changeAsBytes, err := stub.GetState(changeKey)
+	if err != nil {
+		return nil, err
+	}
+	if changeAsBytes == nil {
+		return nil, fmt.Errorf("ChangeID does not exist: %s", ChangeID)
+	}
+	change := PendingChange{}
+	err = json.Unmarshal(changeAsBytes, &change)
+	if err != nil {
+		return nil, err
+	}
+	if change.ChangeStatus == changePending && getTxTime(stub).Format(timelayout2) > change.ExpireTime {
+		change.ChangeStatus = changeExpired
+	}
+	return &change, nil
+}
+
+func putPendingChangeStruct(stub shim.ChaincodeStubInterface, change *PendingChange) error {
+
+	changeKey, err := stub.CreateCompositeKey(PendingChangeObjectType, []string{change.ChangeID})
+	if err != nil {
+		return err
+	}
+	changeAsBytes, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(changeKey, changeAsBytes)
+}
+
+//將逾期未覆核的變更寫為 Expired
+func expirePendingChanges(stub shim.ChaincodeStubInterface) error {
+
+	TimeNow2 := getTxTime(stub).Format(timelayout2)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(PendingChangeObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		change := PendingChange{}
+		err = json.Unmarshal(queryResponse.Value, &change)
+		if err != nil {
+			return err
+		}
+		if change.ChangeStatus != changePending || TimeNow2 <= change.ExpireTime {
+			continue
+		}
+		change.ChangeStatus = changeExpired
+		change.UpdateTime = TimeNow2
+		err = putPendingChangeStruct(stub, &change)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//覆核通過後以原管理交易執行變更
+func (s *SmartContract) applyPendingChange(APIstub shim.ChaincodeStubInterface, change *PendingChange) peer.Response {
+
+	args := change.Args
+	if change.Function == "changeSecurity" {
+		return s.changeSecurity(APIstub, args)
+	} else if change.Function == "changeSecurityStatus" {
+		return s.changeSecurityStatus(APIstub, args)
+	} else if change.Function == "deleteSecurity" {
+		return s.deleteSecurity(APIstub, args)
+	} else if change.Function == "updateAccount" {
+		return s.updateAccount(APIstub, args)
+	} else if change.Function == "updateAsset" {
+		return s.updateAsset(APIstub, args)
+	} else if change.Function == "deleteBank" {
+		return s.deleteBank(APIstub, args)
+	}
+	return errorResponse(codeArgumentFormat, "Function does not require dual authorization: "+change.Function)
+}
+
+//覆核者須為不同的 ID 及不同的憑證
+func verifyChangeChecker(stub shim.ChaincodeStubInterface, change *PendingChange, CheckerID string) string {
+
+	if change.ChangeStatus != changePending {
+		return "ChangeStatus of change was " + change.ChangeStatus
+	}
+	if errMsg := verifyIdentity(stub, CheckerID); errMsg != "" {
+		return errMsg
+	}
+	if CheckerID == change.MakerID {
+		return "Checker must be different from maker " + change.MakerID
+	}
+	CheckerCreator := getCreatorHash(stub)
+	if CheckerCreator != "" && CheckerCreator == change.MakerCreator {
+		return "Checker must submit with a different identity from maker"
+	}
+	return ""
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["submitChange","BANK004","changeSecurityStatus","[\"A06101\",\"SUSPENDED\"]"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["submitChange","BANK004","changeSecurityStatus","{\"SecurityID\":\"A06101\",\"SecurityStatus\":\"SUSPENDED\"}"]}' -C myc
+
+提出管理交易變更，參數為原管理交易的參數(JSON 字串陣列或具名欄位 JSON 物件)，覆核通過前不生效
+*/
+func (s *SmartContract) submitChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow := getTxTime(APIstub)
+	TimeNow2 := TimeNow.Format(timelayout2)
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "MakerID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Function must be a non-empty string")
+	}
+	MakerID := strings.ToUpper(args[0])
+	Function := args[1]
+	if _, ok := makerCheckerFunctions[Function]; ok != true {
+		return errorResponse(codeArgumentFormat, "Function does not require dual authorization: "+Function)
+	}
+	//Args 可為位置參數陣列或具名欄位的 JSON 物件，都依原管理交易的 schema 檢核(見 Request.go)
+	Args := []string{args[2]}
+	if isRequestObject(args[2]) != true {
+		err = json.Unmarshal([]byte(args[2]), &Args)
+		if err != nil {
+			return errorResponse(codeArgumentFormat, "Args must be a JSON array of strings or a JSON object")
+		}
+	}
+	Args, err = getRequestArgs(Function, Args)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, err.Error())
+	}
+	if errMsg := verifyIdentity(APIstub, MakerID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	err = expirePendingChanges(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	change := PendingChange{}
+	change.ObjectType = PendingChangeObjectType
+	change.ChangeID = APIstub.GetTxID()
+	change.Function = Function
+	change.Args = Args
+	change.PayloadHash, err = getPayloadHash(Function, Args)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, err.Error())
+	}
+	change.MakerID = MakerID
+	change.MakerCreator = getCreatorHash(APIstub)
+	change.ChangeStatus = changePending
+	change.ExpireTime = TimeNow.Add(pendingChangeTTL).Format(timelayout2)
+	change.CreateTime = TimeNow2
+	change.UpdateTime = TimeNow2
+	err = putPendingChangeStruct(APIstub, &change)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	changeAsBytes, err := json.Marshal(change)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(changeAsBytes)
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["approveChange","<ChangeID>","BANKCBC","<PayloadHash>"]}' -C myc
+
+覆核者核對 PayloadHash 後核准，變更以原管理交易執行；執行失敗時整筆交易不生效，變更仍為 Pending
+*/
+func (s *SmartContract) approveChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ChangeID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "CheckerID must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "PayloadHash must be a non-empty string")
+	}
+	CheckerID := strings.ToUpper(args[1])
+	change, err := getPendingChangeStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := verifyChangeChecker(APIstub, change, CheckerID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	PayloadHash, err := getPayloadHash(change.Function, change.Args)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, err.Error())
+	}
+	if args[2] != change.PayloadHash || PayloadHash != change.PayloadHash {
+		return errorResponse(codeArgumentFormat, "PayloadHash does not match change "+change.ChangeID)
+	}
+
+	response := s.applyPendingChange(APIstub, change)
+	if response.Status != shim.OK {
+		errorCode := getResponseErrorCode(response.Message)
+		return errorResponse(errorCode.Code, "Failed to apply "+change.Function+": "+errorCode.Detail)
+	}
+	fmt.Printf("approveChange ChangeID=%s, Function=%s\n", change.ChangeID, change.Function)
+
+	change.CheckerID = CheckerID
+	change.CheckerCreator = getCreatorHash(APIstub)
+	change.ChangeStatus = changeApproved
+	change.UpdateTime = time.Now().Format(timelayout2)
+	err = putPendingChangeStruct(APIstub, change)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return response
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["rejectChange","<ChangeID>","BANKCBC"]}' -C myc
+
+func (s *SmartContract) rejectChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ChangeID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "CheckerID must be a non-empty string")
+	}
+	CheckerID := strings.ToUpper(args[1])
+	change, err := getPendingChangeStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := verifyChangeChecker(APIstub, change, CheckerID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	change.CheckerID = CheckerID
+	change.CheckerCreator = getCreatorHash(APIstub)
+	change.ChangeStatus = changeRejected
+	change.UpdateTime = time.Now().Format(timelayout2)
+	err = putPendingChangeStruct(APIstub, change)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+peer chaincode query -n mycc -c '{"Args":["queryPendingChanges"]}' -C myc
+peer chaincode query -n mycc -c '{"Args":["queryPendingChanges","Pending"]}' -C myc
+*/
+func (s *SmartContract) queryPendingChanges(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) > 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 0 or 1")
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(PendingChangeObjectType, []string{})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	TimeNow2 := getTxTime(APIstub).Format(timelayout2)
+	changes := []PendingChange{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		change := PendingChange{}
+		err = json.Unmarshal(queryResponse.Value, &change)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if change.ChangeStatus == changePending && TimeNow2 > change.ExpireTime {
+			change.ChangeStatus = changeExpired
+		}
+		if len(args) == 1 && change.ChangeStatus != args[0] {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return dataResponse(changes)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const PledgeObjectType string = "Pledge"
+const PledgorPledgeIndex string = "PledgorPledge"
+const PledgeePledgeIndex string = "PledgeePledge"
+const pledgeActive string = "PLEDGED"
+const pledgeReleased string = "RELEASED"
+const pledgeRolePledgor string = "PLEDGOR"
+const pledgeRolePledgee string = "PLEDGEE"
+
+type Pledge struct {
+	ObjectType       string `json:"docType"`          // default set to "Pledge"
+	PledgeID         string `json:"PledgeID"`         // 質押序號(Fabric TxID)
+	AccountID        string `json:"AccountID"`        // 出質人帳號
+	BankID           string `json:"BankID"`           // 出質人清算銀行代號(三碼)
+	SecurityID       string `json:"SecurityID"`       // 公債代號
+	PledgeeID        string `json:"PledgeeID"`        // 質權人(例如 BANKCBC)
+	Quantity         int64  `json:"Quantity"`         // 質押券數
+	ReleasedQuantity int64  `json:"ReleasedQuantity"` // 已解除券數
+	Purpose          string `json:"Purpose"`          // 質押用途(例如日間透支擔保)
+	PledgeStatus     string `json:"PledgeStatus"`     // PLEDGED or RELEASED
+	CreateTime       string `json:"CreateTime"`
+	UpdateTime       string `json:"UpdateTime"`
+}
+
+/*
+出質人以持有部位之可動用餘額設定質押，質押券數記於 Position.PledgedBalance，
+券仍屬出質人(利息照計)，但 checkAccountBalance 及淨額交割以 Balance - PledgedBalance 檢核。
+質權人可分次解除，全部解除後狀態為 RELEASED。
+直接異動部位(updateAsset、changeSecurity 等)不可使 Balance 小於 PledgedBalance，設有質押時不可刪除部位或帳戶。
+Key: Pledge~PledgeID
+Index: PledgorPledge~AccountID~PledgeID
+       PledgeePledge~PledgeeID~PledgeID
+
+1.質押序號
+2.出質人帳號
+3.出質人清算銀行代號
+4.公債代號
+5.質權人
+6.質押券數
+7.已解除券數
+8.質押用途
+9.狀態
+10.建立時間
+11.異動時間
+*/
+
+//未設定質押的券數
+func getFreeBalance(position *Position) int64 {
+
+	Balance := position.Balance
+	if position.PendingBalance < Balance {
+		Balance = position.PendingBalance
+	}
+	return Balance - position.PledgedBalance
+}
+
+//直接異動部位時，Balance 不可小於已質押券數
+func checkPledgedBalance(position *Position) string {
+
+	if position.Balance < position.PledgedBalance {
+		return fmt.Sprintf("Balance (%d) of %s %s must not be less than PledgedBalance (%d)", position.Balance, position.AccountID, position.SecurityID, position.PledgedBalance)
+	}
+	return ""
+}
+
+//有質押券數的部位不可刪除
+func checkNoPledge(position *Position) string {
+
+	if position.PledgedBalance > 0 {
+		return fmt.Sprintf("%s %s has an active pledge of %d", position.AccountID, position.SecurityID, position.PledgedBalance)
+	}
+	return ""
+}
+
+func getPledgeStruct(stub shim.ChaincodeStubInterface, PledgeID string) (*Pledge, error) {
+
+	pledge := &Pledge{}
+	pledgeKey, err := stub.CreateCompositeKey(PledgeObjectType, []string{PledgeID})
+	if err != nil {
+		return pledge, err
+	}
+	pledgeAsBytes, err := stub.GetState(pledgeKey)
+	if err != nil {
+		return pledge, err
+	} else if pledgeAsBytes == nil {
+		return pledge, errors.New(fmt.Sprintf("Error: Pledge does not exist (%s)", PledgeID))
+	}
+	err = json.Unmarshal(pledgeAsBytes, pledge)
+	if err != nil {
+		return pledge, err
+	}
+	return pledge, nil
+}
+
+func putPledgeStruct(stub shim.ChaincodeStubInterface, pledge *Pledge) error {
+
+	pledgeKey, err := stub.CreateCompositeKey(PledgeObjectType, []string{pledge.PledgeID})
+	if err != nil {
+		return err
+	}
+	pledge.ObjectType = PledgeObjectType
+	pledge.UpdateTime = time.Now().Format(timelayout2)
+	pledgeAsBytes, err := json.Marshal(pledge)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(pledgeKey, pledgeAsBytes)
+	if err != nil {
+		return err
+	}
+	//Save index entries to state. Only the key name is needed, no need to store a duplicate copy of the pledge.
+	pledgorKey, err := stub.CreateCompositeKey(PledgorPledgeIndex, []string{pledge.AccountID, pledge.PledgeID})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(pledgorKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	pledgeeKey, err := stub.CreateCompositeKey(PledgeePledgeIndex, []string{pledge.PledgeeID, pledge.PledgeID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(pledgeeKey, []byte{0x00})
+}
+
+/*
+第 5 個參數為質押用途，第 6 個參數為出質人之清算銀行(BANK + 帳號前三碼)
+peer chaincode invoke -n mycc -c '{"Args":["pledgeSecurity","004000000001","A07103","5000000","BANKCBC","INTRADAY","BANK004"]}' -C myc
+*/
+func (s *SmartContract) pledgeSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 6)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "AccountID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
+	}
+	if len(args[3]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "PledgeeID must be a non-empty string")
+	}
+	AccountID := strings.ToUpper(args[0])
+	SecurityID := strings.ToUpper(args[1])
+	Quantity, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || Quantity <= 0 {
+		return errorResponse(codeArgumentFormat, "Quantity must be a positive integer")
+	}
+	PledgeeID := strings.ToUpper(args[3])
+	BankID := strings.ToUpper(args[5])
+	if BankID != "BANK"+SubString(AccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "BankID must be the bank of AccountID "+AccountID)
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if errMsg := verifyIdentity(APIstub, PledgeeID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if PledgeeID == BankID {
+		return errorResponse(codeArgumentFormat, "PledgeeID can not equal to the pledgor's bank")
+	}
+
+	position, err := getPositionStruct(APIstub, AccountID, SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	FreeBalance := getFreeBalance(position)
+	if Quantity > FreeBalance {
+		return errorResponse(codeInsufficientFreeBalance, fmt.Sprintf("Quantity (%d) > free balance (%d) of %s %s", Quantity, FreeBalance, AccountID, SecurityID))
+	}
+	position.PledgedBalance += Quantity
+	err = putPositionStruct(APIstub, position)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	pledge := &Pledge{}
+	pledge.PledgeID = APIstub.GetTxID()
+	pledge.AccountID = AccountID
+	pledge.BankID = position.BankID
+	pledge.SecurityID = SecurityID
+	pledge.PledgeeID = PledgeeID
+	pledge.Quantity = Quantity
+	pledge.Purpose = args[4]
+	pledge.PledgeStatus = pledgeActive
+	pledge.CreateTime = TimeNow2
+	err = putPledgeStruct(APIstub, pledge)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(pledge)
+}
+
+/*
+由質權人解除，Quantity 為空字串時解除全部剩餘券數
+peer chaincode invoke -n mycc -c '{"Args":["releasePledge","<PledgeID>","2000000","BANKCBC"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["releasePledge","<PledgeID>","","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) releasePledge(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "PledgeID must be a non-empty string")
+	}
+	pledge, err := getPledgeStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	BankID := strings.ToUpper(args[2])
+	if BankID != pledge.PledgeeID {
+		return errorResponse(codeUnauthorized, "Only the pledgee "+pledge.PledgeeID+" can release the pledge")
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if pledge.PledgeStatus != pledgeActive {
+		return errorResponse(codeInvalidStatus, "Pledge "+pledge.PledgeID+" is already "+pledge.PledgeStatus)
+	}
+	Remaining := pledge.Quantity - pledge.ReleasedQuantity
+	Quantity := Remaining
+	if args[1] != "" {
+		Quantity, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil || Quantity <= 0 {
+			return errorResponse(codeArgumentFormat, "Quantity must be a positive integer")
+		}
+		if Quantity > Remaining {
+			return errorResponse(codeArgumentFormat, fmt.Sprintf("Quantity must not be greater than the pledged quantity (%d)", Remaining))
+		}
+	}
+
+	position, err := getPositionStruct(APIstub, pledge.AccountID, pledge.SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if Quantity > position.PledgedBalance {
+		return errorResponse(codeInvalidStatus, fmt.Sprintf("Quantity (%d) > PledgedBalance (%d) of %s %s", Quantity, position.PledgedBalance, pledge.AccountID, pledge.SecurityID))
+	}
+	position.PledgedBalance -= Quantity
+	err = putPositionStruct(APIstub, position)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	pledge.ReleasedQuantity += Quantity
+	if pledge.ReleasedQuantity == pledge.Quantity {
+		pledge.PledgeStatus = pledgeReleased
+	}
+	err = putPledgeStruct(APIstub, pledge)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(pledge)
+}
+
+/*
+Role 為 PLEDGOR 時 ID 為出質人帳號，PLEDGEE 時 ID 為質權人；含已解除者
+peer chaincode query -n mycc -c '{"Args":["queryPledges","PLEDGOR","004000000001"]}' -C myc
+peer chaincode query -n mycc -c '{"Args":["queryPledges","PLEDGEE","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) queryPledges(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 2 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
+	}
+	Role := strings.ToUpper(args[0])
+	ID := strings.ToUpper(args[1])
+	var index string
+	if Role == pledgeRolePledgor {
+		index = PledgorPledgeIndex
+	} else if Role == pledgeRolePledgee {
+		index = PledgeePledgeIndex
+	} else {
+		return errorResponse(codeArgumentFormat, "Role must be PLEDGOR or PLEDGEE")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(index, []string{ID})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	pledges := []Pledge{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		pledge, err := getPledgeStruct(APIstub, compositeKeyParts[1])
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		pledges = append(pledges, *pledge)
+	}
+	return dataResponse(pledges)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const PositionObjectType string = "Position"
+const SecurityPositionIndex string = "SecurityPosition"
+
+type Position struct {
+	ObjectType                string   `json:"docType"`                   // default set to "Position"
+	AccountID                 string   `json:"AccountID"`                 // 客戶帳號
+	BankID                    string   `json:"BankID"`                    // 清算銀行代號(三碼)
+	SecurityID                string   `json:"SecurityID"`                // 公債代號
+	SecurityAmount            int64    `json:"SecurityAmount"`            // 帳戶款項金額
+	Balance                   int64    `json:"Balance"`                   // 帳戶券項可動用餘額
+	Position                  int64    `json:"Position"`                  // 帳戶券項餘額
+	TotalPayment              int64    `json:"TotalPayment"`              // 交易總計券項金額
+	PendingBalance            int64    `json:"PendingBalance"`            // 未比對前可動用餘額
+	OwnedAmount               int64    `json:"OwnedAmount"`               // 登錄之公債金額
+	OwnedRepay                int64    `json:"OwnedRepay"`                // 登錄之公債還本付息
+	OwnedInterest             int64    `json:"OwnedInterest"`             // 登錄之公債利息
+	OwnedDurationInterest     int64    `json:"OwnedDurationInterest"`     // 登錄之公債利息(每一期)
+	OwnedPaidDurationInterest int64    `json:"OwnedPaidDurationInterest"` // 登錄之公債已付利息
+	OwnedDurationDate         []string `json:"OwnedDurationDate"`         // 登錄之公債期數日期
+	Avaliable                 int      `json:"Avaliable"`                 // 登錄之可用性
+	PledgedBalance            int64    `json:"PledgedBalance"`            // 質押券數(不可動用)
+	CreateTime                string   `json:"CreateTime"`
+	UpdateTime                string   `json:"UpdateTime"`
+}
+
+/*
+每一個(AccountID, SecurityID)只有一筆持有部位，
+Account.Assets 與 Security.Owners 皆由此投影產生。
+Key: Position~AccountID~SecurityID
+Index: SecurityPosition~SecurityID~AccountID
+
+1.客戶帳號
+2.清算銀行代號
+3.公債代號
+4.帳戶款項金額
+5.帳戶券項可動用餘額(=登錄之公債面額)
+6.帳戶券項餘額
+7.交易總計券項金額
+8.未比對前可動用餘額
+9.登錄之公債金額
+10.登錄之公債還本付息
+11.登錄之公債利息
+12.登錄之公債利息(每一期)
+13.登錄之公債已付利息
+14.登錄之公債期數日期
+15.登錄之可用性
+16.質押券數(見 Pledge.go)
+17.建立時間
+18.異動時間
+*/
+
+func newPosition(AccountID string, BankID string, SecurityID string) *Position {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+	position := &Position{}
+	position.ObjectType = PositionObjectType
+	position.AccountID = AccountID
+	position.BankID = BankID
+	if position.BankID == "" {
+		position.BankID = SubString(AccountID, 0, 3)
+	}
+	position.SecurityID = SecurityID
+	position.CreateTime = TimeNow2
+	position.UpdateTime = TimeNow2
+	return position
+}
+
+func getPositionStruct(
+	stub shim.ChaincodeStubInterface,
+	AccountID string,
+	SecurityID string) (*Position, error) {
+
+	var errMsg string
+	position := &Position{}
+	positionKey, err := stub.CreateCompositeKey(PositionObjectType, []string{AccountID, SecurityID})
+	if err != nil {
+		return position, err
+	}
+	positionAsBytes, err := stub.GetState(positionKey)
+	if err != nil {
+		return position, err
+	} else if positionAsBytes == nil {
+		errMsg = fmt.Sprintf("Error: Position does not exist (%s,%s)", AccountID, SecurityID)
+		return position, errors.New(errMsg)
+	}
+	err = json.Unmarshal(positionAsBytes, position)
+	if err != nil {
+		return position, err
+	}
+	return position, nil
+}
+
+func putPositionStruct(stub shim.ChaincodeStubInterface, position *Position) error {
+
+	positionKey, err := stub.CreateCompositeKey(PositionObjectType, []string{position.AccountID, position.SecurityID})
+	if err != nil {
+		return err
+	}
+	indexKey, err := stub.CreateCompositeKey(SecurityPositionIndex, []string{position.SecurityID, position.AccountID})
+	if err != nil {
+		return err
+	}
+	position.ObjectType = PositionObjectType
+	position.UpdateTime = time.Now().Format(timelayout2)
+	notePositionIncrease(stub, positionKey, position)
+	positionAsBytes, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(positionKey, positionAsBytes)
+	if err != nil {
+		return err
+	}
+	//Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the position.
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func delPositionStruct(stub shim.ChaincodeStubInterface, AccountID string, SecurityID string) error {
+
+	positionKey, err := stub.CreateCompositeKey(PositionObjectType, []string{AccountID, SecurityID})
+	if err != nil {
+		return err
+	}
+	indexKey, err := stub.CreateCompositeKey(SecurityPositionIndex, []string{SecurityID, AccountID})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(positionKey)
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getAccountPositions(stub shim.ChaincodeStubInterface, AccountID string) ([]Position, error) {
+
+	return getPositionsByPartialKey(stub, []string{AccountID})
+}
+
+func getAllPositions(stub shim.ChaincodeStubInterface) ([]Position, error) {
+
+	return getPositionsByPartialKey(stub, []string{})
+}
+
+func getPositionsByPartialKey(stub shim.ChaincodeStubInterface, keys []string) ([]Position, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(PositionObjectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	positions := []Position{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		position := Position{}
+		err = json.Unmarshal(queryResponse.Value, &position)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+func getSecurityPositions(stub shim.ChaincodeStubInterface, SecurityID string) ([]Position, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SecurityPositionIndex, []string{SecurityID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	positions := []Position{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		position, err := getPositionStruct(stub, compositeKeyParts[1], compositeKeyParts[0])
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, *position)
+	}
+	return positions, nil
+}
+
+func positionToAsset(position Position) Asset {
+
+	var asset Asset
+	asset.SecurityID = position.SecurityID
+	asset.SecurityAmount = position.SecurityAmount
+	asset.Balance = position.Balance
+	asset.Position = position.Position
+	asset.TotalPayment = position.TotalPayment
+	asset.PendingBalance = position.PendingBalance
+	return asset
+}
+
+func positionToOwner(position Position) Owner {
+
+	var owner Owner
+	owner.OwnedAccountID = position.AccountID
+	owner.OwnedBankID = position.BankID
+	owner.OwnedAmount = position.OwnedAmount
+	owner.OwnedBalance = position.Balance
+	owner.OwnedRepay = position.OwnedRepay
+	owner.OwnedInterest = position.OwnedInterest
+	owner.OwnedDurationInterest = position.OwnedDurationInterest
+	owner.OwnedPaidDurationInterest = position.OwnedPaidDurationInterest
+	owner.OwnedDurationDate = position.OwnedDurationDate
+	owner.Avaliable = position.Avaliable
+	return owner
+}
+
+func ownerToPosition(SecurityID string, owner Owner) *Position {
+
+	position := newPosition(owner.OwnedAccountID, owner.OwnedBankID, SecurityID)
+	position.Balance = owner.OwnedBalance
+	position.Position = owner.OwnedBalance
+	position.PendingBalance = owner.OwnedBalance
+	position.OwnedAmount = owner.OwnedAmount
+	position.OwnedRepay = owner.OwnedRepay
+	position.OwnedInterest = owner.OwnedInterest
+	position.OwnedDurationInterest = owner.OwnedDurationInterest
+	position.OwnedPaidDurationInterest = owner.OwnedPaidDurationInterest
+	position.OwnedDurationDate = owner.OwnedDurationDate
+	position.Avaliable = owner.Avaliable
+	return position
+}
+
+//Account.Assets 投影
+func getAccountAssets(stub shim.ChaincodeStubInterface, AccountID string) ([]Asset, error) {
+
+	positions, err := getAccountPositions(stub, AccountID)
+	if err != nil {
+		return nil, err
+	}
+	assets := []Asset{}
+	for _, val := range positions {
+		assets = append(assets, positionToAsset(val))
+	}
+	return assets, nil
+}
+
+//Security.Owners 投影
+func getSecurityOwners(stub shim.ChaincodeStubInterface, SecurityID string) ([]Owner, error) {
+
+	positions, err := getSecurityPositions(stub, SecurityID)
+	if err != nil {
+		return nil, err
+	}
+	owners := []Owner{}
+	for _, val := range positions {
+		owners = append(owners, positionToOwner(val))
+	}
+	return owners, nil
+}
+
+//依券數重算持有部位之利息欄位(同updateOwnerInterest)，
+//利息及付息日以 Today 生效的條件計算，已付利息以各付息日當時生效的條件計算
+func setPositionInterest(timeline []SecurityTerms, position *Position, Today string) {
+
+	terms := getTermsInForce(timeline, Today)
+	position.OwnedInterest, position.OwnedDurationInterest = getTermsInterest(terms, position.Balance)
+	position.OwnedRepay = position.Balance + position.OwnedInterest
+	position.OwnedPaidDurationInterest = 0
+	position.OwnedDurationDate = nil
+	j := 0
+	for j < terms.RepayPeriod {
+		NextPayInterestDate, _ := generateMaturity(terms.IssueDate, j+1, 0, 0)
+		position.OwnedDurationDate = append(position.OwnedDurationDate, NextPayInterestDate)
+		if getTermsDate(Today) >= getTermsDate(NextPayInterestDate) {
+			_, DurationInterest := getTermsInterest(getTermsInForce(timeline, NextPayInterestDate), position.Balance)
+			position.OwnedPaidDurationInterest += DurationInterest
+		}
+		j = j + 1
+	}
+}
+
+/*
+Moves the legacy Security.Owners and Account.Assets arrays of a SecurityID
+into Position records and clears the arrays.
+
+peer chaincode invoke -n mycc -c '{"Args":["migratePositions","A07103","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) migratePositions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	SecurityID := strings.ToUpper(args[0])
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+
+	security, err := getSecurityStructFromID(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	accounts, err := getAllAccountStruct(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	positions := make(map[string]*Position)
+	var AccountIDs []string
+	for _, val := range security.Owners {
+		if _, ok := positions[val.OwnedAccountID]; ok != true {
+			AccountIDs = append(AccountIDs, val.OwnedAccountID)
+		}
+		positions[val.OwnedAccountID] = ownerToPosition(SecurityID, val)
+	}
+	for _, account := range accounts {
+		var doflg bool
+		doflg = false
+		var assets []Asset
+		filteredAssets := assets[:0]
+		for _, val := range account.Assets {
+			if val.SecurityID != SecurityID {
+				filteredAssets = append(filteredAssets, val)
+				continue
+			}
+			position, ok := positions[account.AccountID]
+			if ok != true {
+				position = newPosition(account.AccountID, account.BankID, SecurityID)
+				positions[account.AccountID] = position
+				AccountIDs = append(AccountIDs, account.AccountID)
+			}
+			//帳戶層級為準
+			position.SecurityAmount = val.SecurityAmount
+			position.Balance = val.Balance
+			position.Position = val.Position
+			position.TotalPayment = val.TotalPayment
+			position.PendingBalance = val.PendingBalance
+			doflg = true
+		}
+		if doflg == true {
+			account.Assets = filteredAssets
+			accountAsBytes, err := json.Marshal(account)
+			if err != nil {
+				return errorResponse(codeStateError, err.Error())
+			}
+			err = APIstub.PutState(account.AccountID, accountAsBytes)
+			if err != nil {
+				return errorResponse(codeStateError, err.Error())
+			}
+		}
+	}
+	for _, AccountID := range AccountIDs {
+		err = putPositionStruct(APIstub, positions[AccountID])
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+
+	security.Owners = nil
+	securityAsBytes, err := json.Marshal(security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(SecurityID, securityAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	return shim.Success(nil)
+}
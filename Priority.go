@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//交割優先順序，數字小者優先
+const priorityCentralBank int = 1 //央行業務
+const priorityInterbank int = 2   //跨行
+const priorityCustomer int = 3    //客戶(自行)
+
+type QueuePosition struct {
+	TXID          string `json:"TXID"`
+	TXStatus      string `json:"TXStatus"`
+	TXPriority    int    `json:"TXPriority"`
+	QueuePosition int    `json:"QueuePosition"` //於佇列中的順位(1 起算)
+	QueueLength   int    `json:"QueueLength"`   //佇列中尚未完成的交易筆數
+}
+
+/*
+1.交易序號
+2.交易狀態
+3.交割優先順序
+4.佇列順位
+5.佇列筆數
+*/
+
+func getDefaultTXPriority(BankFrom string, BankTo string) int {
+
+	if BankFrom == "BK"+AdminBankID || BankTo == "BK"+AdminBankID {
+		return priorityCentralBank
+	}
+	if BankFrom != BankTo {
+		return priorityInterbank
+	}
+	return priorityCustomer
+}
+
+//舊資料沒有 TXPriority，視為客戶交易
+func getTXPriority(transaction Transaction) int {
+
+	if transaction.TXPriority <= 0 {
+		return priorityCustomer
+	}
+	return transaction.TXPriority
+}
+
+//依優先順序、再依進入佇列先後，回傳 Transactions 的 key
+func getQueueOrder(transactions []Transaction) []int {
+
+	order := make([]int, len(transactions))
+	for key := range transactions {
+		order[key] = key
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return getTXPriority(transactions[order[i]]) < getTXPriority(transactions[order[j]])
+	})
+	return order
+}
+
+func isQueuedStatus(TXStatus string) bool {
+
+	return TXStatus == "Pending" || TXStatus == "Matched" || TXStatus == "PaymentError" || TXStatus == "Waiting4Payment"
+}
+
+//交易所在的佇列；營業日的佇列找不到時，以交易日(TradeDate)或交易序號中的日期為準
+func getTransactionQueue(stub shim.ChaincodeStubInterface, TXID string) (*QueuedTransaction, error) {
+
+	queuedTX, err := getQueueStructFromID(stub, getQueueTXKEY(stub))
+	if err == nil {
+		for _, val := range queuedTX.TXIDs {
+			if val == TXID {
+				return queuedTX, nil
+			}
+		}
+	}
+	transaction, err := getTransactionStructFromID(stub, TXID)
+	if err == nil && transaction.TradeDate != "" {
+		//跨日比對時移至之後營業日佇列的交易
+		dates := getMatchingDates(stub, getQueueTXKEY(stub), getMatchingWindow(stub))
+		for i := len(dates) - 1; i >= 0; i-- {
+			queuedTX, err := getQueueStructFromID(stub, dates[i])
+			if err != nil {
+				continue
+			}
+			for _, val := range queuedTX.TXIDs {
+				if val == TXID {
+					return queuedTX, nil
+				}
+			}
+		}
+		return getQueueStructFromID(stub, transaction.TradeDate)
+	}
+	return getQueueStructFromID(stub, SubString(TXID, 18, 8))
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["updateTransactionPriority","BK004S00400000000120180415070724","1","BANK004"]}' -C myc
+
+只有轉出銀行(BankFrom)或 CBC 可調整尚未完成交易的優先順序
+*/
+func (s *SmartContract) updateTransactionPriority(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "TXID must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "TXPriority must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "BankID must be a non-empty string")
+	}
+	TXID := strings.ToUpper(args[0])
+	TXPriority, err := strconv.Atoi(args[1])
+	if err != nil || TXPriority < priorityCentralBank || TXPriority > priorityCustomer {
+		return errorResponse(codeArgumentFormat, "TXPriority must be 1, 2 or 3")
+	}
+	BankID := strings.ToUpper(args[2])
+
+	transaction, err := getTransactionStructFromID(APIstub, TXID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if verifyAdminIdentity(APIstub, BankID) != "" {
+		if BankID != "BANK"+SubString(transaction.TXFrom, 0, 3) {
+			return errorResponse(codeUnauthorized, "Only BankFrom or BANK"+AdminBankID+" can change TXPriority")
+		}
+		if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+			return errorResponse(codeUnauthorized, errMsg)
+		}
+	}
+	if isQueuedStatus(transaction.TXStatus) != true {
+		return errorResponse(codeInvalidStatus, "TXStatus of transaction was "+transaction.TXStatus)
+	}
+
+	transaction.TXPriority = TXPriority
+	transaction.UpdateTime = TimeNow2
+	transactionAsBytes, err := json.Marshal(transaction)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(TXID, transactionAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	queuedTX, err := getTransactionQueue(APIstub, TXID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	for key, val := range queuedTX.TXIDs {
+		if val == TXID {
+			queuedTX.Transactions[key].TXPriority = TXPriority
+			queuedTX.Transactions[key].UpdateTime = TimeNow2
+		}
+	}
+	queuedAsBytes, err := json.Marshal(queuedTX)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(queuedTX.TXKEY, queuedAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	historyTX, err := getHistoryTransactionStructFromID(APIstub, "H"+queuedTX.TXKEY)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	for key, val := range historyTX.TXIDs {
+		if val == TXID {
+			historyTX.Transactions[key].TXPriority = TXPriority
+			historyTX.Transactions[key].UpdateTime = TimeNow2
+		}
+	}
+	historyAsBytes, err := json.Marshal(historyTX)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(historyTX.TXKEY, historyAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryQueuePosition","BK004S00400000000120180415070724"]}' -C myc
+
+func (s *SmartContract) queryQueuePosition(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	TXID := strings.ToUpper(args[0])
+	queuedTX, err := getTransactionQueue(APIstub, TXID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+
+	position := QueuePosition{}
+	position.TXID = TXID
+	for _, key := range getQueueOrder(queuedTX.Transactions) {
+		val := queuedTX.Transactions[key]
+		if val.TXID == TXID {
+			position.TXStatus = val.TXStatus
+			position.TXPriority = getTXPriority(val)
+		}
+		if isQueuedStatus(val.TXStatus) != true {
+			continue
+		}
+		position.QueueLength++
+		if val.TXID == TXID {
+			position.QueuePosition = position.QueueLength
+		}
+	}
+	if position.TXStatus == "" {
+		return errorResponse(codeNotFound, "Failed to find Queued TXID "+TXID)
+	}
+	return dataResponse(position)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+PaymentError / Waiting4Payment 的交易券款已先行異動，等待轉入方款項足夠。
+任一 Position 的券數或款數增加(putPositionStruct 記錄於 txStub)且本交易已寫入目前營業日佇列時，
+Invoke 於交易結束前呼叫 retryQueuedTransactions 依序重新檢核(其他交易由 resolveGridlock 檢核)：
+1.逐筆(依優先順序及進入佇列先後)：轉入方款數 >= 0，且不依賴其他尚未完成交易的收款
+2.互抵(offsetting)：其餘交易視為一組，剔除不足者，剩下的整組一起完成
+*/
+
+//佇列中一組 PaymentError / Waiting4Payment 交易，以賣方(TXType = S)交易為準
+type queuedPair struct {
+	seller    Transaction
+	buyerTXID string
+}
+
+func positionKeyOf(AccountID string, SecurityID string) string {
+	return AccountID + "~" + SecurityID
+}
+
+//由 putPositionStruct 呼叫，記錄券數或款數增加的 Position
+func notePositionIncrease(stub shim.ChaincodeStubInterface, positionKey string, position *Position) {
+
+	t, ok := stub.(*txStub)
+	if ok != true {
+		return
+	}
+	old := Position{}
+	oldAsBytes, err := stub.GetState(positionKey)
+	if err == nil && oldAsBytes != nil {
+		json.Unmarshal(oldAsBytes, &old)
+	}
+	if position.Balance > old.Balance || position.SecurityAmount > old.SecurityAmount {
+		t.increased[positionKeyOf(position.AccountID, position.SecurityID)] = true
+	}
+}
+
+//目前營業日，尚未 openBusinessDay 時為今日
+func getQueueTXKEY(stub shim.ChaincodeStubInterface) string {
+
+	TXKEY, err := getCurrentBusinessDate(stub)
+	if err != nil || TXKEY == "" {
+		TXKEY = SubString(getTxTime(stub).Format(timelayout), 0, 8)
+	}
+	return TXKEY
+}
+
+//本交易已寫入目前營業日的佇列(轉帳、核准及交割)，重新檢核不會把佇列另外加入其他交易的 read set
+func isQueueWritten(t *txStub) bool {
+
+	_, ok := t.writes[getQueueTXKEY(t)]
+	return ok
+}
+
+//暫停交割(holdInstruction)的交易序號
+func getHeldTXIDs(queuedTX *QueuedTransaction) map[string]bool {
+
+	held := make(map[string]bool)
+	for _, val := range queuedTX.Transactions {
+		if val.IsHeld == true {
+			held[val.TXID] = true
+		}
+	}
+	return held
+}
+
+func getQueuedPairs(queuedTX *QueuedTransaction) []queuedPair {
+
+	var pairs []queuedPair
+	held := getHeldTXIDs(queuedTX)
+	for _, key := range getQueueOrder(queuedTX.Transactions) {
+		val := queuedTX.Transactions[key]
+		if held[val.TXID] == true || held[val.MatchedTXID] == true {
+			continue
+		}
+		if (val.TXStatus == "PaymentError" || val.TXStatus == "Waiting4Payment") && val.TXType == "S" && val.MatchedTXID != "" {
+			pairs = append(pairs, queuedPair{val, val.MatchedTXID})
+		}
+	}
+	return pairs
+}
+
+/*
+回傳 group 內轉入方款數不足的交易(賣方交易序號)：
+目前款數 - 不在 group 內之佇列交易應收款 < 0。
+款項已於比對時異動，故目前款數已扣除 group 內全部應付款。
+*/
+func getUnfundedQueuedPairs(stub shim.ChaincodeStubInterface, pairs []queuedPair, group map[string]bool) []string {
+
+	var unfunded []string
+	pending := make(map[string]int64)
+	for _, pair := range pairs {
+		if group[pair.seller.TXID] != true {
+			pending[positionKeyOf(pair.seller.TXFrom, pair.seller.SecurityID)] += pair.seller.SecurityAmount
+		}
+	}
+	for _, pair := range pairs {
+		if group[pair.seller.TXID] != true {
+			continue
+		}
+		position, err := getPositionStruct(stub, pair.seller.TXTo, pair.seller.SecurityID)
+		if err != nil || position.SecurityAmount-pending[positionKeyOf(pair.seller.TXTo, pair.seller.SecurityID)] < 0 {
+			unfunded = append(unfunded, pair.seller.TXID)
+		}
+	}
+	return unfunded
+}
+
+func finishQueuedPair(stub shim.ChaincodeStubInterface, TXKEY string, pair queuedPair) error {
+
+	TXID := pair.seller.TXID
+	MatchedTXID := pair.buyerTXID
+	err := updateQueuedTransactionApproveStatus(stub, TXKEY, TXID, MatchedTXID, "Finished")
+	if err != nil {
+		return err
+	}
+	err = updateHistoryTransactionApproveStatus(stub, "H"+TXKEY, TXID, MatchedTXID, "Finished")
+	if err != nil {
+		return err
+	}
+	err = updateTransactionStatus(stub, TXID, "Finished", MatchedTXID)
+	if err != nil {
+		return err
+	}
+	err = updateTransactionStatus(stub, MatchedTXID, "Finished", TXID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//回傳完成的交易序號
+func retryQueuedTransactions(stub shim.ChaincodeStubInterface, skipWritten bool) ([]string, error) {
+
+	var finished []string
+	TXKEY := getQueueTXKEY(stub)
+	queuedTX, err := getQueueStructFromID(stub, TXKEY)
+	if err != nil {
+		return finished, nil
+	}
+	pairs := getQueuedPairs(queuedTX)
+	//暫停中或債券狀態不可轉帳的交易留在佇列，解除後再重新檢核
+	var unhalted []queuedPair
+	for _, pair := range pairs {
+		if checkHalt(stub, pair.seller.SecurityID, pair.seller.TXFrom, pair.seller.TXTo) == "" && checkSecurityIDStatus(stub, pair.seller.SecurityID, securityOpTransfer) == "" {
+			unhalted = append(unhalted, pair)
+		}
+	}
+	pairs = unhalted
+	//由 Invoke 觸發時，本交易中才進入佇列或異動狀態的交易，留待之後重新檢核
+	if t, ok := stub.(*txStub); ok == true && skipWritten == true {
+		var queued []queuedPair
+		for _, pair := range pairs {
+			_, ok1 := t.writes[pair.seller.TXID]
+			_, ok2 := t.writes[pair.buyerTXID]
+			if ok1 != true && ok2 != true {
+				queued = append(queued, pair)
+			}
+		}
+		pairs = queued
+	}
+
+	//1.逐筆，完成一筆後從頭再檢核
+	doflg := true
+	for doflg == true {
+		doflg = false
+		for key, pair := range pairs {
+			if len(getUnfundedQueuedPairs(stub, pairs, map[string]bool{pair.seller.TXID: true})) > 0 {
+				continue
+			}
+			err = finishQueuedPair(stub, TXKEY, pair)
+			if err != nil {
+				return finished, err
+			}
+			finished = append(finished, pair.seller.TXID, pair.buyerTXID)
+			pairs = append(pairs[:key], pairs[key+1:]...)
+			doflg = true
+			break
+		}
+	}
+
+	//2.互抵：剔除轉入方不足的交易後重新檢核，直到其餘交易皆足夠
+	group := make(map[string]bool)
+	for _, pair := range pairs {
+		group[pair.seller.TXID] = true
+	}
+	for len(group) > 1 {
+		unfunded := getUnfundedQueuedPairs(stub, pairs, group)
+		if len(unfunded) == 0 {
+			break
+		}
+		for _, TXID := range unfunded {
+			delete(group, TXID)
+		}
+	}
+	if len(group) > 1 {
+		for _, pair := range pairs {
+			if group[pair.seller.TXID] != true {
+				continue
+			}
+			err = finishQueuedPair(stub, TXKEY, pair)
+			if err != nil {
+				return finished, err
+			}
+			finished = append(finished, pair.seller.TXID, pair.buyerTXID)
+		}
+	}
+	return finished, nil
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["resolveGridlock","BANKCBC"]}' -C myc
+
+func (s *SmartContract) resolveGridlock(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 1)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[0]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	finished, err := retryQueuedTransactions(APIstub, false)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	finishedAsBytes, err := json.Marshal(finished)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(finishedAsBytes)
+}
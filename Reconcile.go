@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const ReconcileObjectType string = "Reconcile"
+const reconcileScopeSecurity string = "SECURITY"
+const reconcileScopeBank string = "BANK"
+
+//帳戶代號範圍，用來掃描全部帳戶
+const accountStartKey string = "000000000000"
+const accountEndKey string = "999999999999"
+
+type ReconcileMismatch struct {
+	Scope      string `json:"Scope"`      // SecurityTotal, BankTotal, Security
+	ID         string `json:"ID"`         // AccountID, BankID or SecurityID
+	SecurityID string `json:"SecurityID"` // SecurityID
+	Field      string `json:"Field"`      // mismatched field
+	Expected   int64  `json:"Expected"`   // value recomputed from Position records
+	Recorded   int64  `json:"Recorded"`   // value recorded in the aggregate
+}
+
+/*
+1.不一致的資料類別
+2.不一致的帳號、銀行代號或公債代號
+3.公債代號
+4.不一致的欄位
+5.由持有部位(Position)重新計算之數值
+6.帳上記錄之數值
+*/
+
+type ReconcileReport struct {
+	ObjectType string              `json:"docType"`    // default set to "Reconcile"
+	Scope      string              `json:"Scope"`      // SECURITY or BANK
+	ID         string              `json:"ID"`         // SecurityID or BankID
+	IsBalanced bool                `json:"IsBalanced"` // true if no mismatch was found
+	Mismatches []ReconcileMismatch `json:"Mismatches"`
+	CheckTime  string              `json:"CheckTime"`
+}
+
+/*
+1.對帳範圍：SECURITY 或 BANK
+2.公債代號或銀行代號
+3.是否平衡
+4.不一致明細
+5.對帳時間
+*/
+
+/*
+The Position records (Position.Balance) are the source of truth.
+Security.SecurityTotals[].TotalBalance, Bank.BankTotals[].TotalBalance (checkpoint
+plus outstanding deltas) and Security.TotalAmount - Security.Balance are
+recomputed from them and compared.
+
+peer chaincode query -n mycc -c '{"Args":["reconcile","SECURITY","A07103"]}' -C myc
+peer chaincode query -n mycc -c '{"Args":["reconcile","BANK","BANK002"]}' -C myc
+*/
+func (s *SmartContract) reconcile(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Scope must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ID must be a non-empty string")
+	}
+	Scope := strings.ToUpper(args[0])
+	ID := strings.ToUpper(args[1])
+
+	positions, err := getAllPositions(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	var mismatches []ReconcileMismatch
+	if Scope == reconcileScopeSecurity {
+		security, err := getSecurityStructFromID(APIstub, ID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		mismatches = reconcileSecurity(APIstub, security, positions, "")
+	} else if Scope == reconcileScopeBank {
+		BankCode := getBankCode(ID)
+		SecurityIDs, err := getBankSecurityIDs(APIstub, BankCode, positions)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		for _, SecurityID := range SecurityIDs {
+			security, err := getSecurityStructFromID(APIstub, SecurityID)
+			if err != nil {
+				mismatches = append(mismatches, ReconcileMismatch{Scope: "Security", ID: SecurityID, SecurityID: SecurityID, Field: "SecurityID"})
+				continue
+			}
+			mismatches = append(mismatches, reconcileSecurity(APIstub, security, positions, BankCode)...)
+		}
+	} else {
+		return errorResponse(codeArgumentFormat, "Scope must be SECURITY or BANK")
+	}
+
+	report := ReconcileReport{}
+	report.ObjectType = ReconcileObjectType
+	report.Scope = Scope
+	report.ID = ID
+	report.IsBalanced = len(mismatches) == 0
+	report.Mismatches = mismatches
+	report.CheckTime = time.Now().Format(timelayout2)
+
+	return dataResponse(report)
+}
+
+/*
+Rebuilds the aggregates of a SecurityID (all banks) or of a bank (all of its
+securities) from the Position records.
+
+peer chaincode invoke -n mycc -c '{"Args":["repairTotals","SECURITY","A07103","BANKCBC"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["repairTotals","BANK","BANK002","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) repairTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Scope must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ID must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	Scope := strings.ToUpper(args[0])
+	ID := strings.ToUpper(args[1])
+	if errMsg := verifyAdminIdentity(APIstub, args[2]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+
+	positions, err := getAllPositions(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	if Scope == reconcileScopeSecurity {
+		err = repairSecurityTotals(APIstub, ID, positions, "")
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	} else if Scope == reconcileScopeBank {
+		BankCode := getBankCode(ID)
+		SecurityIDs, err := getBankSecurityIDs(APIstub, BankCode, positions)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		for _, SecurityID := range SecurityIDs {
+			err = repairSecurityTotals(APIstub, SecurityID, positions, BankCode)
+			if err != nil {
+				return errorResponse(codeStateError, err.Error())
+			}
+		}
+	} else {
+		return errorResponse(codeArgumentFormat, "Scope must be SECURITY or BANK")
+	}
+
+	return shim.Success(nil)
+}
+
+func getAllAccountStruct(stub shim.ChaincodeStubInterface) ([]Account, error) {
+
+	resultsIterator, err := stub.GetStateByRange(accountStartKey, accountEndKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	accounts := []Account{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		account := Account{}
+		err = json.Unmarshal(queryResponse.Value, &account)
+		if err != nil || account.ObjectType != accountObjectType {
+			//QueuedTX(YYYYMMDD) 也落在帳號範圍內
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+//BANK002 -> 002
+func getBankCode(BankID string) string {
+	BankCode := strings.ToUpper(BankID)
+	if strings.HasPrefix(BankCode, "BANK") {
+		BankCode = BankCode[len("BANK"):]
+	}
+	return SubString(BankCode, 0, 3)
+}
+
+func getBankSecurityIDs(stub shim.ChaincodeStubInterface, BankCode string, positions []Position) ([]string, error) {
+
+	var SecurityIDs []string
+	seen := make(map[string]bool)
+
+	bank, err := getBankStructFromID(stub, "BANK"+BankCode)
+	if err != nil {
+		return SecurityIDs, err
+	}
+	BankTotals, err := getEffectiveBankTotals(stub, bank)
+	if err != nil {
+		return SecurityIDs, err
+	}
+	for _, val := range BankTotals {
+		if seen[val.SecurityID] != true {
+			SecurityIDs = append(SecurityIDs, val.SecurityID)
+			seen[val.SecurityID] = true
+		}
+	}
+	for _, position := range positions {
+		if SubString(position.AccountID, 0, 3) != BankCode {
+			continue
+		}
+		if seen[position.SecurityID] != true {
+			SecurityIDs = append(SecurityIDs, position.SecurityID)
+			seen[position.SecurityID] = true
+		}
+	}
+	return SecurityIDs, nil
+}
+
+//帳戶持有之券數(AccountID -> Balance)與清算銀行(AccountID -> BankCode)
+func getAccountHoldings(positions []Position, SecurityID string, BankCode string) (map[string]int64, map[string]string, []string) {
+
+	balances := make(map[string]int64)
+	banks := make(map[string]string)
+	var AccountIDs []string
+	for _, position := range positions {
+		accountBank := SubString(position.AccountID, 0, 3)
+		if BankCode != "" && accountBank != BankCode {
+			continue
+		}
+		if position.SecurityID == SecurityID {
+			if _, ok := balances[position.AccountID]; ok != true {
+				AccountIDs = append(AccountIDs, position.AccountID)
+			}
+			balances[position.AccountID] += position.Balance
+			banks[position.AccountID] = accountBank
+		}
+	}
+	return balances, banks, AccountIDs
+}
+
+func reconcileSecurity(stub shim.ChaincodeStubInterface, security *Security, positions []Position, BankCode string) []ReconcileMismatch {
+
+	SecurityID := security.SecurityID
+	var mismatches []ReconcileMismatch
+
+	balances, banks, AccountIDs := getAccountHoldings(positions, SecurityID, BankCode)
+
+	//1.Security.SecurityTotals
+	bankSums := make(map[string]int64)
+	var BankCodes []string
+	for _, AccountID := range AccountIDs {
+		if _, ok := bankSums[banks[AccountID]]; ok != true {
+			BankCodes = append(BankCodes, banks[AccountID])
+		}
+		bankSums[banks[AccountID]] += balances[AccountID]
+	}
+	SecurityTotals, err := getEffectiveSecurityTotals(stub, security)
+	if err != nil {
+		return append(mismatches, ReconcileMismatch{Scope: "SecurityTotal", ID: SecurityID, SecurityID: SecurityID, Field: "TotalDelta"})
+	}
+	totalSeen := make(map[string]bool)
+	for _, val := range SecurityTotals {
+		if BankCode != "" && val.BankID != BankCode {
+			continue
+		}
+		totalSeen[val.BankID] = true
+		if val.TotalBalance != bankSums[val.BankID] {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "SecurityTotal", ID: val.BankID, SecurityID: SecurityID, Field: "TotalBalance", Expected: bankSums[val.BankID], Recorded: val.TotalBalance})
+		}
+		if _, ok := bankSums[val.BankID]; ok != true {
+			BankCodes = append(BankCodes, val.BankID)
+		}
+	}
+	for _, code := range BankCodes {
+		if totalSeen[code] != true && bankSums[code] != 0 {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "SecurityTotal", ID: code, SecurityID: SecurityID, Field: "TotalBalance", Expected: bankSums[code], Recorded: 0})
+		}
+	}
+
+	//2.Bank.BankTotals
+	for _, code := range BankCodes {
+		bank, err := getBankStructFromID(stub, "BANK"+code)
+		if err != nil {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "BankTotal", ID: "BANK" + code, SecurityID: SecurityID, Field: "BankID", Expected: bankSums[code], Recorded: 0})
+			continue
+		}
+		BankTotals, err := getEffectiveBankTotals(stub, bank)
+		if err != nil {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "BankTotal", ID: bank.BankID, SecurityID: SecurityID, Field: "TotalDelta", Expected: bankSums[code], Recorded: 0})
+			continue
+		}
+		var recorded int64
+		for _, val := range BankTotals {
+			if val.SecurityID == SecurityID {
+				recorded += val.TotalBalance
+			}
+		}
+		if recorded != bankSums[code] {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "BankTotal", ID: bank.BankID, SecurityID: SecurityID, Field: "TotalBalance", Expected: bankSums[code], Recorded: recorded})
+		}
+	}
+
+	//3.Security.TotalAmount - Security.Balance
+	if BankCode == "" {
+		var holdings int64
+		for _, AccountID := range AccountIDs {
+			holdings += balances[AccountID]
+		}
+		if security.TotalAmount-security.Balance != holdings {
+			mismatches = append(mismatches, ReconcileMismatch{Scope: "Security", ID: SecurityID, SecurityID: SecurityID, Field: "TotalAmount-Balance", Expected: holdings, Recorded: security.TotalAmount - security.Balance})
+		}
+	}
+
+	return mismatches
+}
+
+func repairSecurityTotals(stub shim.ChaincodeStubInterface, SecurityID string, positions []Position, BankCode string) error {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	security, err := getSecurityStructFromID(stub, SecurityID)
+	if err != nil {
+		return err
+	}
+	//總數以持有部位重新計算，先將 delta 併入 checkpoint
+	_, err = foldSecurityTotalDeltas(stub, security, BankCode)
+	if err != nil {
+		return err
+	}
+	allBalances, _, allAccountIDs := getAccountHoldings(positions, SecurityID, "")
+
+	//1.Security.SecurityTotals
+	bankSums := make(map[string]int64)
+	bankInterests := make(map[string]int64)
+	var BankCodes []string
+	for _, val := range positions {
+		code := SubString(val.AccountID, 0, 3)
+		if val.SecurityID != SecurityID || (BankCode != "" && code != BankCode) {
+			continue
+		}
+		if _, ok := bankSums[code]; ok != true {
+			BankCodes = append(BankCodes, code)
+		}
+		bankSums[code] += val.Balance
+		bankInterests[code] += val.OwnedInterest
+	}
+	totalSeen := make(map[string]bool)
+	for key, val := range security.SecurityTotals {
+		if BankCode != "" && val.BankID != BankCode {
+			continue
+		}
+		totalSeen[val.BankID] = true
+		security.SecurityTotals[key].TotalBalance = bankSums[val.BankID]
+		security.SecurityTotals[key].TotalInterest = bankInterests[val.BankID]
+		if security.RepayPeriod > 0 {
+			security.SecurityTotals[key].DurationInterest = bankInterests[val.BankID] / int64(security.RepayPeriod)
+		}
+		security.SecurityTotals[key].UpdateTime = TimeNow2
+		if _, ok := bankSums[val.BankID]; ok != true {
+			BankCodes = append(BankCodes, val.BankID)
+		}
+	}
+	for _, code := range BankCodes {
+		if totalSeen[code] != true && bankSums[code] != 0 {
+			var securityTotal SecurityTotal
+			securityTotal.BankID = code
+			securityTotal.TotalBalance = bankSums[code]
+			securityTotal.TotalInterest = bankInterests[code]
+			if security.RepayPeriod > 0 {
+				securityTotal.DurationInterest = securityTotal.TotalInterest / int64(security.RepayPeriod)
+			}
+			securityTotal.CreateTime = TimeNow2
+			securityTotal.UpdateTime = TimeNow2
+			security.SecurityTotals = append(security.SecurityTotals, securityTotal)
+		}
+	}
+
+	//2.Security.Balance
+	var holdings int64
+	for _, AccountID := range allAccountIDs {
+		holdings += allBalances[AccountID]
+	}
+	security.Balance = security.TotalAmount - holdings
+
+	securityAsBytes, err := json.Marshal(security)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(SecurityID, securityAsBytes)
+	if err != nil {
+		return err
+	}
+
+	//3.Bank.BankTotals
+	for _, code := range BankCodes {
+		bank, err := getBankStructFromID(stub, "BANK"+code)
+		if err != nil {
+			return err
+		}
+		_, err = foldBankTotalDeltas(stub, bank, SecurityID)
+		if err != nil {
+			return err
+		}
+		var doflg bool
+		doflg = false
+		for key, val := range bank.BankTotals {
+			if val.SecurityID == SecurityID {
+				if doflg == true {
+					//重複的明細歸零，總數只記在第一筆
+					bank.BankTotals[key].TotalBalance = 0
+				} else {
+					bank.BankTotals[key].TotalBalance = bankSums[code]
+				}
+				bank.BankTotals[key].UpdateTime = TimeNow2
+				doflg = true
+			}
+		}
+		if doflg != true {
+			var bankTotal BankTotal
+			bankTotal.SecurityID = SecurityID
+			bankTotal.TotalBalance = bankSums[code]
+			bankTotal.CreateTime = TimeNow2
+			bankTotal.UpdateTime = TimeNow2
+			bank.BankTotals = append(bank.BankTotals, bankTotal)
+		}
+		bankAsBytes, err := json.Marshal(bank)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState("BANK"+code, bankAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
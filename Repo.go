@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const RepoObjectType string = "Repo"
+const RepoDueIndex string = "RepoDue"
+const RepoAccountIndex string = "RepoAccount"
+const repoProposed string = "PROPOSED"
+const repoAccepted string = "ACCEPTED"
+const repoOpen string = "OPEN"
+const repoClosed string = "CLOSED"
+const repoCancelled string = "CANCELLED"
+const legPending string = "PENDING"
+const legSettled string = "SETTLED"
+const repoDayBasis float64 = 365
+
+type Repo struct {
+	ObjectType      string  `json:"docType"`         // default set to "Repo"
+	RepoID          string  `json:"RepoID"`          // 附買回序號(Fabric TxID)
+	SellerAccountID string  `json:"SellerAccountID"` // 出券(融資)方帳號
+	BuyerAccountID  string  `json:"BuyerAccountID"`  // 買券(資金提供)方帳號
+	SecurityID      string  `json:"SecurityID"`      // 公債代號
+	Quantity        int64   `json:"Quantity"`        // 券數
+	StartDate       string  `json:"StartDate"`       // 首次交割日(YYYYMMDD)
+	EndDate         string  `json:"EndDate"`         // 到期交割日(YYYYMMDD)，提前解約時改為解約日
+	RepoRate        float64 `json:"RepoRate"`        // 附買回利率(%，實際天數/365)
+	StartAmount     int64   `json:"StartAmount"`     // 首次交割款數
+	RepoInterest    int64   `json:"RepoInterest"`    // 附買回利息
+	EndAmount       int64   `json:"EndAmount"`       // 到期交割款數(= 首次交割款數 + 利息)
+	RepoStatus      string  `json:"RepoStatus"`      // PROPOSED, ACCEPTED, OPEN, CLOSED or CANCELLED
+	OpeningLeg      RepoLeg `json:"OpeningLeg"`      // 首次交割：出券方交券，買券方付款
+	ClosingLeg      RepoLeg `json:"ClosingLeg"`      // 到期交割：買券方還券，出券方付款
+	IsTerminated    bool    `json:"IsTerminated"`    // 是否提前解約
+	CreateTime      string  `json:"CreateTime"`
+	UpdateTime      string  `json:"UpdateTime"`
+}
+
+type RepoLeg struct {
+	SettlementDate string `json:"SettlementDate"` // 交割日(YYYYMMDD)
+	Amount         int64  `json:"Amount"`         // 款數
+	LegStatus      string `json:"LegStatus"`      // PENDING or SETTLED
+	LegMemo        string `json:"LegMemo"`        // 最近一次未能交割的原因
+	SettleTime     string `json:"SettleTime"`     // 交割時間
+}
+
+/*
+附買回交易：出券方之清算銀行以 submitRepo 登錄，買券方之清算銀行以 acceptRepo 確認，
+首次交割於 StartDate 與 DVP 相同(券款同時異動)，確認時即產生到期交割並排入 EndDate 的佇列，
+由 openBusinessDay 自動交割(或 CBC 以 settleRepo 重新交割)，terminateRepo 提前於當日解約。
+Key: Repo~RepoID
+Index: RepoDue~SettlementDate~RepoID (待交割)
+       RepoAccount~AccountID~RepoID
+
+1.附買回序號
+2.出券方帳號
+3.買券方帳號
+4.公債代號
+5.券數
+6.首次交割日
+7.到期交割日
+8.附買回利率
+9.首次交割款數
+10.附買回利息
+11.到期交割款數
+12.狀態
+13.首次交割
+14.到期交割
+15.是否提前解約
+16.建立時間
+17.異動時間
+*/
+
+//StartDate 至 EndDate(YYYYMMDD)的利息，實際天數/365，四捨五入至元
+func getRepoInterest(StartAmount int64, RepoRate float64, StartDate string, EndDate string) int64 {
+
+	t1, _ := time.Parse("20060102", StartDate)
+	t2, _ := time.Parse("20060102", EndDate)
+	days := timeSub(t2, t1)
+	if days <= 0 {
+		return 0
+	}
+	return int64(round(float64(StartAmount)*RepoRate/100*float64(days)/repoDayBasis, 0))
+}
+
+func getRepoStruct(stub shim.ChaincodeStubInterface, RepoID string) (*Repo, error) {
+
+	repo := &Repo{}
+	repoKey, err := stub.CreateCompositeKey(RepoObjectType, []string{RepoID})
+	if err != nil {
+		return repo, err
+	}
+	repoAsBytes, err := stub.GetState(repoKey)
+	if err != nil {
+		return repo, err
+	} else if repoAsBytes == nil {
+		return repo, errors.New(fmt.Sprintf("Error: Repo does not exist (%s)", RepoID))
+	}
+	err = json.Unmarshal(repoAsBytes, repo)
+	if err != nil {
+		return repo, err
+	}
+	return repo, nil
+}
+
+func putRepoStruct(stub shim.ChaincodeStubInterface, repo *Repo) error {
+
+	repoKey, err := stub.CreateCompositeKey(RepoObjectType, []string{repo.RepoID})
+	if err != nil {
+		return err
+	}
+	repo.ObjectType = RepoObjectType
+	repo.UpdateTime = time.Now().Format(timelayout2)
+	repoAsBytes, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(repoKey, repoAsBytes)
+	if err != nil {
+		return err
+	}
+	//Save index entries to state. Only the key name is needed, no need to store a duplicate copy of the repo.
+	for _, AccountID := range []string{repo.SellerAccountID, repo.BuyerAccountID} {
+		indexKey, err := stub.CreateCompositeKey(RepoAccountIndex, []string{AccountID, repo.RepoID})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putRepoDue(stub shim.ChaincodeStubInterface, SettlementDate string, RepoID string) error {
+
+	dueKey, err := stub.CreateCompositeKey(RepoDueIndex, []string{SettlementDate, RepoID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(dueKey, []byte{0x00})
+}
+
+func delRepoDue(stub shim.ChaincodeStubInterface, SettlementDate string, RepoID string) error {
+
+	dueKey, err := stub.CreateCompositeKey(RepoDueIndex, []string{SettlementDate, RepoID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(dueKey)
+}
+
+//待交割的一段：ACCEPTED 為首次交割，OPEN 為到期交割
+func getRepoDueLeg(repo *Repo) (*RepoLeg, string) {
+
+	if repo.RepoStatus == repoAccepted {
+		return &repo.OpeningLeg, repo.StartDate
+	} else if repo.RepoStatus == repoOpen {
+		return &repo.ClosingLeg, repo.EndDate
+	}
+	return nil, ""
+}
+
+/*
+於營業日 TXKEY 交割待交割的一段，券款檢核全部通過才異動，未通過時回傳原因(不異動)。
+TXKEY 有 BusinessDay 紀錄時須為 Open。
+到期交割不檢核債券狀態，暫停交易(SUSPENDED)或已到期(MATURED)的債券仍可還券。
+*/
+func settleRepoLeg(stub shim.ChaincodeStubInterface, repo *Repo, TXKEY string) (string, error) {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	leg, SettlementDate := getRepoDueLeg(repo)
+	if leg == nil {
+		return "Status of repo " + repo.RepoID + " is " + repo.RepoStatus, nil
+	}
+	day, err := getBusinessDayStruct(stub, TXKEY)
+	if err != nil {
+		return "", err
+	}
+	if day != nil && day.DayStatus != dayOpen {
+		return "Business day " + TXKEY + " is " + day.DayStatus, nil
+	}
+	if SettlementDate > TXKEY {
+		return "SettlementDate of repo " + repo.RepoID + " is " + SettlementDate, nil
+	}
+	deliverer := repo.SellerAccountID
+	receiver := repo.BuyerAccountID
+	if repo.RepoStatus == repoOpen {
+		deliverer = repo.BuyerAccountID
+		receiver = repo.SellerAccountID
+	}
+	if errMsg := checkHalt(stub, repo.SecurityID, deliverer, receiver); errMsg != "" {
+		return errMsg, nil
+	}
+	if repo.RepoStatus == repoAccepted {
+		security, err := getSecurityStructFromID(stub, repo.SecurityID)
+		if err != nil {
+			return err.Error(), nil
+		}
+		if errMsg := checkSecurityStatus(security, securityOpTransfer); errMsg != "" {
+			return errMsg, nil
+		}
+	}
+	//交券方券數(不含質押)，付款方款數
+	_, _, _, _, _, errMsg := checkAccountBalance(stub, repo.SecurityID, repo.Quantity, 0, deliverer, "S", -1)
+	if errMsg != "" {
+		return errMsg, nil
+	}
+	_, _, _, _, _, errMsg = checkAccountBalance(stub, repo.SecurityID, 0, leg.Amount, receiver, "B", -1)
+	if errMsg != "" {
+		return errMsg, nil
+	}
+
+	_, _, _, _, err = updateAccountBalance(stub, repo.SecurityID, leg.Amount, repo.Quantity, deliverer, receiver)
+	if err != nil {
+		return "", err
+	}
+	_, _, err = updateSecurityAmount(stub, repo.SecurityID, repo.Quantity, leg.Amount, deliverer, receiver)
+	if err != nil {
+		return "", err
+	}
+	if SubString(deliverer, 0, 3) != SubString(receiver, 0, 3) {
+		err = updateBankTotals(stub, deliverer, repo.SecurityID, deliverer, repo.Quantity, leg.Amount, true)
+		if err != nil {
+			return "", err
+		}
+		err = updateBankTotals(stub, receiver, repo.SecurityID, receiver, repo.Quantity, leg.Amount, false)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = delRepoDue(stub, SettlementDate, repo.RepoID)
+	if err != nil {
+		return "", err
+	}
+	leg.LegStatus = legSettled
+	leg.LegMemo = ""
+	leg.SettleTime = TimeNow2
+	if repo.RepoStatus == repoAccepted {
+		repo.RepoStatus = repoOpen
+		err = putRepoDue(stub, repo.EndDate, repo.RepoID)
+	} else {
+		repo.RepoStatus = repoClosed
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+//openBusinessDay 完成時交割到期的附買回交易，未能交割者記錄原因，留待下一營業日或 settleRepo
+func settleDueRepos(stub shim.ChaincodeStubInterface, TXKEY string) error {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RepoDueIndex, []string{})
+	if err != nil {
+		return err
+	}
+	var RepoIDs []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			resultsIterator.Close()
+			return err
+		}
+		if compositeKeyParts[0] <= TXKEY {
+			RepoIDs = append(RepoIDs, compositeKeyParts[1])
+		}
+	}
+	resultsIterator.Close()
+
+	for _, RepoID := range RepoIDs {
+		repo, err := getRepoStruct(stub, RepoID)
+		if err != nil {
+			return err
+		}
+		errMsg, err := settleRepoLeg(stub, repo, TXKEY)
+		if err != nil {
+			return err
+		}
+		if errMsg != "" {
+			leg, _ := getRepoDueLeg(repo)
+			if leg == nil {
+				continue
+			}
+			leg.LegMemo = errMsg
+		}
+		err = putRepoStruct(stub, repo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+由出券方之清算銀行登錄，日期為 YYYYMMDD，RepoRate 為年利率(%)
+peer chaincode invoke -n mycc -c '{"Args":["submitRepo","002000000001","004000000001","A07103","10000000","10000000","0.5","20180415","20180515","BANK002"]}' -C myc
+*/
+func (s *SmartContract) submitRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 9)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	SellerAccountID := strings.ToUpper(args[0])
+	BuyerAccountID := strings.ToUpper(args[1])
+	SecurityID := strings.ToUpper(args[2])
+	if SellerAccountID == BuyerAccountID {
+		return errorResponse(codeArgumentFormat, "SellerAccountID can not equal to BuyerAccountID")
+	}
+	Quantity, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || Quantity <= 0 {
+		return errorResponse(codeArgumentFormat, "Quantity must be a positive integer")
+	}
+	StartAmount, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || StartAmount <= 0 {
+		return errorResponse(codeArgumentFormat, "StartAmount must be a positive integer")
+	}
+	RepoRate, err := strconv.ParseFloat(args[5], 64)
+	if err != nil || RepoRate < 0 {
+		return errorResponse(codeArgumentFormat, "RepoRate must be a non-negative number")
+	}
+	StartDate := args[6]
+	EndDate := args[7]
+	_, err = time.Parse("20060102", StartDate)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, "StartDate must be a YYYYMMDD string")
+	}
+	_, err = time.Parse("20060102", EndDate)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, "EndDate must be a YYYYMMDD string")
+	}
+	TXKEY, dayErrMsg := getBookingBusinessDate(APIstub, APIstub.GetTxID())
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
+	if StartDate < TXKEY {
+		return errorResponse(codeSettlementDateInvalid, "StartDate must not be before the business day "+TXKEY+": "+StartDate)
+	}
+	if EndDate <= StartDate {
+		return errorResponse(codeSettlementDateInvalid, "EndDate must be after StartDate: "+EndDate)
+	}
+	BankID := strings.ToUpper(args[8])
+	if BankID != "BANK"+SubString(SellerAccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "BankID must be the bank of SellerAccountID "+SellerAccountID)
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	for _, AccountID := range []string{SellerAccountID, BuyerAccountID} {
+		_, err = getAccountStructFromID(APIstub, AccountID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+	}
+	_, err = getSecurityStructFromID(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+
+	repo := &Repo{}
+	repo.RepoID = APIstub.GetTxID()
+	repo.SellerAccountID = SellerAccountID
+	repo.BuyerAccountID = BuyerAccountID
+	repo.SecurityID = SecurityID
+	repo.Quantity = Quantity
+	repo.StartDate = StartDate
+	repo.EndDate = EndDate
+	repo.RepoRate = RepoRate
+	repo.StartAmount = StartAmount
+	repo.RepoInterest = getRepoInterest(StartAmount, RepoRate, StartDate, EndDate)
+	repo.EndAmount = StartAmount + repo.RepoInterest
+	repo.RepoStatus = repoProposed
+	repo.OpeningLeg = RepoLeg{SettlementDate: StartDate, Amount: StartAmount, LegStatus: legPending}
+	repo.ClosingLeg = RepoLeg{SettlementDate: EndDate, Amount: repo.EndAmount, LegStatus: legPending}
+	repo.CreateTime = TimeNow2
+	err = putRepoStruct(APIstub, repo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+/*
+由買券方之清算銀行確認，StartDate 為目前營業日時立即首次交割(未能交割則不確認)，否則排入 StartDate 的佇列
+peer chaincode invoke -n mycc -c '{"Args":["acceptRepo","<RepoID>","BANK004"]}' -C myc
+*/
+func (s *SmartContract) acceptRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	repo, err := getRepoStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	BankID := strings.ToUpper(args[1])
+	if BankID != "BANK"+SubString(repo.BuyerAccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "BankID must be the bank of BuyerAccountID "+repo.BuyerAccountID)
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if repo.RepoStatus != repoProposed {
+		return errorResponse(codeInvalidStatus, "Status of repo "+repo.RepoID+" is "+repo.RepoStatus)
+	}
+	TXKEY, dayErrMsg := getBookingBusinessDate(APIstub, APIstub.GetTxID())
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
+	if repo.StartDate < TXKEY {
+		return errorResponse(codeSettlementDateInvalid, "StartDate of repo "+repo.RepoID+" has passed: "+repo.StartDate)
+	}
+
+	repo.RepoStatus = repoAccepted
+	if repo.StartDate == TXKEY {
+		errMsg, err := settleRepoLeg(APIstub, repo, TXKEY)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		if errMsg != "" {
+			return errorResponse(codeRepoNotSettled, errMsg)
+		}
+	} else {
+		err = putRepoDue(APIstub, repo.StartDate, repo.RepoID)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	err = putRepoStruct(APIstub, repo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+/*
+首次交割前由任一方之清算銀行取消
+peer chaincode invoke -n mycc -c '{"Args":["cancelRepo","<RepoID>","BANK002"]}' -C myc
+*/
+func (s *SmartContract) cancelRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	repo, err := getRepoStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	BankID := strings.ToUpper(args[1])
+	if BankID != "BANK"+SubString(repo.SellerAccountID, 0, 3) && BankID != "BANK"+SubString(repo.BuyerAccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "BankID must be the bank of SellerAccountID or BuyerAccountID")
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if repo.RepoStatus != repoProposed && repo.RepoStatus != repoAccepted {
+		return errorResponse(codeInvalidStatus, "Status of repo "+repo.RepoID+" is "+repo.RepoStatus)
+	}
+	if repo.RepoStatus == repoAccepted {
+		err = delRepoDue(APIstub, repo.StartDate, repo.RepoID)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	repo.RepoStatus = repoCancelled
+	err = putRepoStruct(APIstub, repo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+/*
+提前解約：由任一方之清算銀行於 EndDate 之前執行，利息計至目前營業日，到期交割立即執行(未能交割則不解約)
+peer chaincode invoke -n mycc -c '{"Args":["terminateRepo","<RepoID>","BANK004"]}' -C myc
+*/
+func (s *SmartContract) terminateRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	repo, err := getRepoStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	BankID := strings.ToUpper(args[1])
+	if BankID != "BANK"+SubString(repo.SellerAccountID, 0, 3) && BankID != "BANK"+SubString(repo.BuyerAccountID, 0, 3) {
+		return errorResponse(codeUnauthorized, "BankID must be the bank of SellerAccountID or BuyerAccountID")
+	}
+	if errMsg := verifyIdentity(APIstub, BankID); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if repo.RepoStatus != repoOpen {
+		return errorResponse(codeInvalidStatus, "Status of repo "+repo.RepoID+" is "+repo.RepoStatus)
+	}
+	Today, dayErrMsg := getBookingBusinessDate(APIstub, APIstub.GetTxID())
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
+	if Today >= repo.EndDate {
+		return errorResponse(codeSettlementDateInvalid, "Repo "+repo.RepoID+" is due on "+repo.EndDate+", it can not be terminated early")
+	}
+
+	err = delRepoDue(APIstub, repo.EndDate, repo.RepoID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	repo.EndDate = Today
+	repo.RepoInterest = getRepoInterest(repo.StartAmount, repo.RepoRate, repo.StartDate, Today)
+	repo.EndAmount = repo.StartAmount + repo.RepoInterest
+	repo.ClosingLeg.SettlementDate = Today
+	repo.ClosingLeg.Amount = repo.EndAmount
+	repo.IsTerminated = true
+	errMsg, err := settleRepoLeg(APIstub, repo, Today)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if errMsg != "" {
+		return errorResponse(codeRepoNotSettled, errMsg)
+	}
+	err = putRepoStruct(APIstub, repo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+/*
+CBC 於交割日已到、之前未能交割(LegMemo)的附買回重新交割
+peer chaincode invoke -n mycc -c '{"Args":["settleRepo","<RepoID>","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) settleRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	repo, err := getRepoStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	TXKEY, dayErrMsg := getBookingBusinessDate(APIstub, APIstub.GetTxID())
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
+	errMsg, err := settleRepoLeg(APIstub, repo, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if errMsg != "" {
+		return errorResponse(codeRepoNotSettled, errMsg)
+	}
+	err = putRepoStruct(APIstub, repo)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryRepo","<RepoID>"]}' -C myc
+func (s *SmartContract) queryRepo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	repo, err := getRepoStruct(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	return dataResponse(repo)
+}
+
+//出券方或買券方為 AccountID 的附買回交易
+//peer chaincode query -n mycc -c '{"Args":["queryAccountRepos","004000000001"]}' -C myc
+func (s *SmartContract) queryAccountRepos(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(RepoAccountIndex, []string{strings.ToUpper(args[0])})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	repos := []Repo{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		repo, err := getRepoStruct(APIstub, compositeKeyParts[1])
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		repos = append(repos, *repo)
+	}
+	return dataResponse(repos)
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+每個交易除原有的位置參數外，也可以只傳一個 JSON 物件參數，以欄位名稱指定參數，例如
+peer chaincode invoke -n mycc -c '{"Args":["securityTransfer","{\"TXType\":\"B\",\"TXFrom\":\"004000000001\",\"TXTo\":\"002000000001\",\"SecurityID\":\"A07103\",\"SecurityAmount\":102000,\"Payment\":100000,\"IsPutToQueue\":true}"]}' -C myc
+兩種形式都依 requestSchemas 檢核後轉為位置參數，交給原交易處理。
+*/
+
+const fieldString string = "string"
+const fieldInteger string = "integer"
+const fieldNumber string = "number"
+const fieldBool string = "bool"
+const fieldDate string = "date" //YYYYMMDD
+const fieldJSON string = "json" //JSON 陣列或物件
+
+type RequestField struct {
+	Name      string   `json:"Name"`
+	Type      string   `json:"Type"`
+	Required  bool     `json:"Required"`        //不可為空白
+	Omittable bool     `json:"Omittable"`       //位置參數可省略(只能在最後)
+	Enum      []string `json:"Enum,omitempty"`  //允許值(不分大小寫)
+	Range     []int64  `json:"Range,omitempty"` //[最小值] 或 [最小值, 最大值]
+}
+
+/*
+1.欄位名稱
+2.型別
+3.是否必填
+4.是否可省略
+5.允許值
+6.數值範圍
+*/
+
+//欄位順序即位置參數順序
+type RequestSchema []RequestField
+
+//JSON 物件及位置參數都轉成 Request，欄位值一律以字串表示
+type Request struct {
+	Function string            `json:"Function"`
+	Fields   map[string]string `json:"Fields"`
+}
+
+func required(Name string, Type string) RequestField {
+	return RequestField{Name: Name, Type: Type, Required: true}
+}
+
+//位置參數須存在，但可為空白
+func blank(Name string, Type string) RequestField {
+	return RequestField{Name: Name, Type: Type}
+}
+
+func omittable(Name string, Type string) RequestField {
+	return RequestField{Name: Name, Type: Type, Omittable: true}
+}
+
+func (field RequestField) enum(values ...string) RequestField {
+	field.Enum = values
+	return field
+}
+
+func (field RequestField) min(value int64) RequestField {
+	field.Range = []int64{value}
+	return field
+}
+
+func (field RequestField) between(min int64, max int64) RequestField {
+	field.Range = []int64{min, max}
+	return field
+}
+
+var rangeSchema = RequestSchema{
+	required("StartKey", fieldString),
+	required("EndKey", fieldString),
+	omittable("PageSize", fieldInteger).min(0),
+}
+
+var keysSchema = RequestSchema{
+	required("StartKey", fieldString),
+	required("EndKey", fieldString),
+	omittable("SleepTime", fieldInteger).min(0),
+}
+
+var accountSchema = RequestSchema{
+	required("AccountID", fieldString),
+	required("BankID", fieldString),
+	required("BankName", fieldString),
+	required("CustName", fieldString),
+	required("CustType", fieldString),
+	required("SecurityID", fieldString),
+	required("SecurityAmount", fieldInteger).min(0),
+	required("Balance", fieldInteger).min(0),
+	required("Position", fieldInteger).min(0),
+	required("Status", fieldString),
+}
+
+var transferSchema = RequestSchema{
+	required("TXType", fieldString).enum("B", "S"),
+	required("TXFrom", fieldString),
+	required("TXTo", fieldString),
+	required("SecurityID", fieldString),
+	required("SecurityAmount", fieldInteger).min(0),
+	required("Payment", fieldInteger).min(0),
+	required("IsPutToQueue", fieldBool),
+	omittable("SettlementDate", fieldDate),
+	omittable("TradeRef", fieldString),
+}
+
+var instructionSchema = RequestSchema{
+	required("TXID", fieldString),
+	required("ReasonCode", fieldString).enum(getSortedKeys(instructionReasonCodes)...),
+	required("BankID", fieldString),
+}
+
+var requestSchemas = map[string]RequestSchema{
+	//Security.go
+	"querySecurity": {required("SecurityID", fieldString)},
+	"initLedger":    {required("BankID", fieldString)},
+	"createSecurity": {
+		required("SecurityID", fieldString),
+		required("SecurityName", fieldString),
+		required("IssueDate", fieldString),
+		required("MaturityDate", fieldString),
+		required("InterestRate", fieldNumber),
+		required("RepayPeriod", fieldInteger).min(1),
+		required("TotalAmount", fieldInteger).min(0),
+		omittable("SecurityStatus", fieldString).enum(securityStatusNames[securityAnnounced], securityStatusNames[securityIssued]),
+	},
+	"changeSecurity": {
+		required("SecurityID", fieldString),
+		required("SecurityName", fieldString),
+		required("IssueDate", fieldString),
+		required("MaturityDate", fieldString),
+		required("InterestRate", fieldNumber),
+		required("RepayPeriod", fieldInteger).min(1),
+		required("TotalAmount", fieldInteger).min(0),
+		required("AccountID", fieldString),
+		required("BankID", fieldString),
+		required("OwnedBalance", fieldInteger).min(0),
+		required("OwnedAmount", fieldInteger).min(0),
+		required("Avaliable", fieldInteger),
+		omittable("EffectiveDate", fieldDate),
+	},
+	"changeSecurityStatus":      {required("SecurityID", fieldString), required("SecurityStatus", fieldString).enum(getSecurityStatusList()...)},
+	"deleteSecurity":            {required("SecurityID", fieldString)},
+	"queryAllSecurities":        rangeSchema,
+	"querySecurityTerms":        {required("SecurityID", fieldString)},
+	"reopenSecurity":            {required("SecurityID", fieldString), required("IssueDate", fieldString), required("Amount", fieldInteger).min(1), required("IssuePrice", fieldNumber), required("AdminID", fieldString)},
+	"querySecurityTranches":     {required("SecurityID", fieldString)},
+	"querySecurityStatus":       {required("SecurityID", fieldString)},
+	"queryOwner":                {required("SecurityID", fieldString)},
+	"queryOwnerAccount":         {required("SecurityID", fieldString), required("AccountID", fieldString)},
+	"queryOwnerLength":          {required("SecurityID", fieldString)},
+	"queryBankSecurityTotals":   {required("SecurityID", fieldString), required("BankID", fieldString)},
+	"changeBankSecurityTotals":  {required("SecurityID", fieldString), required("BankID", fieldString), blank("BaselineDate", fieldDate)},
+	"changeOwnerAvaliable":      {required("SecurityID", fieldString), required("AccountID", fieldString), required("Avaliable", fieldInteger)},
+	"deleteOwner":               {required("SecurityID", fieldString), required("AccountID", fieldString)},
+	"updateOwnerInterest":       {required("SecurityID", fieldString), blank("BaselineDate", fieldDate)},
+	"getHistoryForSecurity":     {required("SecurityID", fieldString)},
+	"getHistoryTXIDForSecurity": {required("SecurityID", fieldString), required("TxId", fieldString)},
+	"queryAllSecurityKeys":      keysSchema,
+	"querySecurityTotals":       {required("SecurityID", fieldString)},
+	//Account.go
+	"initAccount":         accountSchema,
+	"updateAccount":       accountSchema,
+	"deleteAccount":       {required("AccountID", fieldString), required("BankID", fieldString)},
+	"readAccount":         {required("AccountID", fieldString)},
+	"updateAccountStatus": {required("AccountID", fieldString), required("Status", fieldString)},
+	"updateAsset": {
+		required("AccountID", fieldString),
+		required("SecurityID", fieldString),
+		required("SecurityAmount", fieldInteger).min(0),
+		required("Balance", fieldInteger).min(0),
+		required("Position", fieldInteger).min(0),
+	},
+	"updateAssetBalance": {
+		required("AccountID", fieldString),
+		required("SecurityID", fieldString),
+		required("BuyOrSell", fieldString).enum("B", "S"),
+		required("Balance", fieldInteger).min(0),
+		required("Position", fieldInteger).min(0),
+	},
+	"deleteAsset":              {required("AccountID", fieldString), required("SecurityID", fieldString)},
+	"queryAsset":               {required("AccountID", fieldString)},
+	"queryAssetInfo":           {required("AccountID", fieldString), required("SecurityID", fieldString)},
+	"queryAssetLength":         {required("AccountID", fieldString)},
+	"queryAccountStatus":       {required("AccountID", fieldString)},
+	"queryAllAccounts":         rangeSchema,
+	"getHistoryForAccount":     {required("AccountID", fieldString)},
+	"getHistoryTXIDForAccount": {required("AccountID", fieldString), required("TxId", fieldString)},
+	"queryAllAccountKeys":      keysSchema,
+	//Bank.go
+	"initBank":              {required("BankID", fieldString), required("BankName", fieldString), required("BankCode", fieldString)},
+	"updateBank":            {required("BankID", fieldString), required("BankName", fieldString), required("BankCode", fieldString)},
+	"deleteBank":            {required("BankID", fieldString)},
+	"verifyBankList":        {required("BankID", fieldString)},
+	"readBank":              {required("Key", fieldString)},
+	"queryAllBanks":         rangeSchema,
+	"getHistoryForBank":     {required("BankID", fieldString)},
+	"getHistoryTXIDForBank": {required("BankID", fieldString), required("TxId", fieldString)},
+	"queryAllBankKeys":      keysSchema,
+	"queryBankTotals":       {required("BankID", fieldString)},
+	//Transaction.go
+	"submitApproveTransaction":           {required("TXID", fieldString), required("AdminID", fieldString)},
+	"submitEndDayTransaction":            {required("TXID", fieldString), required("AdminID", fieldString)},
+	"securityTransfer":                   transferSchema,
+	"securityCorrectTransfer":            append(append(RequestSchema{}, transferSchema[:7]...), required("TXID", fieldString)),
+	"queryTXIDTransactions":              {required("TXID", fieldString)},
+	"queryTXKEYTransactions":             {required("TXKEY", fieldString)},
+	"queryHistoryTXKEYTransactions":      {required("HTXKEY", fieldString)},
+	"getHistoryForTransaction":           {required("TXID", fieldString)},
+	"getHistoryTXIDForTransaction":       {required("TXID", fieldString), required("TxId", fieldString)},
+	"getHistoryForQueuedTransaction":     {required("TXKEY", fieldString)},
+	"getHistoryTXIDForQueuedTransaction": {required("TXKEY", fieldString), required("TxId", fieldString)},
+	"queryAllTransactions":               rangeSchema,
+	"queryAllQueuedTransactions":         rangeSchema,
+	"queryAllHistoryTransactions":        rangeSchema,
+	"queryAllTransactionKeys":            keysSchema,
+	"queryQueuedTransactionStatus":       {required("TXKEY", fieldString), required("TXStatus", fieldString), required("BankID", fieldString)},
+	"queryHistoryTransactionStatus":      {required("HTXKEY", fieldString), required("TXStatus", fieldString), required("BankID", fieldString)},
+	"updateQueuedTransactionHcode":       {required("TXKEY", fieldString), required("TXID", fieldString), required("TXHcode", fieldString)},
+	"updateHistoryTransactionHcode":      {required("HTXKEY", fieldString), required("TXID", fieldString), required("TXHcode", fieldString)},
+	//Reconcile.go、Position.go、Totals.go
+	"reconcile":         {required("Scope", fieldString).enum(reconcileScopeSecurity, reconcileScopeBank), required("ID", fieldString)},
+	"repairTotals":      {required("Scope", fieldString).enum(reconcileScopeSecurity, reconcileScopeBank), required("ID", fieldString), required("AdminID", fieldString)},
+	"migratePositions":  {required("SecurityID", fieldString), required("AdminID", fieldString)},
+	"compactTotals":     {required("Scope", fieldString).enum(reconcileScopeSecurity, reconcileScopeBank), required("ID", fieldString), required("AdminID", fieldString)},
+	"queryBankAccounts": {required("BankID", fieldString)},
+	//BusinessDay.go、Settlement.go、SettlementDate.go、QueueManager.go
+	"openBusinessDay":       {required("TXKEY", fieldDate), required("PageSize", fieldInteger).min(1), required("AdminID", fieldString)},
+	"cutOffBusinessDay":     {required("AdminID", fieldString)},
+	"setCutOffTime":         {blank("CutOffTime", fieldString), required("AdminID", fieldString)},
+	"closeBusinessDay":      {required("TXKEY", fieldDate), required("PageSize", fieldInteger).min(1), required("AdminID", fieldString)},
+	"queryBusinessDay":      {required("TXKEY", fieldDate)},
+	"queryOpeningPositions": {required("TXKEY", fieldDate), omittable("AccountID", fieldString)},
+	"setSettlementMode":     {required("SettlementMode", fieldString).enum(settlementGross, settlementNet), required("AdminID", fieldString)},
+	"runSettlementCycle":    {required("TXKEY", fieldDate), required("AdminID", fieldString)},
+	"querySettlementCycles": {required("TXKEY", fieldDate)},
+	"queryDueTransactions":  {required("SettlementDate", fieldDate)},
+	"resolveGridlock":       {required("AdminID", fieldString)},
+	//Priority.go、Matching.go、Instruction.go
+	"updateTransactionPriority": {required("TXID", fieldString), required("TXPriority", fieldInteger).between(int64(priorityCentralBank), int64(priorityCustomer)), required("BankID", fieldString)},
+	"queryQueuePosition":        {required("TXID", fieldString)},
+	"queryMatchCandidates":      {required("TXID", fieldString)},
+	"cancelInstruction":         instructionSchema,
+	"holdInstruction":           instructionSchema,
+	"releaseInstruction":        instructionSchema,
+	"queryInstructionActions":   {required("TXID", fieldString)},
+	//PendingChange.go、Audit.go、Errors.go、Request.go
+	"submitChange":        {required("MakerID", fieldString), required("Function", fieldString), required("Args", fieldJSON)},
+	"approveChange":       {required("ChangeID", fieldString), required("CheckerID", fieldString), required("PayloadHash", fieldString)},
+	"rejectChange":        {required("ChangeID", fieldString), required("CheckerID", fieldString)},
+	"queryPendingChanges": {omittable("ChangeStatus", fieldString).enum(changePending, changeApproved, changeRejected, changeExpired)},
+	"queryAuditRecords":   {blank("Caller", fieldString), blank("Key", fieldString), blank("Function", fieldString), blank("FromTime", fieldString), blank("ToTime", fieldString)},
+	"queryErrorCodes":     {},
+	"queryRequestSchema":  {required("Function", fieldString)},
+	//Auction.go
+	"openAuction":        {required("SecurityID", fieldString), required("OfferingAmount", fieldInteger).min(1), required("CloseTime", fieldString), required("AllotmentRule", fieldString).enum(auctionUniform, auctionMultiple), required("AdminID", fieldString), omittable("TrancheNo", fieldInteger).min(1)},
+	"submitBid":          {required("AuctionID", fieldString), required("AccountID", fieldString), required("BidType", fieldString).enum(bidCompetitive, bidNonCompetitive), required("Amount", fieldInteger).min(1), blank("Price", fieldNumber), required("BankID", fieldString)},
+	"closeAuction":       {required("AuctionID", fieldString), required("AdminID", fieldString)},
+	"queryAuction":       {required("AuctionID", fieldString)},
+	"queryAuctionBids":   {required("AuctionID", fieldString)},
+	"queryAuctionResult": {required("AuctionID", fieldString)},
+	//Halt.go
+	"activateHalt": {required("Scope", fieldString).enum(haltScopeGlobal, haltScopeSecurity, haltScopeBank), blank("ID", fieldString), required("Reason", fieldString), blank("EndTime", fieldString), required("AdminID", fieldString)},
+	"releaseHalt":  {required("Scope", fieldString).enum(haltScopeGlobal, haltScopeSecurity, haltScopeBank), blank("ID", fieldString), required("AdminID", fieldString)},
+	"queryHalts":   {},
+	//Pledge.go
+	"pledgeSecurity": {required("AccountID", fieldString), required("SecurityID", fieldString), required("Quantity", fieldInteger).min(1), required("PledgeeID", fieldString), blank("Purpose", fieldString), required("BankID", fieldString)},
+	"releasePledge":  {required("PledgeID", fieldString), blank("Quantity", fieldInteger).min(1), required("BankID", fieldString)},
+	"queryPledges":   {required("Role", fieldString).enum(pledgeRolePledgor, pledgeRolePledgee), required("ID", fieldString)},
+	//Repo.go
+	"submitRepo": {
+		required("SellerAccountID", fieldString),
+		required("BuyerAccountID", fieldString),
+		required("SecurityID", fieldString),
+		required("Quantity", fieldInteger).min(1),
+		required("StartAmount", fieldInteger).min(1),
+		required("RepoRate", fieldNumber),
+		required("StartDate", fieldDate),
+		required("EndDate", fieldDate),
+		required("BankID", fieldString),
+	},
+	"acceptRepo":        {required("RepoID", fieldString), required("BankID", fieldString)},
+	"cancelRepo":        {required("RepoID", fieldString), required("BankID", fieldString)},
+	"terminateRepo":     {required("RepoID", fieldString), required("BankID", fieldString)},
+	"settleRepo":        {required("RepoID", fieldString), required("AdminID", fieldString)},
+	"queryRepo":         {required("RepoID", fieldString)},
+	"queryAccountRepos": {required("AccountID", fieldString)},
+	//mapFunction，query 的唯一參數為 CouchDB 查詢 JSON，不適用
+	"put":     {required("Key", fieldString), blank("Value", fieldString)},
+	"get":     {required("Key", fieldString)},
+	"remove":  {required("Key", fieldString)},
+	"keys":    keysSchema,
+	"history": {required("Key", fieldString)},
+}
+
+func getSortedKeys(values map[string]string) []string {
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isRequestObject(arg string) bool {
+
+	return strings.HasPrefix(strings.TrimSpace(arg), "{")
+}
+
+func newRequestFromJSON(function string, schema RequestSchema, arg string) (*Request, error) {
+
+	request := &Request{Function: function, Fields: map[string]string{}}
+	values := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(arg))
+	decoder.UseNumber()
+	err := decoder.Decode(&values)
+	if err != nil {
+		return request, errors.New("Request must be a JSON object: " + err.Error())
+	}
+	for name, value := range values {
+		field, ok := schema.getField(name)
+		if ok != true {
+			return request, errors.New("Unknown field " + name + " for " + function)
+		}
+		if value == nil {
+			continue
+		}
+		switch field.Type {
+		case fieldInteger, fieldNumber:
+			number, ok := value.(json.Number)
+			if ok != true {
+				return request, errors.New(name + " must be a JSON number")
+			}
+			request.Fields[name] = number.String()
+		case fieldBool:
+			flag, ok := value.(bool)
+			if ok != true {
+				return request, errors.New(name + " must be a JSON boolean")
+			}
+			request.Fields[name] = strconv.FormatBool(flag)
+		case fieldJSON:
+			var buffer bytes.Buffer
+			encoder := json.NewEncoder(&buffer)
+			encoder.SetEscapeHTML(false)
+			err = encoder.Encode(value)
+			if err != nil {
+				return request, err
+			}
+			request.Fields[name] = strings.TrimSpace(buffer.String())
+		default:
+			text, ok := value.(string)
+			if ok != true {
+				return request, errors.New(name + " must be a JSON string")
+			}
+			request.Fields[name] = text
+		}
+	}
+	return request, nil
+}
+
+func newRequestFromArgs(function string, schema RequestSchema, args []string) *Request {
+
+	request := &Request{Function: function, Fields: map[string]string{}}
+	for i, val := range args {
+		request.Fields[schema[i].Name] = val
+	}
+	return request
+}
+
+func (schema RequestSchema) getField(name string) (RequestField, bool) {
+
+	for _, field := range schema {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return RequestField{}, false
+}
+
+//位置參數最少個數(不含可省略的欄位)
+func (schema RequestSchema) getMinArgs() int {
+
+	n := len(schema)
+	for n > 0 && schema[n-1].Omittable == true {
+		n--
+	}
+	return n
+}
+
+func validateRequestField(field RequestField, value string) error {
+
+	if value == "" {
+		if field.Required == true {
+			return errors.New(field.Name + " must be a non-empty string")
+		}
+		return nil
+	}
+	switch field.Type {
+	case fieldInteger:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New(field.Name + " must be an integer")
+		}
+		if len(field.Range) > 0 && number < field.Range[0] {
+			return errors.New(field.Name + " must be >= " + strconv.FormatInt(field.Range[0], 10))
+		}
+		if len(field.Range) > 1 && number > field.Range[1] {
+			return errors.New(field.Name + " must be <= " + strconv.FormatInt(field.Range[1], 10))
+		}
+	case fieldNumber:
+		_, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New(field.Name + " must be a number")
+		}
+	case fieldBool:
+		_, err := strconv.ParseBool(strings.ToLower(value))
+		if err != nil {
+			return errors.New(field.Name + " must be true or false")
+		}
+	case fieldDate:
+		_, err := time.Parse("20060102", value)
+		if err != nil {
+			return errors.New(field.Name + " must be a date (YYYYMMDD)")
+		}
+	case fieldJSON:
+		if json.Valid([]byte(value)) != true {
+			return errors.New(field.Name + " must be a JSON value")
+		}
+	}
+	if len(field.Enum) > 0 {
+		for _, val := range field.Enum {
+			if strings.ToUpper(val) == strings.ToUpper(value) {
+				return nil
+			}
+		}
+		return errors.New(field.Name + " must be one of " + strings.Join(field.Enum, ", "))
+	}
+	return nil
+}
+
+func (schema RequestSchema) validate(request *Request) error {
+
+	for _, field := range schema {
+		err := validateRequestField(field, request.Fields[field.Name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//依欄位順序轉為位置參數，最後可省略且空白的欄位不輸出
+func (schema RequestSchema) getArgs(request *Request) []string {
+
+	args := make([]string, len(schema))
+	for i, field := range schema {
+		args[i] = request.Fields[field.Name]
+	}
+	n := len(args)
+	for n > schema.getMinArgs() && args[n-1] == "" {
+		n--
+	}
+	return args[:n]
+}
+
+/*
+由 Invoke 呼叫。只有一個 JSON 物件參數時依欄位名稱轉為位置參數；
+位置參數個數正確時依同一 schema 檢核，個數不符時原樣交給交易處理(沿用原錯誤訊息)。
+*/
+func getRequestArgs(function string, args []string) ([]string, error) {
+
+	schema, ok := requestSchemas[function]
+	if ok != true {
+		return args, nil
+	}
+	var request *Request
+	if len(args) == 1 && isRequestObject(args[0]) == true {
+		var err error
+		request, err = newRequestFromJSON(function, schema, args[0])
+		if err != nil {
+			return args, err
+		}
+	} else {
+		if len(args) < schema.getMinArgs() || len(args) > len(schema) {
+			return args, nil
+		}
+		request = newRequestFromArgs(function, schema, args)
+	}
+	err := schema.validate(request)
+	if err != nil {
+		return args, err
+	}
+	return schema.getArgs(request), nil
+}
+
+/*
+peer chaincode query -n mycc -c '{"Args":["queryRequestSchema","securityTransfer"]}' -C myc
+peer chaincode query -n mycc -c '{"Args":["queryRequestSchema","All"]}' -C myc
+*/
+func (s *SmartContract) queryRequestSchema(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 1)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	Function := args[0]
+	if Function != "All" {
+		schema, ok := requestSchemas[Function]
+		if ok != true {
+			return errorResponse(codeNotFound, "Failed to find request schema for "+Function)
+		}
+		return dataResponse(schema)
+	}
+	functions := []string{}
+	for key := range requestSchemas {
+		functions = append(functions, key)
+	}
+	sort.Strings(functions)
+	return dataResponse(functions)
+}
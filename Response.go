@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+查詢交易一律回傳 ResponseEnvelope：{"Data","Pagination","Warnings"}，
+Data 為下列型別化結構(或既有的 Account、Security、Transaction 等結構)，數值欄位為 JSON 數字。
+錯誤仍以 errorResponse 回傳(見 Errors.go)。
+*/
+
+type ResponseEnvelope struct {
+	Data       interface{} `json:"Data"`
+	Pagination *Pagination `json:"Pagination,omitempty"` //範圍查詢才有
+	Warnings   []string    `json:"Warnings"`
+}
+
+/*
+1.查詢結果
+2.分頁資訊
+3.警告訊息(例如查無符合條件的資料)
+*/
+
+type Pagination struct {
+	StartKey string `json:"StartKey"`
+	EndKey   string `json:"EndKey"`
+	PageSize int    `json:"PageSize"` //0 表示不分頁
+	Count    int    `json:"Count"`    //本頁筆數
+	NextKey  string `json:"NextKey"`  //下一頁的 StartKey，空字串表示已無資料
+}
+
+/*
+1.起始 key
+2.結束 key(不含)
+3.每頁筆數
+4.本頁筆數
+5.下一頁起始 key
+*/
+
+type KeyRecord struct {
+	Key    string          `json:"Key"`
+	Record json.RawMessage `json:"Record"`
+}
+
+type HistoryRecord struct {
+	TxId      string          `json:"TxId"`
+	Value     json.RawMessage `json:"Value"` //刪除時為 null
+	Timestamp string          `json:"Timestamp"`
+	IsDelete  bool            `json:"IsDelete"`
+}
+
+type BankValue struct {
+	BankID string          `json:"BankID"`
+	Value  json.RawMessage `json:"Value"`
+}
+
+type AccountAssetLength struct {
+	AccountID    string `json:"AccountID"`
+	OwnersLength int    `json:"OwnersLength"`
+}
+
+type AccountAssetRecord struct {
+	AssetKey int `json:"AssetKey"` //Assets 中的序號(由 1 開始)
+	Asset
+}
+
+type AccountAssetInfo struct {
+	AccountID string               `json:"AccountID"`
+	Records   []AccountAssetRecord `json:"Records"`
+}
+
+type AccountStatus struct {
+	AccountID     string `json:"AccountID"`
+	AccountStatus string `json:"AccountStatus"`
+}
+
+type SecurityOwnerLength struct {
+	SecurityID   string `json:"SecurityID"`
+	OwnersLength int    `json:"OwnersLength"`
+}
+
+type SecurityOwnerRecord struct {
+	OwnedKey int `json:"OwnedKey"` //Owners 中的序號(由 1 開始)
+	Owner
+}
+
+type SecurityOwnerAccount struct {
+	SecurityID string                `json:"SecurityID"`
+	Records    []SecurityOwnerRecord `json:"Records"`
+}
+
+type SecurityStatus struct {
+	SecurityID     string `json:"SecurityID"`
+	SecurityStatus int    `json:"SecurityStatus"`
+	StatusName     string `json:"StatusName"` //ISSUED、ANNOUNCED 等，見 SecurityStatus.go
+}
+
+type BankSecurityTotalsRecord struct {
+	SecurityTotalsKey int `json:"SecurityTotalsKey"` //SecurityTotals 中的序號(由 1 開始)
+	SecurityTotal
+}
+
+type BankSecurityTotals struct {
+	SecurityID string                     `json:"SecurityID"`
+	Records    []BankSecurityTotalsRecord `json:"Records"`
+}
+
+type QueuedTransactionRecord struct {
+	QueuedKey int `json:"QueuedKey"` //佇列中的序號(由 1 開始)
+	Transaction
+}
+
+type QueuedTransactionStatus struct {
+	TXKEY        string                    `json:"TXKEY"`
+	Transactions []QueuedTransactionRecord `json:"Transactions"`
+}
+
+type HistoryTransactionRecord struct {
+	HistoryKey int    `json:"HistoryKey"` //歷史檔中的序號(由 1 開始)
+	TXKinds    string `json:"TXKinds"`
+	Transaction
+}
+
+type HistoryTransactionStatus struct {
+	HTXKEY       string                     `json:"HTXKEY"`
+	Transactions []HistoryTransactionRecord `json:"Transactions"`
+}
+
+func envelopeResponse(data interface{}, pagination *Pagination, warnings []string) peer.Response {
+
+	if warnings == nil {
+		warnings = []string{}
+	}
+	envelopeAsBytes, err := json.Marshal(ResponseEnvelope{data, pagination, warnings})
+	if err != nil {
+		return errorResponse(codeInternalError, err.Error())
+	}
+	return shim.Success(envelopeAsBytes)
+}
+
+func dataResponse(data interface{}, warnings ...string) peer.Response {
+
+	return envelopeResponse(data, nil, warnings)
+}
+
+//帳本中非 JSON 的值(例如 matchingwindow)以 JSON 字串回傳
+func getRecordValue(value []byte) json.RawMessage {
+
+	if value == nil {
+		return nil
+	}
+	if json.Valid(value) == true {
+		return json.RawMessage(value)
+	}
+	valueAsBytes, _ := json.Marshal(string(value))
+	return json.RawMessage(valueAsBytes)
+}
+
+//args 為 startKey、endKey、[PageSize]
+func getPagination(args []string) (*Pagination, error) {
+
+	pagination := &Pagination{StartKey: args[0], EndKey: args[1]}
+	if len(args) > 2 && args[2] != "" {
+		PageSize, err := strconv.Atoi(args[2])
+		if err != nil || PageSize < 0 {
+			return pagination, errors.New("PageSize must be a non-negative integer")
+		}
+		pagination.PageSize = PageSize
+	}
+	return pagination, nil
+}
+
+func getKeyRecords(iterator shim.StateQueryIteratorInterface, pagination *Pagination) ([]KeyRecord, error) {
+
+	records := []KeyRecord{}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return records, err
+		}
+		if pagination.PageSize > 0 && len(records) == pagination.PageSize {
+			pagination.NextKey = queryResponse.Key
+			break
+		}
+		records = append(records, KeyRecord{queryResponse.Key, getRecordValue(queryResponse.Value)})
+	}
+	pagination.Count = len(records)
+	return records, nil
+}
+
+//queryAllAccounts、queryAllBanks 等範圍查詢共用
+func getRangeResponse(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2 or 3")
+	}
+	pagination, err := getPagination(args)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(pagination.StartKey, pagination.EndKey)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	records, err := getKeyRecords(resultsIterator, pagination)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return envelopeResponse(records, pagination, nil)
+}
+
+//TXID 為空字串時回傳全部歷史，否則只回傳該 TxId 的紀錄
+func getHistoryRecords(stub shim.ChaincodeStubInterface, key string, TXID string) ([]HistoryRecord, error) {
+
+	records := []HistoryRecord{}
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return records, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return records, err
+		}
+		if TXID != "" && response.TxId != TXID {
+			continue
+		}
+		record := HistoryRecord{}
+		record.TxId = response.TxId
+		if response.IsDelete != true {
+			record.Value = getRecordValue(response.Value)
+		}
+		record.Timestamp = time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String()
+		record.IsDelete = response.IsDelete
+		records = append(records, record)
+		if TXID != "" {
+			break
+		}
+	}
+	return records, nil
+}
+
+func getHistoryResponse(stub shim.ChaincodeStubInterface, key string, TXID string) peer.Response {
+
+	records, err := getHistoryRecords(stub, key, TXID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	var warnings []string
+	if TXID != "" && len(records) == 0 {
+		warnings = append(warnings, "Failed to find TxId "+TXID+" in the history of "+key)
+	}
+	return dataResponse(records, warnings...)
+}
@@ -36,10 +36,10 @@ package main
  * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
  */
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -59,6 +59,7 @@ const (
 	unitAmount            = int64(1000000)           //1單位=100萬
 	perDayMillionInterest = float64(27.3972603)      //每1百萬面額，利率=1%，一天的利息
 	perDayInterest        = float64(0.0000273972603) //每1元面額，利率=1%，一天的利息
+	maxInterestRate       = float64(20)              //票面利率上限(%)
 	//InterestObjectType    = "Interest"
 )
 
@@ -123,7 +124,7 @@ type Security struct {
 	TotalAmount          int64           `json:"TotalAmount"`
 	Balance              int64           `json:"Balance"`
 	SecurityStatus       int             `json:"SecurityStatus"`
-	Owners               []Owner         `json:"Owners"`
+	Owners               []Owner         `json:"Owners,omitempty"`
 	SecurityTotals       []SecurityTotal `json:"SecurityTotals"`
 	SecurityDurationDate []string        `json:"SecurityDurationDate"`
 }
@@ -156,10 +157,45 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) peer.Response
  * The Invoke method is called as a result of an application request to run the Smart Contract "CGSecurity"
  * The calling application program has also specified the particular smart contract function to be called, with arguments
  */
-func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Response {
+func (s *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 
+	// Reads within this transaction see its own writes, see TxStub.go
+	APIstub := newTxStub(stub)
 	// Retrieve the requested Smart Contract function and arguments
 	function, args := APIstub.GetFunctionAndParameters()
+	// A single JSON object argument is mapped onto the positional args, see Request.go
+	args, err := getRequestArgs(function, args)
+	if err != nil {
+		return errorResponse(codeArgumentFormat, err.Error())
+	}
+	response := s.invokeFunction(APIstub, function, args)
+	// Re-test queued PaymentError / Waiting4Payment transactions when the call
+	// already wrote the day's queue, see QueueManager.go
+	if response.Status == shim.OK && len(APIstub.increased) > 0 && isQueueWritten(APIstub) {
+		_, err := retryQueuedTransactions(APIstub, true)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	// Error messages are returned as JSON with a stable code, see Errors.go
+	if response.Status != shim.OK {
+		return toErrorResponse(response)
+	}
+	// Append an AuditRecord for every call that changed state, see Audit.go
+	err = putAuditRecord(APIstub, function, args)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return response
+}
+
+func (s *SmartContract) invokeFunction(APIstub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+
+	//需雙人覆核之管理交易，只能由 approveChange 執行
+	if _, ok := makerCheckerFunctions[function]; ok == true {
+		return errorResponse(codeDualAuthRequired, function+" must be submitted with submitChange and approved with approveChange")
+	}
+
 	// Route to the appropriate handler function to interact with the ledger appropriately
 	if function == "querySecurity" {
 		return s.querySecurity(APIstub, args)
@@ -179,16 +215,10 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Respons
 		return s.queryOwnerLength(APIstub, args)
 	} else if function == "queryBankSecurityTotals" {
 		return s.queryBankSecurityTotals(APIstub, args)
-	} else if function == "changeSecurity" {
-		return s.changeSecurity(APIstub, args)
-	} else if function == "changeSecurityStatus" {
-		return s.changeSecurityStatus(APIstub, args)
 	} else if function == "changeBankSecurityTotals" {
 		return s.changeBankSecurityTotals(APIstub, args)
 	} else if function == "changeOwnerAvaliable" {
 		return s.changeOwnerAvaliable(APIstub, args)
-	} else if function == "deleteSecurity" {
-		return s.deleteSecurity(APIstub, args)
 	} else if function == "deleteOwner" {
 		return s.deleteOwner(APIstub, args)
 	} else if function == "updateOwnerInterest" {
@@ -201,19 +231,21 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Respons
 		return s.queryAllSecurityKeys(APIstub, args)
 	} else if function == "querySecurityTotals" {
 		return s.querySecurityTotals(APIstub, args)
+	} else if function == "querySecurityTerms" {
+		return s.querySecurityTerms(APIstub, args)
+	} else if function == "reopenSecurity" {
+		return s.reopenSecurity(APIstub, args)
+	} else if function == "querySecurityTranches" {
+		return s.querySecurityTranches(APIstub, args)
 		// Account Functions
 	} else if function == "initAccount" {
 		return s.initAccount(APIstub, args)
 	} else if function == "deleteAccount" {
 		return s.deleteAccount(APIstub, args)
 	} else if function == "readAccount" {
-		return s.getStateAsBytes(APIstub, args)
+		return s.readAccount(APIstub, args)
 	} else if function == "updateAccountStatus" {
 		return s.updateAccountStatus(APIstub, args)
-	} else if function == "updateAccount" {
-		return s.updateAccount(APIstub, args)
-	} else if function == "updateAsset" {
-		return s.updateAsset(APIstub, args)
 	} else if function == "updateAssetBalance" {
 		return s.updateAssetBalance(APIstub, args)
 	} else if function == "deleteAsset" {
@@ -239,8 +271,6 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Respons
 		return s.initBank(APIstub, args)
 	} else if function == "updateBank" {
 		return s.updateBank(APIstub, args)
-	} else if function == "deleteBank" {
-		return s.deleteBank(APIstub, args)
 	} else if function == "verifyBankList" {
 		return s.verifyBankList(APIstub, args)
 	} else if function == "readBank" {
@@ -294,12 +324,124 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Respons
 		return s.updateQueuedTransactionHcode(APIstub, args)
 	} else if function == "updateHistoryTransactionHcode" {
 		return s.updateHistoryTransactionHcode(APIstub, args)
+		// Reconcile Functions
+	} else if function == "reconcile" {
+		return s.reconcile(APIstub, args)
+	} else if function == "repairTotals" {
+		return s.repairTotals(APIstub, args)
+		// Position Functions
+	} else if function == "migratePositions" {
+		return s.migratePositions(APIstub, args)
+		// Total Delta Functions
+	} else if function == "compactTotals" {
+		return s.compactTotals(APIstub, args)
+	} else if function == "queryBankAccounts" {
+		return s.queryBankAccounts(APIstub, args)
+		// Business Day Functions
+	} else if function == "openBusinessDay" {
+		return s.openBusinessDay(APIstub, args)
+	} else if function == "cutOffBusinessDay" {
+		return s.cutOffBusinessDay(APIstub, args)
+	} else if function == "setCutOffTime" {
+		return s.setCutOffTime(APIstub, args)
+	} else if function == "queryOpeningPositions" {
+		return s.queryOpeningPositions(APIstub, args)
+		// Settlement Functions
+	} else if function == "setSettlementMode" {
+		return s.setSettlementMode(APIstub, args)
+	} else if function == "runSettlementCycle" {
+		return s.runSettlementCycle(APIstub, args)
+	} else if function == "querySettlementCycles" {
+		return s.querySettlementCycles(APIstub, args)
+	} else if function == "queryDueTransactions" {
+		return s.queryDueTransactions(APIstub, args)
+		// Queue Functions
+	} else if function == "resolveGridlock" {
+		return s.resolveGridlock(APIstub, args)
+	} else if function == "updateTransactionPriority" {
+		return s.updateTransactionPriority(APIstub, args)
+	} else if function == "queryQueuePosition" {
+		return s.queryQueuePosition(APIstub, args)
+	} else if function == "queryMatchCandidates" {
+		return s.queryMatchCandidates(APIstub, args)
+		// Instruction Functions
+	} else if function == "cancelInstruction" {
+		return s.cancelInstruction(APIstub, args)
+	} else if function == "holdInstruction" {
+		return s.holdInstruction(APIstub, args)
+	} else if function == "releaseInstruction" {
+		return s.releaseInstruction(APIstub, args)
+	} else if function == "queryInstructionActions" {
+		return s.queryInstructionActions(APIstub, args)
+	} else if function == "closeBusinessDay" {
+		return s.closeBusinessDay(APIstub, args)
+	} else if function == "queryBusinessDay" {
+		return s.queryBusinessDay(APIstub, args)
+		// Maker-Checker Functions
+	} else if function == "submitChange" {
+		return s.submitChange(APIstub, args)
+	} else if function == "approveChange" {
+		return s.approveChange(APIstub, args)
+	} else if function == "rejectChange" {
+		return s.rejectChange(APIstub, args)
+	} else if function == "queryPendingChanges" {
+		return s.queryPendingChanges(APIstub, args)
+		// Auction Functions
+	} else if function == "openAuction" {
+		return s.openAuction(APIstub, args)
+	} else if function == "submitBid" {
+		return s.submitBid(APIstub, args)
+	} else if function == "closeAuction" {
+		return s.closeAuction(APIstub, args)
+	} else if function == "queryAuction" {
+		return s.queryAuction(APIstub, args)
+	} else if function == "queryAuctionBids" {
+		return s.queryAuctionBids(APIstub, args)
+	} else if function == "queryAuctionResult" {
+		return s.queryAuctionResult(APIstub, args)
+		// Halt Functions
+	} else if function == "activateHalt" {
+		return s.activateHalt(APIstub, args)
+	} else if function == "releaseHalt" {
+		return s.releaseHalt(APIstub, args)
+	} else if function == "queryHalts" {
+		return s.queryHalts(APIstub, args)
+		// Pledge Functions
+	} else if function == "pledgeSecurity" {
+		return s.pledgeSecurity(APIstub, args)
+	} else if function == "releasePledge" {
+		return s.releasePledge(APIstub, args)
+	} else if function == "queryPledges" {
+		return s.queryPledges(APIstub, args)
+		// Repo Functions
+	} else if function == "submitRepo" {
+		return s.submitRepo(APIstub, args)
+	} else if function == "acceptRepo" {
+		return s.acceptRepo(APIstub, args)
+	} else if function == "cancelRepo" {
+		return s.cancelRepo(APIstub, args)
+	} else if function == "terminateRepo" {
+		return s.terminateRepo(APIstub, args)
+	} else if function == "settleRepo" {
+		return s.settleRepo(APIstub, args)
+	} else if function == "queryRepo" {
+		return s.queryRepo(APIstub, args)
+	} else if function == "queryAccountRepos" {
+		return s.queryAccountRepos(APIstub, args)
+		// Audit Functions
+	} else if function == "queryAuditRecords" {
+		return s.queryAuditRecords(APIstub, args)
+		// Error Functions
+	} else if function == "queryErrorCodes" {
+		return s.queryErrorCodes(APIstub, args)
+	} else if function == "queryRequestSchema" {
+		return s.queryRequestSchema(APIstub, args)
 	} else {
 		//map functions
 		return s.mapFunction(APIstub, function, args)
 	}
 
-	return shim.Error("Invalid Smart Contract function name.")
+	return errorResponse(codeInvalidFunction, "Invalid Smart Contract function name.")
 }
 
 func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
@@ -307,57 +449,68 @@ func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function s
 
 	case "put":
 		if len(args) < 2 {
-			return shim.Error("put operation must include two arguments: [key, value]")
+			return errorResponse(codeArgumentCount, "put operation must include two arguments: [key, value]")
 		}
 		key := args[0]
 		value := args[1]
+		if isAuditKey(key) == true {
+			return errorResponse(codeUnauthorized, "put operation can not change an audit record")
+		}
+		if key == settlementModeKey {
+			return errorResponse(codeUnauthorized, "put operation can not change "+key+", use setSettlementMode")
+		}
+		if key == cutOffTimeKey {
+			return errorResponse(codeUnauthorized, "put operation can not change "+key+", use setCutOffTime")
+		}
 
 		if err := stub.PutState(key, []byte(value)); err != nil {
 			fmt.Printf("Error putting state %s", err)
-			return shim.Error(fmt.Sprintf("put operation failed. Error updating state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("put operation failed. Error updating state: %s", err))
 		}
 
 		indexName := "compositeKeyTest"
 		compositeKeyTestIndex, err := stub.CreateCompositeKey(indexName, []string{key})
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		valueByte := []byte{0x00}
 		if err := stub.PutState(compositeKeyTestIndex, valueByte); err != nil {
 			fmt.Printf("Error putting state with compositeKey %s", err)
-			return shim.Error(fmt.Sprintf("put operation failed. Error updating state with compositeKey: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("put operation failed. Error updating state with compositeKey: %s", err))
 		}
 
 		return shim.Success(nil)
 
 	case "remove":
 		if len(args) < 1 {
-			return shim.Error("remove operation must include one argument: [key]")
+			return errorResponse(codeArgumentCount, "remove operation must include one argument: [key]")
 		}
 		key := args[0]
+		if isAuditKey(key) == true {
+			return errorResponse(codeUnauthorized, "remove operation can not change an audit record")
+		}
 
 		err := stub.DelState(key)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("remove operation failed. Error updating state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("remove operation failed. Error updating state: %s", err))
 		}
 		return shim.Success(nil)
 
 	case "get":
 		if len(args) < 1 {
-			return shim.Error("get operation must include one argument, a key")
+			return errorResponse(codeArgumentCount, "get operation must include one argument, a key")
 		}
 		key := args[0]
 		value, err := stub.GetState(key)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("get operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("get operation failed. Error accessing state: %s", err))
 		}
-		jsonVal, err := json.Marshal(string(value))
-		return shim.Success(jsonVal)
+		return dataResponse(string(value))
 
 	case "keys":
 		if len(args) < 2 {
-			return shim.Error("put operation must include two arguments, a key and value")
+			return errorResponse(codeArgumentCount, "put operation must include two arguments, a key and value")
 		}
 		startKey := args[0]
 		endKey := args[1]
@@ -370,11 +523,11 @@ func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function s
 
 		keysIter, err := stub.GetStateByRange(startKey, endKey)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 		}
 		defer keysIter.Close()
 
-		var keys []string
+		keys := []string{}
 		for keysIter.HasNext() {
 			//if sleeptime is specied, take a nap
 			if stime > 0 {
@@ -383,7 +536,7 @@ func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function s
 
 			response, iterErr := keysIter.Next()
 			if iterErr != nil {
-				return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+				return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 			}
 			keys = append(keys, response.Key)
 		}
@@ -392,48 +545,38 @@ func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function s
 			fmt.Printf("key %d contains %s\n", key, value)
 		}
 
-		jsonKeys, err := json.Marshal(keys)
-		if err != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error marshaling JSON: %s", err))
-		}
-
-		return shim.Success(jsonKeys)
+		return envelopeResponse(keys, &Pagination{StartKey: startKey, EndKey: endKey, Count: len(keys)}, nil)
 	case "query":
 		query := args[0]
 		keysIter, err := stub.GetQueryResult(query)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("query operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("query operation failed. Error accessing state: %s", err))
 		}
 		defer keysIter.Close()
 
-		var keys []string
+		keys := []string{}
 		for keysIter.HasNext() {
 			response, iterErr := keysIter.Next()
 			if iterErr != nil {
-				return shim.Error(fmt.Sprintf("query operation failed. Error accessing state: %s", err))
+				return errorResponse(codeStateError, fmt.Sprintf("query operation failed. Error accessing state: %s", err))
 			}
 			keys = append(keys, response.Key)
 		}
 
-		jsonKeys, err := json.Marshal(keys)
-		if err != nil {
-			return shim.Error(fmt.Sprintf("query operation failed. Error marshaling JSON: %s", err))
-		}
-
-		return shim.Success(jsonKeys)
+		return dataResponse(keys)
 	case "history":
 		key := args[0]
 		keysIter, err := stub.GetHistoryForKey(key)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("query operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("query operation failed. Error accessing state: %s", err))
 		}
 		defer keysIter.Close()
 
-		var keys []string
+		keys := []string{}
 		for keysIter.HasNext() {
 			response, iterErr := keysIter.Next()
 			if iterErr != nil {
-				return shim.Error(fmt.Sprintf("query operation failed. Error accessing state: %s", err))
+				return errorResponse(codeStateError, fmt.Sprintf("query operation failed. Error accessing state: %s", err))
 			}
 			keys = append(keys, response.TxId)
 		}
@@ -442,15 +585,10 @@ func (s *SmartContract) mapFunction(stub shim.ChaincodeStubInterface, function s
 			fmt.Printf("key %d contains %s\n", key, txID)
 		}
 
-		jsonKeys, err := json.Marshal(keys)
-		if err != nil {
-			return shim.Error(fmt.Sprintf("query operation failed. Error marshaling JSON: %s", err))
-		}
-
-		return shim.Success(jsonKeys)
+		return dataResponse(keys)
 
 	default:
-		return shim.Success([]byte("Unsupported operation"))
+		return errorResponse(codeInvalidFunction, "Unsupported operation")
 	}
 }
 
@@ -534,7 +672,10 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface, args []s
 		owner.OwnedPaidDurationInterest = owner.OwnedDurationInterest * PaidDurationPeriod
 		owner.OwnedRepay = unitAmount + owner.OwnedInterest
 		owner.Avaliable = 0
-		Securities[i].Owners = append(Securities[i].Owners, owner)
+		err := putPositionStruct(APIstub, ownerToPosition(Securities[i].SecurityID, owner))
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
 
 		//err := updateBankTotals(APIstub, args[0], Securities[i].SecurityID, owner.OwnedBalance, owner.OwnedBalance, false)
 		//if err != nil {
@@ -552,6 +693,16 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface, args []s
 		Securities[i].SecurityDurationDate = SecurityDurationDate
 		Securities[i].TotalAmount = 25000 * unitAmount
 		Securities[i].Balance = Securities[i].TotalAmount - owner.OwnedBalance
+		terms := newSecurityTerms(&Securities[i], getTermsDate(Securities[i].IssueDate))
+		err = putSecurityTerms(APIstub, &terms)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		tranche := newSecurityTranche(Securities[i].SecurityID, 1, Securities[i].IssueDate, Securities[i].TotalAmount, parPrice)
+		err = putSecurityTranche(APIstub, &tranche)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
 		SecurityAsBytes, _ := json.Marshal(Securities[i])
 		//APIstub.PutState("Security"+strconv.Itoa(i), SecurityAsBytes)
 		APIstub.PutState(Securities[i].SecurityID, SecurityAsBytes)
@@ -563,11 +714,21 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface, args []s
 	return shim.Success(nil)
 }
 
+//第 8 個參數為狀態(ANNOUNCED 或 ISSUED)，未輸入時為 ISSUED
 //peer chaincode invoke -n mycc -c '{"Args":["createSecurity", "A07103","107A03","2018/03/02","2028/03/02","1","10","25000000000"]}' -C myc
+//peer chaincode invoke -n mycc -c '{"Args":["createSecurity", "A07104","107A04","2018/04/02","2028/04/02","1","10","25000000000","ANNOUNCED"]}' -C myc
 func (s *SmartContract) createSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 7 {
-		return shim.Error("Incorrect number of arguments. Expecting 7")
+	if len(args) != 7 && len(args) != 8 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 7 or 8")
+	}
+	newStatus := securityIssued
+	if len(args) == 8 && args[7] != "" {
+		var ok bool
+		newStatus, ok = getSecurityStatusFromName(args[7])
+		if ok != true || (newStatus != securityIssued && newStatus != securityAnnounced) {
+			return errorResponse(codeArgumentFormat, "SecurityStatus must be ANNOUNCED or ISSUED")
+		}
 	}
 
 	var newRepayPeriod int
@@ -575,22 +736,36 @@ func (s *SmartContract) createSecurity(APIstub shim.ChaincodeStubInterface, args
 	var newAmount int64
 	newRate, err := strconv.ParseFloat(args[4], 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newRepayPeriod, err = strconv.Atoi(args[5])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newAmount, err = strconv.ParseInt(args[6], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 
-	var Security = Security{ObjectType: "security", SecurityID: args[0], SecurityName: args[1], IssueDate: args[2], MaturityDate: args[3], InterestRate: newRate, RepayPeriod: newRepayPeriod, TotalAmount: newAmount, Balance: newAmount}
+	var Security = Security{ObjectType: "security", SecurityID: args[0], SecurityName: args[1], IssueDate: args[2], MaturityDate: args[3], InterestRate: newRate, RepayPeriod: newRepayPeriod, TotalAmount: newAmount, Balance: newAmount, SecurityStatus: newStatus}
+	code, errMsg := validateSecurity(APIstub, &Security, true, "", 0)
+	if code != "" {
+		return errorResponse(code, errMsg)
+	}
+	terms := newSecurityTerms(&Security, getTermsDate(Security.IssueDate))
+	err = putSecurityTerms(APIstub, &terms)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to create state")
+	}
+	tranche := newSecurityTranche(Security.SecurityID, 1, Security.IssueDate, Security.TotalAmount, parPrice)
+	err = putSecurityTranche(APIstub, &tranche)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to create state")
+	}
 	SecurityAsBytes, _ := json.Marshal(Security)
 	err2 := APIstub.PutState(Security.SecurityID, SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to create state")
+		return errorResponse(codeStateError, "Failed to create state")
 	}
 
 	return shim.Success(nil)
@@ -600,117 +775,126 @@ func (s *SmartContract) createSecurity(APIstub shim.ChaincodeStubInterface, args
 func (s *SmartContract) querySecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	return shim.Success(SecurityAsBytes)
-}
-
-//peer chaincode query -n mycc -c '{"Args":["queryAllSecurities","A000000","Z999999"]}' -C myc
-func (s *SmartContract) queryAllSecurities(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	if SecurityAsBytes == nil {
+		return errorResponse(codeNotFound, "Failed to find SecurityID "+args[0])
 	}
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
+	Security := Security{}
+	json.Unmarshal(SecurityAsBytes, &Security)
+	//顯示今日生效的發行條件
+	timeline, err := getSecurityTermsTimeline(APIstub, &Security)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, "Failed to query SecurityTerms state")
 	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	applySecurityTerms(&Security, getTermsInForce(timeline, SubString(time.Now().Format(timelayout), 0, 8)))
+	Owners, err := getSecurityOwners(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query owners state")
 	}
-	buffer.WriteString("]")
+	Security.Owners = Owners
+	Security.SecurityTotals, err = getEffectiveSecurityTotals(APIstub, &Security)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query SecurityTotals state")
+	}
+	return dataResponse(Security)
+}
 
-	fmt.Printf("%s", buffer.String())
+//peer chaincode query -n mycc -c '{"Args":["queryAllSecurities","A000000","Z999999"]}' -C myc
+func (s *SmartContract) queryAllSecurities(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
-//peer chaincode invoke -n mycc -c '{"Args":["changeSecurity", "A07103","107A03","2018/03/02","2028/03/02","1","10","25000000000","002000000001","002","1000000","1000000","0"]}' -C myc
+//第 13 個參數為發行條件的生效日(YYYYMMDD)，未輸入時為今日
+//peer chaincode invoke -n mycc -c '{"Args":["changeSecurity", "A07103","107A03","2018/03/02","2028/03/02","1","10","25000000000","002000000001","002","1000000","1000000","0","20190701"]}' -C myc
 func (s *SmartContract) changeSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 12 {
-		return shim.Error("Incorrect number of arguments. Expecting 12")
+	if len(args) != 12 && len(args) != 13 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 12 or 13")
 	}
 
 	TimeNow := time.Now().Format(timelayout)
 	TimeNow2 := time.Now().Format(timelayout2)
 	Today := SubString(TimeNow, 0, 8)
+	EffectiveDate := Today
+	if len(args) == 13 && args[12] != "" {
+		_, err := time.Parse("20060102", args[12])
+		if err != nil {
+			return errorResponse(codeArgumentFormat, "EffectiveDate must be a YYYYMMDD string.")
+		}
+		EffectiveDate = args[12]
+	}
 
 	var newRepayPeriod, newAvaliable int
 	var newRate float64
 	var newAmount, newOwnedBalance, newOwnedAmount int64
 	newRate, err := strconv.ParseFloat(args[4], 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newRepayPeriod, err = strconv.Atoi(args[5])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newAmount, err = strconv.ParseInt(args[6], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newOwnedBalance, err = strconv.ParseInt(args[9], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newOwnedAmount, err = strconv.ParseInt(args[10], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 	newAvaliable, err = strconv.Atoi(args[11])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
+	SecurityAsBytes, err := APIstub.GetState(args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to get state for "+args[0])
+	} else if SecurityAsBytes == nil {
+		return errorResponse(codeNotFound, "Failed to find SecurityID "+args[0])
+	}
 	Security := Security{}
 
 	json.Unmarshal(SecurityAsBytes, &Security)
 	Security.ObjectType = "security"
+	Security.SecurityID = args[0]
+	//變更前的版本，尚無版本時以變更前的條件建立起始版本
+	timeline, err := getSecurityTermsTimeline(APIstub, &Security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
 	Security.SecurityName = args[1]
 	Security.IssueDate = args[2]
 	Security.MaturityDate = args[3]
 	Security.InterestRate = newRate
 	Security.RepayPeriod = newRepayPeriod
+	//未持有餘額隨發行總額調整
+	Security.Balance += newAmount - Security.TotalAmount
 	Security.TotalAmount = newAmount
+	code, errMsg := validateSecurity(APIstub, &Security, false, args[7], newOwnedBalance)
+	if code != "" {
+		return errorResponse(code, errMsg)
+	}
+	timeline, err = addSecurityTerms(APIstub, timeline, newSecurityTerms(&Security, EffectiveDate))
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to change state")
+	}
+	//Security 顯示今日生效的條件
+	applySecurityTerms(&Security, getTermsInForce(timeline, Today))
 
 	var doflg bool
-	doflg = false
 	var PaidDurationInterest int64
 	PaidDurationInterest = 0
 
-	var OwnedDurationDate []string
 	var oldOwnedBalance int64
 	var oldOwnedAmount int64
 	var oldOwnedInterest int64
@@ -721,85 +905,42 @@ func (s *SmartContract) changeSecurity(APIstub shim.ChaincodeStubInterface, args
 	oldOwnedInterest = 0
 	newOwnedInterest = 0
 
-	for key, val := range Security.Owners {
-		if val.OwnedAccountID == args[7] {
-			oldOwnedBalance = Security.Owners[key].OwnedBalance
-			oldOwnedAmount = Security.Owners[key].OwnedAmount
-			oldOwnedInterest = Security.Owners[key].OwnedInterest
-			Security.Balance += Security.Owners[key].OwnedBalance
-			Security.Owners[key].OwnedBankID = args[8]
-			Security.Owners[key].OwnedBalance = newOwnedBalance
-			Security.Owners[key].OwnedAmount = newOwnedAmount
-			//myOwnedBalance := float64(Security.Owners[key].OwnedBalance)
-			//OwnedInterest := perDayInterest * daySub(Security.IssueDate, Security.MaturityDate) * Security.InterestRate * myOwnedBalance
-			OwnedInterest := float64(newOwnedBalance) * float64(Security.InterestRate/100)
-			Security.Owners[key].OwnedInterest = int64(OwnedInterest)
-			newOwnedInterest = Security.Owners[key].OwnedInterest
-			Security.Owners[key].OwnedDurationInterest = Security.Owners[key].OwnedInterest / int64(Security.RepayPeriod)
-			j := 0
-			var SecurityDurationDate []string
-			var PaidDurationPeriod int64
-
-			for j < Security.RepayPeriod {
-				NextPayInterestDate, _ := generateMaturity(Security.IssueDate, j+1, 0, 0)
-				OwnedDurationDate = append(OwnedDurationDate, NextPayInterestDate)
-				SecurityDurationDate = append(SecurityDurationDate, NextPayInterestDate)
-				if Today >= NextPayInterestDate {
-					PaidDurationPeriod = int64(j + 1)
-					PaidDurationInterest = newOwnedInterest * PaidDurationPeriod
-				}
-				j = j + 1
-			}
-			Security.Owners[key].OwnedDurationDate = OwnedDurationDate
-			Security.Owners[key].OwnedPaidDurationInterest = Security.Owners[key].OwnedDurationInterest * PaidDurationPeriod
-			Security.SecurityDurationDate = SecurityDurationDate
-			Security.Owners[key].OwnedRepay = newOwnedBalance + Security.Owners[key].OwnedInterest
-			Security.Owners[key].Avaliable = newAvaliable
-			Security.Balance -= Security.Owners[key].OwnedBalance
-			doflg = true
-			break
+	position, err := getPositionStruct(APIstub, args[7], args[0])
+	if err == nil {
+		if newOwnedBalance < position.PledgedBalance {
+			return errorResponse(codeInsufficientFreeBalance, fmt.Sprintf("OwnedBalance (%d) of %s %s must not be less than PledgedBalance (%d)", newOwnedBalance, args[7], args[0], position.PledgedBalance))
 		}
-	}
-
+		oldOwnedBalance = position.Balance
+		oldOwnedAmount = position.OwnedAmount
+		oldOwnedInterest = position.OwnedInterest
+		Security.Balance += position.Balance
+	} else {
+		position = newPosition(args[7], args[8], args[0])
+	}
+	position.BankID = args[8]
+	position.Balance = newOwnedBalance
+	position.Position += newOwnedBalance - oldOwnedBalance
+	position.PendingBalance += newOwnedBalance - oldOwnedBalance
+	position.OwnedAmount = newOwnedAmount
+	setPositionInterest(timeline, position, Today)
+	newOwnedInterest = position.OwnedInterest
+	PaidDurationInterest = position.OwnedPaidDurationInterest
+	position.Avaliable = newAvaliable
+	Security.Balance -= newOwnedBalance
+	Security.SecurityDurationDate = position.OwnedDurationDate
+
+	err = putPositionStruct(APIstub, position)
 	if err != nil {
-		return shim.Error("Failed to change banktotal state")
-	}
-
-	if doflg != true {
-		var owner Owner
-		owner.OwnedAccountID = args[7]
-		owner.OwnedBankID = args[8]
-		owner.OwnedBalance = newOwnedBalance
-		owner.OwnedAmount = newOwnedAmount
-		//owner.OwnedInterest = int64(round(perDayMillionInterest*daySub(Security.IssueDate, Security.MaturityDate)*Security.InterestRate, 0)) * int64(newOwnedBalance/unitAmount)
-		OwnedInterest := float64(newOwnedBalance) * float64(Security.InterestRate/100)
-		owner.OwnedInterest = int64(OwnedInterest)
-		newOwnedInterest = owner.OwnedInterest
-		owner.OwnedDurationInterest = owner.OwnedInterest / int64(Security.RepayPeriod)
-		j := 0
-		var SecurityDurationDate []string
-		var PaidDurationPeriod int64
-		for j < Security.RepayPeriod {
-			NextPayInterestDate, _ := generateMaturity(Security.IssueDate, j+1, 0, 0)
-			owner.OwnedDurationDate = append(owner.OwnedDurationDate, NextPayInterestDate)
-			SecurityDurationDate = append(SecurityDurationDate, NextPayInterestDate)
-			if Today >= NextPayInterestDate {
-				PaidDurationPeriod = int64(j + 1)
-				PaidDurationInterest = newOwnedInterest * PaidDurationPeriod
-			}
-			j = j + 1
-		}
-		owner.OwnedRepay = newOwnedBalance + owner.OwnedInterest
-		owner.OwnedPaidDurationInterest = owner.OwnedDurationInterest * PaidDurationPeriod
-		owner.Avaliable = newAvaliable
-		Security.Owners = append(Security.Owners, owner)
-		Security.SecurityDurationDate = SecurityDurationDate
-		Security.Balance -= newOwnedBalance
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
 	doflg = false
 	var securityTotal SecurityTotal
 	BankID := args[8]
+	_, err = foldSecurityTotalDeltas(APIstub, &Security, BankID)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to change state")
+	}
 
 	fmt.Printf("BankID=%s\n", BankID)
 	for key, val := range Security.SecurityTotals {
@@ -838,24 +979,96 @@ func (s *SmartContract) changeSecurity(APIstub shim.ChaincodeStubInterface, args
 	SecurityAsBytes, _ = json.Marshal(Security)
 	err2 := APIstub.PutState(args[0], SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to change state")
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
 	return shim.Success(nil)
 }
 
+//債券代號：一碼英文字母加五碼數字，例如 A07103
+var securityIDPattern = regexp.MustCompile(`^[A-Z][0-9]{5}$`)
+
+/*
+檢查債券主檔，通過時回傳空字串，否則回傳錯誤代碼及說明：
+1.SecurityID 格式
+2.IssueDate、MaturityDate 為 YYYY/MM/DD 且到期日晚於發行日
+3.InterestRate 介於 0 與 maxInterestRate 之間
+4.RepayPeriod 至少 1 年，且發行日加 RepayPeriod 年等於到期日
+5.新增時 SecurityID 不可已存在
+6.TotalAmount 不可小於各帳戶持有餘額合計(AccountID 的持有餘額以 OwnedBalance 計算)
+*/
+func validateSecurity(stub shim.ChaincodeStubInterface, security *Security, isCreate bool, AccountID string, OwnedBalance int64) (string, string) {
+
+	if securityIDPattern.MatchString(security.SecurityID) != true {
+		return codeSecurityIDFormat, "SecurityID must be one capital letter followed by 5 digits: " + security.SecurityID
+	}
+	issueDate, err := time.Parse(layout, security.IssueDate)
+	if err != nil {
+		return codeSecurityDateInvalid, "IssueDate must be a YYYY/MM/DD string: " + security.IssueDate
+	}
+	maturityDate, err := time.Parse(layout, security.MaturityDate)
+	if err != nil {
+		return codeSecurityDateInvalid, "MaturityDate must be a YYYY/MM/DD string: " + security.MaturityDate
+	}
+	if maturityDate.After(issueDate) != true {
+		return codeSecurityDateInvalid, "MaturityDate must be after IssueDate"
+	}
+	if security.InterestRate < 0 || security.InterestRate > maxInterestRate {
+		return codeInterestRateRange, "InterestRate must be between 0 and " + strconv.FormatFloat(maxInterestRate, 'f', -1, 64)
+	}
+	if security.RepayPeriod < 1 {
+		return codeSecurityTermMismatch, "RepayPeriod must be at least 1"
+	}
+	if issueDate.AddDate(security.RepayPeriod, 0, 0).Equal(maturityDate) != true {
+		return codeSecurityTermMismatch, "MaturityDate must be " + strconv.Itoa(security.RepayPeriod) + " years after IssueDate"
+	}
+
+	if isCreate == true {
+		SecurityAsBytes, err := stub.GetState(security.SecurityID)
+		if err != nil {
+			return codeStateError, err.Error()
+		}
+		if SecurityAsBytes != nil {
+			return codeAlreadyExists, "SecurityID " + security.SecurityID + " already exists"
+		}
+	}
+
+	positions, err := getSecurityPositions(stub, security.SecurityID)
+	if err != nil {
+		return codeStateError, err.Error()
+	}
+	holdings := OwnedBalance
+	for _, val := range positions {
+		if val.AccountID != AccountID {
+			holdings += val.Balance
+		}
+	}
+	if security.TotalAmount < holdings {
+		return codeTotalBelowHoldings, "TotalAmount must not be less than the outstanding holdings " + strconv.FormatInt(holdings, 10)
+	}
+	return "", ""
+}
+
 //peer chaincode invoke -n mycc -c '{"Args":["deleteSecurity", "A07103"]}' -C myc
 // Deletes an entity from state
 func (s *SmartContract) deleteSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	// Delete the key from the state in ledger
 	err := APIstub.DelState(args[0])
 	if err != nil {
-		return shim.Error("Failed to delete state")
+		return errorResponse(codeStateError, "Failed to delete state")
+	}
+	err = delSecurityTerms(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to delete state")
+	}
+	err = delSecurityTranches(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to delete state")
 	}
 
 	return shim.Success(nil)
@@ -865,57 +1078,58 @@ func (s *SmartContract) deleteSecurity(APIstub shim.ChaincodeStubInterface, args
 func (s *SmartContract) deleteOwner(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	SecurityAsBytes, _ := APIstub.GetState(args[0])
 	Security := Security{}
 	json.Unmarshal(SecurityAsBytes, &Security)
 
-	var doflg bool
-	doflg = false
-	var owners []Owner
-	filteredOwners := owners[:0]
-	for key, val := range Security.Owners {
-		if val.OwnedAccountID == args[1] {
-			Security.Balance += Security.Owners[key].OwnedBalance
-			doflg = true
-		} else {
-			filteredOwners = append(filteredOwners, val)
+	position, err := getPositionStruct(APIstub, args[1], args[0])
+	if err == nil {
+		if errMsg := checkNoPledge(position); errMsg != "" {
+			return errorResponse(codePledgeActive, errMsg)
+		}
+		Security.Balance += position.Balance
+		err = delPositionStruct(APIstub, args[1], args[0])
+		if err != nil {
+			return errorResponse(codeStateError, "Failed to delete state")
 		}
 	}
 
-	if doflg == true {
-		Security.Owners = filteredOwners
-	}
 	SecurityAsBytes, _ = json.Marshal(Security)
 	err2 := APIstub.PutState(args[0], SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to delete state")
+		return errorResponse(codeStateError, "Failed to delete state")
 	}
 
 	return shim.Success(nil)
 }
 
-//peer chaincode invoke -n mycc -c '{"Args":["changeSecurityStatus", "A06101" , "1" ]}' -C myc
+//狀態轉換見 SecurityStatus.go 的 securityStatusTransitions
+//peer chaincode invoke -n mycc -c '{"Args":["changeSecurityStatus", "A06101" , "SUSPENDED" ]}' -C myc
 func (s *SmartContract) changeSecurityStatus(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
-	var newStatus int
-	newStatus, err := strconv.Atoi(args[1])
+	newStatus, ok := getSecurityStatusFromName(args[1])
+	if ok != true {
+		return errorResponse(codeArgumentFormat, "SecurityStatus must be one of "+strings.Join(getSecurityStatusList(), ", "))
+	}
+	Security, err := getSecurityStructFromID(APIstub, args[0])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeNotFound, "Failed to find SecurityID "+args[0])
+	}
+	Today := SubString(getTxTime(APIstub).Format(timelayout), 0, 8)
+	if errMsg := checkSecurityTransition(APIstub, Security, newStatus, Today); errMsg != "" {
+		return errorResponse(codeSecurityStatusTransition, errMsg)
 	}
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
 	Security.SecurityStatus = newStatus
-	SecurityAsBytes, _ = json.Marshal(Security)
+	SecurityAsBytes, _ := json.Marshal(Security)
 	err2 := APIstub.PutState(args[0], SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to change state")
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
 	return shim.Success(nil)
@@ -924,35 +1138,24 @@ func (s *SmartContract) changeSecurityStatus(APIstub shim.ChaincodeStubInterface
 func (s *SmartContract) changeOwnerAvaliable(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
-
-	var doflg bool
 	var newAvaliable int
 	newAvaliable, err := strconv.Atoi(args[2])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentFormat, err.Error())
 	}
-	doflg = false
 
-	for key, val := range Security.Owners {
-		if val.OwnedAccountID == args[1] {
-			Security.Owners[key].Avaliable = newAvaliable
-			doflg = true
-		}
-	}
-	if doflg != true {
-		return shim.Error("Failed to find ownedAccountID ")
+	position, err := getPositionStruct(APIstub, args[1], args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, "Failed to find ownedAccountID ")
 	}
+	position.Avaliable = newAvaliable
 
-	SecurityAsBytes, _ = json.Marshal(Security)
-	err2 := APIstub.PutState(args[0], SecurityAsBytes)
+	err2 := putPositionStruct(APIstub, position)
 	if err2 != nil {
-		return shim.Error("Failed to change state")
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
 	return shim.Success(nil)
@@ -962,7 +1165,7 @@ func (s *SmartContract) changeOwnerAvaliable(APIstub shim.ChaincodeStubInterface
 func (s *SmartContract) updateOwnerInterest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	TimeNow := time.Now().Format(timelayout)
@@ -978,45 +1181,41 @@ func (s *SmartContract) updateOwnerInterest(APIstub shim.ChaincodeStubInterface,
 
 	SecurityAsBytes, err := APIstub.GetState(SecurityID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	Security := Security{}
 	json.Unmarshal(SecurityAsBytes, &Security)
+	if errMsg := checkSecurityStatus(&Security, securityOpCoupon); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+	timeline, err := getSecurityTermsTimeline(APIstub, &Security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
 
-	for key, _ := range Security.Owners {
-		newOwnedBalance := float64(Security.Owners[key].OwnedBalance)
-		//OwnedInterest := perDayInterest * daySub(Security.IssueDate, Security.MaturityDate) * Security.InterestRate * newOwnedBalance
-		OwnedInterest := newOwnedBalance * float64(Security.InterestRate/100)
-		//fmt.Printf("daySub(Security.IssueDate, Security.MaturityDate)=%f\n", daySub(Security.IssueDate, Security.MaturityDate))
-
-		Security.Owners[key].OwnedInterest = int64(OwnedInterest)
-		Security.Owners[key].OwnedDurationInterest = Security.Owners[key].OwnedInterest / int64(Security.RepayPeriod)
-		Security.Owners[key].OwnedRepay = Security.Owners[key].OwnedBalance + Security.Owners[key].OwnedInterest
-		j := 0
-		var SecurityDurationDate []string
-		var PaidDurationPeriod int64
-		var OwnedDurationDate []string
-		for j < Security.RepayPeriod {
-			NextPayInterestDate, _ := generateMaturity(Security.IssueDate, j+1, 0, 0)
-			OwnedDurationDate = append(OwnedDurationDate, NextPayInterestDate)
-			SecurityDurationDate = append(SecurityDurationDate, NextPayInterestDate)
-			if Today >= NextPayInterestDate {
-				PaidDurationPeriod = int64(j + 1)
-			}
-			j = j + 1
+	positions, err := getSecurityPositions(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	for key, _ := range positions {
+		setPositionInterest(timeline, &positions[key], Today)
+		err = putPositionStruct(APIstub, &positions[key])
+		if err != nil {
+			return errorResponse(codeStateError, "Failed to change state")
 		}
-		Security.Owners[key].OwnedPaidDurationInterest = Security.Owners[key].OwnedDurationInterest * PaidDurationPeriod
-		fmt.Printf("Security.Owners[key].OwnedInterest=%d\n", Security.Owners[key].OwnedInterest)
-		fmt.Printf("Security.Owners[key].OwnedDurationInterest=%d\n", Security.Owners[key].OwnedDurationInterest)
-		fmt.Printf("Security.Owners[key].OwnedPaidDurationInterest=%d\n", Security.Owners[key].OwnedPaidDurationInterest)
 	}
 
 	SecurityAsBytes, _ = json.Marshal(Security)
 	err2 := APIstub.PutState(args[0], SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to change state")
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
+	Security.Owners, err = getSecurityOwners(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	SecurityAsBytes, _ = json.Marshal(Security)
 	return shim.Success(SecurityAsBytes)
 }
 
@@ -1024,162 +1223,82 @@ func (s *SmartContract) updateOwnerInterest(APIstub shim.ChaincodeStubInterface,
 func (s *SmartContract) queryOwner(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	SecurityAsBytes, _ := APIstub.GetState(args[0])
 	Security := Security{}
 	json.Unmarshal(SecurityAsBytes, &Security)
-
-	OwnerAsBytes, err := json.Marshal(Security.Owners)
+	Owners, err := getSecurityOwners(APIstub, args[0])
 	if err != nil {
-		return shim.Error("Failed to query owner state")
+		return errorResponse(codeStateError, "Failed to query owner state")
 	}
+	Security.Owners = Owners
 
-	return shim.Success(OwnerAsBytes)
+	return dataResponse(Security.Owners)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryOwnerLength","A07103"]}' -C myc
 func (s *SmartContract) queryOwnerLength(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"SecurityID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Security.SecurityID)
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"OwnersLength\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(strconv.Itoa(len(Security.Owners)))
-	buffer.WriteString("\"")
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	Security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	Owners, err := getSecurityOwners(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query owner state")
+	}
 
+	return dataResponse(SecurityOwnerLength{Security.SecurityID, len(Owners)})
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryOwnerAccount","A07103","002000000001"]}' -C myc
 func (s *SmartContract) queryOwnerAccount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
+	Security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	Owners, err := getSecurityOwners(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query owner state")
+	}
 
-	var doflg bool
-	doflg = false
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"SecurityID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Security.SecurityID)
-	buffer.WriteString("\"")
-	buffer.WriteString(",\"Records\":[")
-	bArrayMemberAlreadyWritten := false
-	for key, val := range Security.Owners {
+	OwnerAccount := SecurityOwnerAccount{SecurityID: Security.SecurityID, Records: []SecurityOwnerRecord{}}
+	for key, val := range Owners {
 		if val.OwnedAccountID == args[1] {
-			// Add a comma before array members, suppress it for the first array member
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			buffer.WriteString(", \"OwnedKey\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(key + 1))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedAccountID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.OwnedAccountID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedBankID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.OwnedBankID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedBalance\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedBalance, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedAmount\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedAmount, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedRepay\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedRepay, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedDurationInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedDurationInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"OwnedPaidDurationInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.OwnedPaidDurationInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Avaliable\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(val.Avaliable))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
-			doflg = true
+			OwnerAccount.Records = append(OwnerAccount.Records, SecurityOwnerRecord{key + 1, val})
 		}
 	}
-	buffer.WriteString("]")
-	if doflg != true {
-		//return shim.Error("Failed to find ownedAccountID ")
-		buffer.WriteString(", \"Value\":")
-		buffer.WriteString("Failed to find ownedAccountID")
+	if len(OwnerAccount.Records) == 0 {
+		return errorResponse(codeNotFound, "Failed to find ownedAccountID")
 	}
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return dataResponse(OwnerAccount)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["querySecurityStatus","A07103"]}' -C myc
 func (s *SmartContract) querySecurityStatus(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"SecurityID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Security.SecurityID)
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"SecurityStatus\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(strconv.Itoa(Security.SecurityStatus))
-	buffer.WriteString("\"")
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	Security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
 
+	return dataResponse(SecurityStatus{Security.SecurityID, Security.SecurityStatus, getSecurityStatusName(Security.SecurityStatus)})
 }
 
 func getSecurityStructFromID(
@@ -1206,73 +1325,21 @@ func getSecurityStructFromID(
 func (s *SmartContract) getHistoryForSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	SecurityID := args[0]
 
 	fmt.Printf("- start getHistoryForSecurity: %s\n", SecurityID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(SecurityID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForSecurity returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, SecurityID, "")
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryTXIDForSecurity","A07106","a4723f60d5c85d29a2107382fb8e3c8c1624924b970efa04f313727a0dfaa0ff"]}' -C myc
 func (s *SmartContract) getHistoryTXIDForSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	SecurityID := args[0]
@@ -1281,62 +1348,13 @@ func (s *SmartContract) getHistoryTXIDForSecurity(APIstub shim.ChaincodeStubInte
 	fmt.Printf("- start getHistoryTXIDForSecurity: %s\n", SecurityID)
 	fmt.Printf("- start getHistoryTXIDForSecurity: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(SecurityID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		if response.TxId == TXID {
-			buffer.WriteString("{\"TxId\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(response.TxId)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Value\":")
-			// if it was a delete operation on given key, then we need to set the
-			//corresponding value null. Else, we will write the response.Value
-			//as-is (as the Value itself a JSON marble)
-			if response.IsDelete {
-				buffer.WriteString("null")
-			} else {
-				buffer.WriteString(string(response.Value))
-			}
-
-			buffer.WriteString(", \"Timestamp\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-			buffer.WriteString("\"")
-
-			buffer.WriteString(", \"IsDelete\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatBool(response.IsDelete))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-
-			break
-		}
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryTXIDForSecurity returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, SecurityID, TXID)
 }
 
 func (s *SmartContract) queryAllSecurityKeys(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Keys operation must include two arguments, startKey and endKey")
+		return errorResponse(codeArgumentCount, "Keys operation must include two arguments, startKey and endKey")
 	}
 	startKey := args[0]
 	endKey := args[1]
@@ -1349,11 +1367,11 @@ func (s *SmartContract) queryAllSecurityKeys(APIstub shim.ChaincodeStubInterface
 
 	keysIter, err := APIstub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+		return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 	}
 	defer keysIter.Close()
 
-	var keys []string
+	keys := []string{}
 	for keysIter.HasNext() {
 		//if sleeptime is specied, take a nap
 		if stime > 0 {
@@ -1362,7 +1380,7 @@ func (s *SmartContract) queryAllSecurityKeys(APIstub shim.ChaincodeStubInterface
 
 		response, iterErr := keysIter.Next()
 		if iterErr != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 		}
 		keys = append(keys, response.Key)
 	}
@@ -1371,20 +1389,14 @@ func (s *SmartContract) queryAllSecurityKeys(APIstub shim.ChaincodeStubInterface
 		fmt.Printf("key %d contains %s\n", key, value)
 	}
 
-	jsonKeys, err := json.Marshal(keys)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error marshaling JSON: %s", err))
-	}
-
-	return shim.Success(jsonKeys)
-
+	return envelopeResponse(keys, &Pagination{StartKey: startKey, EndKey: endKey, Count: len(keys)}, nil)
 }
 
 //peer chaincode invoke -n mycc -c '{"Args":["changeBankSecurityTotals", "A07103","002","20190701"]}' -C myc
 func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 	TimeNow := time.Now().Format(timelayout)
 	TimeNow2 := time.Now().Format(timelayout2)
@@ -1404,6 +1416,20 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 	SecurityAsBytes, _ := APIstub.GetState(SecurityID)
 	Security := Security{}
 	json.Unmarshal(SecurityAsBytes, &Security)
+	if errMsg := checkSecurityStatus(&Security, securityOpCoupon); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+	//TotalBalance 以持有部位重新計算，先將 delta 併入 checkpoint
+	_, err := foldSecurityTotalDeltas(APIstub, &Security, BankID)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to change state")
+	}
+	timeline, err := getSecurityTermsTimeline(APIstub, &Security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	//以基準日生效的條件計算
+	terms := getTermsInForce(timeline, Today)
 
 	var doflg bool
 	doflg = false
@@ -1428,22 +1454,26 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 	OwnedDurationInterest = 0
 	oldOwnedDurationInterest = 0
 
-	for key, val := range Security.Owners {
-		if val.OwnedBankID == BankID {
-			oldOwnedBalance = Security.Owners[key].OwnedBalance
-			oldOwnedAmount = Security.Owners[key].OwnedAmount
-			oldOwnedInterest = Security.Owners[key].OwnedInterest
-			oldOwnedDurationInterest = Security.Owners[key].OwnedDurationInterest
+	positions, err := getSecurityPositions(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	for key, val := range positions {
+		if val.BankID == BankID {
+			oldOwnedBalance = positions[key].Balance
+			oldOwnedAmount = positions[key].OwnedAmount
+			oldOwnedInterest = positions[key].OwnedInterest
+			oldOwnedDurationInterest = positions[key].OwnedDurationInterest
 			newOwnedBalance += oldOwnedBalance
 			newOwnedAmount += oldOwnedAmount
 			newOwnedInterest += oldOwnedInterest
 
-			OwnedDurationInterest = newOwnedInterest / int64(Security.RepayPeriod)
+			OwnedDurationInterest = newOwnedInterest / int64(terms.RepayPeriod)
 			j := 0
 			var SecurityDurationDate []string
 			var PaidDurationPeriod int64
-			for j < Security.RepayPeriod {
-				NextPayInterestDate, _ := generateMaturity(Security.IssueDate, j+1, 0, 0)
+			for j < terms.RepayPeriod {
+				NextPayInterestDate, _ := generateMaturity(terms.IssueDate, j+1, 0, 0)
 				OwnedDurationDate = append(OwnedDurationDate, NextPayInterestDate)
 				SecurityDurationDate = append(SecurityDurationDate, NextPayInterestDate)
 				if Today >= NextPayInterestDate {
@@ -1452,7 +1482,11 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 				}
 				j = j + 1
 			}
-			Security.Owners[key].OwnedPaidDurationInterest = oldOwnedDurationInterest * PaidDurationPeriod
+			positions[key].OwnedPaidDurationInterest = oldOwnedDurationInterest * PaidDurationPeriod
+			err = putPositionStruct(APIstub, &positions[key])
+			if err != nil {
+				return errorResponse(codeStateError, "Failed to change state")
+			}
 			doflg = true
 		}
 	}
@@ -1479,7 +1513,7 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 				Security.SecurityTotals[key].TotalBalance = newOwnedBalance
 				Security.SecurityTotals[key].TotalAmount = newOwnedAmount
 				Security.SecurityTotals[key].TotalInterest = newOwnedInterest
-				Security.SecurityTotals[key].DurationInterest = Security.SecurityTotals[key].TotalInterest / int64(Security.RepayPeriod)
+				Security.SecurityTotals[key].DurationInterest = Security.SecurityTotals[key].TotalInterest / int64(terms.RepayPeriod)
 				Security.SecurityTotals[key].PaidDurationInterest = PaidDurationInterest
 				Security.SecurityTotals[key].UpdateTime = TimeNow2
 			}
@@ -1489,7 +1523,7 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 	SecurityAsBytes, _ = json.Marshal(Security)
 	err2 := APIstub.PutState(SecurityID, SecurityAsBytes)
 	if err2 != nil {
-		return shim.Error("Failed to change state")
+		return errorResponse(codeStateError, "Failed to change state")
 	}
 
 	return shim.Success(SecurityAsBytes)
@@ -1499,100 +1533,47 @@ func (s *SmartContract) changeBankSecurityTotals(APIstub shim.ChaincodeStubInter
 func (s *SmartContract) queryBankSecurityTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
-	SecurityAsBytes, _ := APIstub.GetState(args[0])
-	Security := Security{}
-	json.Unmarshal(SecurityAsBytes, &Security)
+	Security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	SecurityTotals, err := getEffectiveSecurityTotals(APIstub, Security)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query SecurityTotals state")
+	}
 
-	var doflg bool
-	doflg = false
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"SecurityID\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(Security.SecurityID)
-	buffer.WriteString("\"")
-	buffer.WriteString(",\"Records\":[")
-	bArrayMemberAlreadyWritten := false
-	for key, val := range Security.SecurityTotals {
+	BankTotals := BankSecurityTotals{SecurityID: Security.SecurityID, Records: []BankSecurityTotalsRecord{}}
+	for key, val := range SecurityTotals {
 		if val.BankID == args[1] {
-			// Add a comma before array members, suppress it for the first array member
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			buffer.WriteString("{\"SecurityTotalsKey\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(key + 1))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"BankID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.BankID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TotalBalance\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.TotalBalance, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TotalAmount\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.TotalAmount, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TotalInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.TotalInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"DurationInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.DurationInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"PaidDurationInterest\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(val.PaidDurationInterest, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"CreateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.CreateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"UpdateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(val.UpdateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
-			doflg = true
+			BankTotals.Records = append(BankTotals.Records, BankSecurityTotalsRecord{key + 1, val})
 		}
 	}
-	buffer.WriteString("]")
-	if doflg != true {
-		//return shim.Error("Failed to find SecurityTotals ")
-		buffer.WriteString(", \"Value\":")
-		buffer.WriteString("Failed to find SecurityTotals")
+	if len(BankTotals.Records) == 0 {
+		return errorResponse(codeNotFound, "Failed to find SecurityTotals")
 	}
-	buffer.WriteString("}")
-	buffer.WriteString("]")
-	fmt.Printf("%s", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return dataResponse(BankTotals)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["querySecurityTotals","A07106"]}' -C myc
 func (s *SmartContract) querySecurityTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	SecurityAsBytes, _ := APIstub.GetState(args[0])
 	Security := Security{}
 	json.Unmarshal(SecurityAsBytes, &Security)
-
-	SecurityTotalsAsBytes, err := json.Marshal(Security.SecurityTotals)
+	SecurityTotals, err := getEffectiveSecurityTotals(APIstub, &Security)
 	if err != nil {
-		return shim.Error("Failed to query SecurityTotals state")
+		return errorResponse(codeStateError, "Failed to query SecurityTotals state")
 	}
 
-	return shim.Success(SecurityTotalsAsBytes)
+	return dataResponse(SecurityTotals)
 }
 
 // The main function is only relevant in unit test mode. Only included here for completeness.
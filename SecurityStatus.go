@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+債券生命週期狀態，SecurityStatus 仍以整數保存(既有債券為 0，即 ISSUED)。
+1.ANNOUNCED 已公告，尚未發行，只能標售
+2.ISSUED    已發行，可轉帳、標售、增額發行及付息
+3.SUSPENDED 暫停交易，只能付息
+4.MATURED   已到期，只能付息及還本
+5.REDEEMED  已還本
+6.CANCELLED 已取消發行
+*/
+const securityIssued int = 0
+const securityAnnounced int = 1
+const securitySuspended int = 2
+const securityMatured int = 3
+const securityRedeemed int = 4
+const securityCancelled int = 5
+
+var securityStatusNames = map[int]string{
+	securityIssued:    "ISSUED",
+	securityAnnounced: "ANNOUNCED",
+	securitySuspended: "SUSPENDED",
+	securityMatured:   "MATURED",
+	securityRedeemed:  "REDEEMED",
+	securityCancelled: "CANCELLED",
+}
+
+//允許的狀態轉換，REDEEMED、CANCELLED 為終止狀態
+var securityStatusTransitions = map[int][]int{
+	securityAnnounced: {securityIssued, securityCancelled},
+	securityIssued:    {securitySuspended, securityMatured},
+	securitySuspended: {securityIssued, securityMatured},
+	securityMatured:   {securityRedeemed},
+}
+
+//各類交易允許的狀態
+const securityOpTransfer string = "Transfer"
+const securityOpAuction string = "Auction"
+const securityOpReopen string = "Reopen"
+const securityOpCoupon string = "Coupon"
+
+var securityStatusOperations = map[string][]int{
+	securityOpTransfer: {securityIssued},
+	securityOpAuction:  {securityAnnounced, securityIssued},
+	securityOpReopen:   {securityIssued},
+	securityOpCoupon:   {securityIssued, securitySuspended, securityMatured},
+}
+
+func getSecurityStatusName(SecurityStatus int) string {
+
+	if name, ok := securityStatusNames[SecurityStatus]; ok == true {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+func getSecurityStatusList() []string {
+
+	names := []string{}
+	for i := 0; i < len(securityStatusNames); i++ {
+		names = append(names, securityStatusNames[i])
+	}
+	return names
+}
+
+//名稱不分大小寫
+func getSecurityStatusFromName(name string) (int, bool) {
+
+	for key, val := range securityStatusNames {
+		if val == strings.ToUpper(name) {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
+func isStatusIn(SecurityStatus int, allowed []int) bool {
+
+	for _, val := range allowed {
+		if val == SecurityStatus {
+			return true
+		}
+	}
+	return false
+}
+
+//回傳空字串表示 operation 可在目前狀態執行
+func checkSecurityStatus(security *Security, operation string) string {
+
+	if isStatusIn(security.SecurityStatus, securityStatusOperations[operation]) != true {
+		return operation + " of " + security.SecurityID + " is not allowed when SecurityStatus is " + getSecurityStatusName(security.SecurityStatus)
+	}
+	return ""
+}
+
+//以公債代號檢核，找不到債券時回傳錯誤說明
+func checkSecurityIDStatus(stub shim.ChaincodeStubInterface, SecurityID string, operation string) string {
+
+	security, err := getSecurityStructFromID(stub, SecurityID)
+	if err != nil {
+		return err.Error()
+	}
+	return checkSecurityStatus(security, operation)
+}
+
+//MATURED 須已屆到期日(Today 為 YYYYMMDD)，REDEEMED 須各帳戶持有餘額皆已還本(為 0)
+func checkSecurityTransition(stub shim.ChaincodeStubInterface, security *Security, newStatus int, Today string) string {
+
+	if isStatusIn(newStatus, securityStatusTransitions[security.SecurityStatus]) != true {
+		return "SecurityStatus can not change from " + getSecurityStatusName(security.SecurityStatus) + " to " + getSecurityStatusName(newStatus)
+	}
+	if newStatus == securityMatured {
+		_, err := time.Parse(layout, security.MaturityDate)
+		if err == nil && Today < getTermsDate(security.MaturityDate) {
+			return "SecurityStatus can not change to MATURED before MaturityDate " + security.MaturityDate
+		}
+	}
+	if newStatus == securityRedeemed {
+		positions, err := getSecurityPositions(stub, security.SecurityID)
+		if err != nil {
+			return err.Error()
+		}
+		var holdings int64
+		for _, val := range positions {
+			holdings += val.Balance
+		}
+		if holdings != 0 {
+			return "SecurityStatus can not change to REDEEMED before the outstanding holdings " + strconv.FormatInt(holdings, 10) + " are repaid"
+		}
+	}
+	return ""
+}
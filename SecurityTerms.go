@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const SecurityTermsObjectType string = "SecurityTerms"
+
+type SecurityTerms struct {
+	ObjectType    string  `json:"docType"`       // default set to "SecurityTerms"
+	SecurityID    string  `json:"SecurityID"`    // 公債代號
+	EffectiveDate string  `json:"EffectiveDate"` // 生效日(YYYYMMDD)
+	IssueDate     string  `json:"IssueDate"`     // 發行日(YYYY/MM/DD)
+	MaturityDate  string  `json:"MaturityDate"`  // 到期日(YYYY/MM/DD)
+	InterestRate  float64 `json:"InterestRate"`  // 票面利率(%)
+	RepayPeriod   int     `json:"RepayPeriod"`   // 付息期數(年)
+	TxID          string  `json:"TxID"`          // 寫入此版本的 Fabric TxID
+	CreateTime    string  `json:"CreateTime"`
+}
+
+/*
+債券發行條件依生效日保存版本，changeSecurity 不再覆寫過去的條件。
+利息、付息及還本計算以計算日(或付息日)當時生效的版本為準。
+Key: SecurityTerms~SecurityID~EffectiveDate
+
+1.公債代號
+2.生效日
+3.發行日
+4.到期日
+5.票面利率
+6.付息期數
+7.Fabric 交易序號
+8.建立時間
+*/
+
+func newSecurityTerms(security *Security, EffectiveDate string) SecurityTerms {
+
+	var terms SecurityTerms
+	terms.ObjectType = SecurityTermsObjectType
+	terms.SecurityID = security.SecurityID
+	terms.EffectiveDate = EffectiveDate
+	terms.IssueDate = security.IssueDate
+	terms.MaturityDate = security.MaturityDate
+	terms.InterestRate = security.InterestRate
+	terms.RepayPeriod = security.RepayPeriod
+	return terms
+}
+
+//YYYY/MM/DD 轉為 YYYYMMDD
+func getTermsDate(aDate string) string {
+
+	return strings.Replace(aDate, "/", "", -1)
+}
+
+func isSameTerms(a SecurityTerms, b SecurityTerms) bool {
+
+	return a.IssueDate == b.IssueDate && a.MaturityDate == b.MaturityDate && a.InterestRate == b.InterestRate && a.RepayPeriod == b.RepayPeriod
+}
+
+//同一生效日只保留一個版本，後寫入者取代先寫入者(可由 GetHistoryForKey 追溯)
+func putSecurityTerms(stub shim.ChaincodeStubInterface, terms *SecurityTerms) error {
+
+	termsKey, err := stub.CreateCompositeKey(SecurityTermsObjectType, []string{terms.SecurityID, terms.EffectiveDate})
+	if err != nil {
+		return err
+	}
+	terms.ObjectType = SecurityTermsObjectType
+	terms.TxID = stub.GetTxID()
+	terms.CreateTime = time.Now().Format(timelayout2)
+	termsAsBytes, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(termsKey, termsAsBytes)
+}
+
+//與 aDate 生效的版本不同時才寫入新版本；尚未保存的起始版本一併寫入，過去的計算才能重現
+func addSecurityTerms(stub shim.ChaincodeStubInterface, timeline []SecurityTerms, terms SecurityTerms) ([]SecurityTerms, error) {
+
+	if isSameTerms(getTermsInForce(timeline, terms.EffectiveDate), terms) == true {
+		return timeline, nil
+	}
+	for key, val := range timeline {
+		if val.TxID == "" {
+			err := putSecurityTerms(stub, &timeline[key])
+			if err != nil {
+				return timeline, err
+			}
+		}
+	}
+	err := putSecurityTerms(stub, &terms)
+	if err != nil {
+		return timeline, err
+	}
+	var doflg bool
+	doflg = false
+	for key, val := range timeline {
+		if val.EffectiveDate == terms.EffectiveDate {
+			timeline[key] = terms
+			doflg = true
+			break
+		}
+	}
+	if doflg != true {
+		timeline = append(timeline, terms)
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].EffectiveDate < timeline[j].EffectiveDate
+	})
+	return timeline, nil
+}
+
+func delSecurityTerms(stub shim.ChaincodeStubInterface, SecurityID string) error {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SecurityTermsObjectType, []string{SecurityID})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//依生效日排序；尚無版本的債券(保存版本之前建立者)以 Security 目前的條件自發行日生效
+func getSecurityTermsTimeline(stub shim.ChaincodeStubInterface, security *Security) ([]SecurityTerms, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SecurityTermsObjectType, []string{security.SecurityID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	timeline := []SecurityTerms{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		terms := SecurityTerms{}
+		err = json.Unmarshal(queryResponse.Value, &terms)
+		if err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, terms)
+	}
+	if len(timeline) == 0 {
+		timeline = append(timeline, newSecurityTerms(security, getTermsDate(security.IssueDate)))
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].EffectiveDate < timeline[j].EffectiveDate
+	})
+	return timeline, nil
+}
+
+//aDate(YYYYMMDD 或 YYYY/MM/DD)當時生效的版本，早於第一個版本時以第一個版本計算
+func getTermsInForce(timeline []SecurityTerms, aDate string) SecurityTerms {
+
+	aDate = getTermsDate(aDate)
+	terms := timeline[0]
+	for _, val := range timeline {
+		if val.EffectiveDate > aDate {
+			break
+		}
+		terms = val
+	}
+	return terms
+}
+
+func applySecurityTerms(security *Security, terms SecurityTerms) {
+
+	security.IssueDate = terms.IssueDate
+	security.MaturityDate = terms.MaturityDate
+	security.InterestRate = terms.InterestRate
+	security.RepayPeriod = terms.RepayPeriod
+}
+
+//Balance 依 terms 計算之利息及每期利息
+func getTermsInterest(terms SecurityTerms, Balance int64) (int64, int64) {
+
+	OwnedInterest := int64(float64(Balance) * float64(terms.InterestRate/100))
+	if terms.RepayPeriod <= 0 {
+		return OwnedInterest, 0
+	}
+	return OwnedInterest, OwnedInterest / int64(terms.RepayPeriod)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["querySecurityTerms","A07103"]}' -C myc
+func (s *SmartContract) querySecurityTerms(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+
+	security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, "Failed to find SecurityID "+args[0])
+	}
+	timeline, err := getSecurityTermsTimeline(APIstub, security)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query SecurityTerms state")
+	}
+	return dataResponse(timeline)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const SecurityTrancheObjectType string = "SecurityTranche"
+
+type SecurityTranche struct {
+	ObjectType     string   `json:"docType"`        // default set to "SecurityTranche"
+	SecurityID     string   `json:"SecurityID"`     // 公債代號
+	TrancheNo      int      `json:"TrancheNo"`      // 發行次序(原始發行為 1)
+	IssueDate      string   `json:"IssueDate"`      // 本次發行日(YYYY/MM/DD)
+	Amount         int64    `json:"Amount"`         // 本次發行額
+	IssuePrice     float64  `json:"IssuePrice"`     // 發行價格(每百元面額)
+	AllottedAmount int64    `json:"AllottedAmount"` // 已標售配售額
+	AuctionIDs     []string `json:"AuctionIDs"`     // 本次發行之標售序號
+	TxID           string   `json:"TxID"`           // 寫入此次發行的 Fabric TxID
+	CreateTime     string   `json:"createTime"`
+	UpdateTime     string   `json:"updateTime"`
+}
+
+/*
+同一 SecurityID 之增額發行，每次發行一筆，TotalAmount 為各次發行額合計。
+createSecurity 為第 1 次發行，reopenSecurity 依序增加。
+Key: SecurityTranche~SecurityID~TrancheNo(三碼)
+
+1.公債代號
+2.發行次序
+3.本次發行日
+4.本次發行額
+5.發行價格
+6.已標售配售額
+7.本次發行之標售序號
+8.Fabric 交易序號
+9.建立時間
+10.更新時間
+*/
+
+func newSecurityTranche(SecurityID string, TrancheNo int, IssueDate string, Amount int64, IssuePrice float64) SecurityTranche {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+	var tranche SecurityTranche
+	tranche.ObjectType = SecurityTrancheObjectType
+	tranche.SecurityID = SecurityID
+	tranche.TrancheNo = TrancheNo
+	tranche.IssueDate = IssueDate
+	tranche.Amount = Amount
+	tranche.IssuePrice = IssuePrice
+	tranche.AuctionIDs = []string{}
+	tranche.CreateTime = TimeNow2
+	tranche.UpdateTime = TimeNow2
+	return tranche
+}
+
+func putSecurityTranche(stub shim.ChaincodeStubInterface, tranche *SecurityTranche) error {
+
+	trancheKey, err := stub.CreateCompositeKey(SecurityTrancheObjectType, []string{tranche.SecurityID, fmt.Sprintf("%03d", tranche.TrancheNo)})
+	if err != nil {
+		return err
+	}
+	tranche.ObjectType = SecurityTrancheObjectType
+	if tranche.TxID == "" {
+		tranche.TxID = stub.GetTxID()
+	}
+	tranche.UpdateTime = time.Now().Format(timelayout2)
+	trancheAsBytes, err := json.Marshal(tranche)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(trancheKey, trancheAsBytes)
+}
+
+func findSecurityTranche(tranches []SecurityTranche, TrancheNo int) (*SecurityTranche, error) {
+
+	for key, val := range tranches {
+		if val.TrancheNo == TrancheNo {
+			return &tranches[key], nil
+		}
+	}
+	return nil, fmt.Errorf("Tranche %d does not exist", TrancheNo)
+}
+
+//依發行次序排序；尚無發行紀錄的債券(保存紀錄之前建立者)以 TotalAmount 為第 1 次發行
+func getSecurityTranches(stub shim.ChaincodeStubInterface, security *Security) ([]SecurityTranche, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SecurityTrancheObjectType, []string{security.SecurityID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	tranches := []SecurityTranche{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		tranche := SecurityTranche{}
+		err = json.Unmarshal(queryResponse.Value, &tranche)
+		if err != nil {
+			return nil, err
+		}
+		tranches = append(tranches, tranche)
+	}
+	if len(tranches) == 0 {
+		tranches = append(tranches, newSecurityTranche(security.SecurityID, 1, security.IssueDate, security.TotalAmount, parPrice))
+		tranches[0].CreateTime = ""
+		tranches[0].UpdateTime = ""
+	}
+	sort.SliceStable(tranches, func(i, j int) bool {
+		return tranches[i].TrancheNo < tranches[j].TrancheNo
+	})
+	return tranches, nil
+}
+
+func delSecurityTranches(stub shim.ChaincodeStubInterface, SecurityID string) error {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SecurityTrancheObjectType, []string{SecurityID})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+增額發行：以同一 SecurityID 記錄一次新的發行，TotalAmount 及未發行餘額 Balance 同時增加，
+可再以 openAuction 指定 TrancheNo 標售。
+
+peer chaincode invoke -n mycc -c '{"Args":["reopenSecurity","A07103","2018/06/02","10000000000","100.25","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) reopenSecurity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 5)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "SecurityID must be a non-empty string")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[4]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	SecurityID := strings.ToUpper(args[0])
+	security, err := getSecurityStructFromID(APIstub, SecurityID)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	if errMsg := checkSecurityStatus(security, securityOpReopen); errMsg != "" {
+		return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+	}
+	IssueDate := args[1]
+	_, err = time.Parse(layout, IssueDate)
+	if err != nil {
+		return errorResponse(codeSecurityDateInvalid, "IssueDate must be a YYYY/MM/DD string: "+IssueDate)
+	}
+	if getTermsDate(IssueDate) < getTermsDate(security.IssueDate) || getTermsDate(IssueDate) >= getTermsDate(security.MaturityDate) {
+		return errorResponse(codeSecurityDateInvalid, "IssueDate must be between the original IssueDate and MaturityDate: "+IssueDate)
+	}
+	Amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || Amount <= 0 {
+		return errorResponse(codeArgumentFormat, "Amount must be a positive integer")
+	}
+	IssuePrice, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || IssuePrice <= 0 {
+		return errorResponse(codeArgumentFormat, "IssuePrice must be a positive number")
+	}
+
+	tranches, err := getSecurityTranches(APIstub, security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	//尚未保存的第 1 次發行一併寫入
+	if tranches[0].TxID == "" {
+		tranches[0].CreateTime = time.Now().Format(timelayout2)
+		err = putSecurityTranche(APIstub, &tranches[0])
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	tranche := newSecurityTranche(SecurityID, tranches[len(tranches)-1].TrancheNo+1, IssueDate, Amount, IssuePrice)
+	err = putSecurityTranche(APIstub, &tranche)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	security.TotalAmount += Amount
+	security.Balance += Amount
+	securityAsBytes, err := json.Marshal(security)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(SecurityID, securityAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(tranche)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["querySecurityTranches","A07103"]}' -C myc
+func (s *SmartContract) querySecurityTranches(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+
+	security, err := getSecurityStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, "Failed to find SecurityID "+args[0])
+	}
+	tranches, err := getSecurityTranches(APIstub, security)
+	if err != nil {
+		return errorResponse(codeStateError, "Failed to query SecurityTranche state")
+	}
+	return dataResponse(tranches)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const SettlementCycleObjectType string = "SettlementCycle"
+const settlementModeKey string = "settlementmode" //GROSS or NET
+const settlementGross string = "GROSS"
+const settlementNet string = "NET"
+
+type SettlementCycle struct {
+	ObjectType    string          `json:"docType"` // default set to "SettlementCycle"
+	TXKEY         string          `json:"TXKEY"`   //TXDATE(YYYYMMDD)
+	CycleID       string          `json:"CycleID"` // Fabric TxID
+	SettledTXIDs  []string        `json:"SettledTXIDs"`
+	ExcludedTXIDs []string        `json:"ExcludedTXIDs"`
+	ExcludedBanks []string        `json:"ExcludedBanks"`
+	HaltedTXIDs   []string        `json:"HaltedTXIDs"`
+	Nets          []SettlementNet `json:"Nets"`
+	CreateTime    string          `json:"createTime"`
+}
+
+/*
+1.營業日
+2.批次序號
+3.已交割交易序號
+4.未交割交易序號(額度不足，留待下一批次)
+5.被排除之清算銀行
+6.暫停中未交割交易序號(見 Halt.go)
+7.各清算銀行淨額
+8.建立時間
+*/
+
+type SettlementNet struct {
+	BankID     string `json:"BankID"`     // 清算銀行代號(三碼)
+	SecurityID string `json:"SecurityID"` // 公債代號
+	NetBalance int64  `json:"NetBalance"` // 淨收(付)券數
+	NetAmount  int64  `json:"NetAmount"`  // 淨收(付)款數
+}
+
+//批次中一組比對成功之 DVP 交易，以賣方(TXType = S)交易為準
+type settlementPair struct {
+	seller     Transaction
+	buyerTXID  string
+	sellerBank string
+	buyerBank  string
+}
+
+//單一 Position 於本批次的淨異動
+type settlementLeg struct {
+	AccountID  string
+	BankID     string
+	SecurityID string
+	Balance    int64
+	Amount     int64
+}
+
+func getSettlementMode(stub shim.ChaincodeStubInterface) string {
+
+	SettlementMode := settlementGross
+	ValueAsBytes, err := stub.GetState(settlementModeKey)
+	if err == nil && ValueAsBytes != nil {
+		SettlementMode = strings.ToUpper(string(ValueAsBytes))
+	}
+	return SettlementMode
+}
+
+//TXKEY 佇列中已比對、交割日已到之 DVP 交易
+func getSettlementPairs(queuedTX *QueuedTransaction, TXKEY string) []settlementPair {
+
+	var pairs []settlementPair
+	held := getHeldTXIDs(queuedTX)
+	for _, val := range queuedTX.Transactions {
+		if held[val.TXID] == true || held[val.MatchedTXID] == true {
+			continue
+		}
+		if getTXSettlementDate(val) > TXKEY {
+			continue
+		}
+		if val.TXStatus == "Matched" && val.TXType == "S" && val.MatchedTXID != "" {
+			pairs = append(pairs, newSettlementPair(val))
+		}
+	}
+	return pairs
+}
+
+func newSettlementPair(seller Transaction) settlementPair {
+
+	pair := settlementPair{}
+	pair.seller = seller
+	pair.buyerTXID = seller.MatchedTXID
+	pair.sellerBank = SubString(seller.TXFrom, 0, 3)
+	pair.buyerBank = SubString(seller.TXTo, 0, 3)
+	return pair
+}
+
+func getSettlementLegs(pairs []settlementPair, excluded map[string]bool) (map[string]*settlementLeg, []string) {
+
+	legs := make(map[string]*settlementLeg)
+	var keys []string
+	addLeg := func(AccountID string, SecurityID string, Balance int64, Amount int64) {
+		key := AccountID + "~" + SecurityID
+		leg, ok := legs[key]
+		if ok != true {
+			leg = &settlementLeg{AccountID, SubString(AccountID, 0, 3), SecurityID, 0, 0}
+			legs[key] = leg
+			keys = append(keys, key)
+		}
+		leg.Balance += Balance
+		leg.Amount += Amount
+	}
+	for _, pair := range pairs {
+		if excluded[pair.sellerBank] == true || excluded[pair.buyerBank] == true {
+			continue
+		}
+		//轉出方付券收款，轉入方收券付款
+		addLeg(pair.seller.TXFrom, pair.seller.SecurityID, -pair.seller.Payment, pair.seller.SecurityAmount)
+		addLeg(pair.seller.TXTo, pair.seller.SecurityID, pair.seller.Payment, -pair.seller.SecurityAmount)
+	}
+	sort.Strings(keys)
+	return legs, keys
+}
+
+/*
+回傳各清算銀行的不足額：
+券：任一帳戶交割後券數 < 0
+款：銀行淨付款 > 該行參與帳戶之款數合計
+*/
+func getSettlementShortfalls(stub shim.ChaincodeStubInterface, legs map[string]*settlementLeg, keys []string) map[string]int64 {
+
+	shortfalls := make(map[string]int64)
+	bankCash := make(map[string]int64)
+	bankNet := make(map[string]int64)
+	for _, key := range keys {
+		leg := legs[key]
+		var Balance int64
+		position, err := getPositionStruct(stub, leg.AccountID, leg.SecurityID)
+		if err == nil {
+			Balance = position.Balance - position.PledgedBalance
+			bankCash[leg.BankID] += position.SecurityAmount
+		}
+		if Balance+leg.Balance < 0 {
+			shortfalls[leg.BankID] += -(Balance + leg.Balance)
+		}
+		bankNet[leg.BankID] += leg.Amount
+	}
+	for BankID, Amount := range bankNet {
+		if bankCash[BankID]+Amount < 0 {
+			shortfalls[BankID] += -(bankCash[BankID] + Amount)
+		}
+	}
+	return shortfalls
+}
+
+func applySettlementLegs(stub shim.ChaincodeStubInterface, legs map[string]*settlementLeg, keys []string) error {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+	for _, key := range keys {
+		leg := legs[key]
+		position, err := getPositionStruct(stub, leg.AccountID, leg.SecurityID)
+		if err != nil {
+			position = newPosition(leg.AccountID, leg.BankID, leg.SecurityID)
+		}
+		position.Balance += leg.Balance
+		position.Position += leg.Balance
+		position.TotalPayment -= leg.Balance
+		position.SecurityAmount += leg.Amount
+		//其他 Pending / Matched 交易占用的券數不變
+		position.PendingBalance += leg.Balance
+		position.UpdateTime = TimeNow2
+		if position.Balance < 0 {
+			return fmt.Errorf("%s %s: Balance < 0", leg.AccountID, leg.SecurityID)
+		}
+		err = putPositionStruct(stub, position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getSettlementNets(legs map[string]*settlementLeg, keys []string) []SettlementNet {
+
+	nets := make(map[string]*SettlementNet)
+	var netKeys []string
+	for _, key := range keys {
+		leg := legs[key]
+		netKey := leg.BankID + "~" + leg.SecurityID
+		net, ok := nets[netKey]
+		if ok != true {
+			net = &SettlementNet{leg.BankID, leg.SecurityID, 0, 0}
+			nets[netKey] = net
+			netKeys = append(netKeys, netKey)
+		}
+		net.NetBalance += leg.Balance
+		net.NetAmount += leg.Amount
+	}
+	sort.Strings(netKeys)
+	var result []SettlementNet
+	for _, netKey := range netKeys {
+		result = append(result, *nets[netKey])
+	}
+	return result
+}
+
+/*
+淨額交割一組交易：依清算銀行計算淨款數及各公債淨券數，額度不足之銀行依不足額由大至小逐一排除並重新計算，
+其餘交易以淨額異動 Position。回傳批次紀錄及各交易序號的新狀態(Finished 或仍為 Matched)
+*/
+func settleSettlementPairs(stub shim.ChaincodeStubInterface, TXKEY string, pairs []settlementPair) (*SettlementCycle, map[string]string, error) {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+	//暫停中或債券狀態不可轉帳的交易不列入本批次，維持原狀態
+	var HaltedTXIDs []string
+	var unhalted []settlementPair
+	for _, pair := range pairs {
+		if checkHalt(stub, pair.seller.SecurityID, pair.seller.TXFrom, pair.seller.TXTo) != "" || checkSecurityIDStatus(stub, pair.seller.SecurityID, securityOpTransfer) != "" {
+			HaltedTXIDs = append(HaltedTXIDs, pair.seller.TXID, pair.buyerTXID)
+			continue
+		}
+		unhalted = append(unhalted, pair)
+	}
+	pairs = unhalted
+	excluded := make(map[string]bool)
+	var legs map[string]*settlementLeg
+	var keys []string
+	for {
+		legs, keys = getSettlementLegs(pairs, excluded)
+		shortfalls := getSettlementShortfalls(stub, legs, keys)
+		if len(shortfalls) == 0 {
+			break
+		}
+		var ExcludedBank string
+		var MaxShortfall int64
+		for BankID, Shortfall := range shortfalls {
+			if Shortfall > MaxShortfall || (Shortfall == MaxShortfall && BankID < ExcludedBank) {
+				ExcludedBank = BankID
+				MaxShortfall = Shortfall
+			}
+		}
+		excluded[ExcludedBank] = true
+	}
+
+	err := applySettlementLegs(stub, legs, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cycle := &SettlementCycle{}
+	cycle.ObjectType = SettlementCycleObjectType
+	cycle.TXKEY = TXKEY
+	cycle.CycleID = stub.GetTxID()
+	cycle.Nets = getSettlementNets(legs, keys)
+	cycle.HaltedTXIDs = HaltedTXIDs
+	cycle.CreateTime = TimeNow2
+	for BankID := range excluded {
+		cycle.ExcludedBanks = append(cycle.ExcludedBanks, BankID)
+	}
+	sort.Strings(cycle.ExcludedBanks)
+
+	status := make(map[string]string)
+	for _, pair := range pairs {
+		seller := pair.seller
+		if excluded[pair.sellerBank] == true || excluded[pair.buyerBank] == true {
+			status[seller.TXID] = "Matched"
+			status[pair.buyerTXID] = "Matched"
+			cycle.ExcludedTXIDs = append(cycle.ExcludedTXIDs, seller.TXID, pair.buyerTXID)
+			continue
+		}
+		//OwnedAmount 及 SecurityTotals / BankTotals delta 與逐筆交割相同
+		_, _, err = updateSecurityAmount(stub, seller.SecurityID, seller.Payment, seller.SecurityAmount, seller.TXFrom, seller.TXTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seller.BankFrom != seller.BankTo {
+			err = updateBankTotals(stub, seller.TXFrom, seller.SecurityID, seller.TXFrom, seller.Payment, seller.SecurityAmount, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			err = updateBankTotals(stub, seller.TXTo, seller.SecurityID, seller.TXTo, seller.Payment, seller.SecurityAmount, false)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		err = updateTransactionStatus(stub, seller.TXID, "Finished", pair.buyerTXID)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = updateTransactionStatus(stub, pair.buyerTXID, "Finished", seller.TXID)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = delSettlementDue(stub, seller)
+		if err != nil {
+			return nil, nil, err
+		}
+		status[seller.TXID] = "Finished"
+		status[pair.buyerTXID] = "Finished"
+		cycle.SettledTXIDs = append(cycle.SettledTXIDs, seller.TXID, pair.buyerTXID)
+	}
+
+	return cycle, status, nil
+}
+
+func putSettlementCycle(stub shim.ChaincodeStubInterface, cycle *SettlementCycle) error {
+
+	cycleKey, err := stub.CreateCompositeKey(SettlementCycleObjectType, []string{cycle.TXKEY, cycle.CycleID})
+	if err != nil {
+		return err
+	}
+	cycleAsBytes, err := json.Marshal(cycle)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(cycleKey, cycleAsBytes)
+}
+
+//peer chaincode invoke -n mycc -c '{"Args":["setSettlementMode","NET","BANKCBC"]}' -C myc
+func (s *SmartContract) setSettlementMode(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	SettlementMode := strings.ToUpper(args[0])
+	if SettlementMode != settlementGross && SettlementMode != settlementNet {
+		return errorResponse(codeArgumentFormat, "SettlementMode must be GROSS or NET")
+	}
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	err = APIstub.PutState(settlementModeKey, []byte(SettlementMode))
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+peer chaincode invoke -n mycc -c '{"Args":["runSettlementCycle","20180415","BANKCBC"]}' -C myc
+
+settlementmode = NET 時，securityTransfer 比對成功之 DVP 交易維持 Matched，由本批次一次交割；
+交割日已到之預約交易一併交割。整批於同一筆 Fabric 交易內完成。
+TXKEY 須為目前營業日(尚未 openBusinessDay 時為今日)，且為 Open 或 CutOff。
+*/
+func (s *SmartContract) runSettlementCycle(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	TimeNow2 := time.Now().Format(timelayout2)
+
+	err := checkArgArrayLength(args, 2)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "TXKEY must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	TXKEY := args[0]
+	HTXKEY := "H" + TXKEY
+	if errMsg := verifyAdminIdentity(APIstub, args[1]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+	if SettlementMode := getSettlementMode(APIstub); SettlementMode != settlementNet {
+		return errorResponse(codeInvalidStatus, "runSettlementCycle requires settlementmode NET, current mode is "+SettlementMode)
+	}
+	CurrentDate, err := getCurrentBusinessDate(APIstub)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if CurrentDate == "" {
+		CurrentDate = SubString(getTxTime(APIstub).Format(timelayout), 0, 8)
+	}
+	if TXKEY != CurrentDate {
+		return errorResponse(codeArgumentFormat, "TXKEY must be the current business day "+CurrentDate)
+	}
+	day, err := getBusinessDayStruct(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	if day != nil && day.DayStatus != dayOpen && day.DayStatus != dayCutOff {
+		return errorResponse(codeInvalidStatus, "Business day "+TXKEY+" is "+day.DayStatus)
+	}
+
+	queuedTX, err := getQueueStructFromID(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	historyTX, err := getHistoryTransactionStructFromID(APIstub, HTXKEY)
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	pairs := getSettlementPairs(queuedTX, TXKEY)
+	duePairs, err := getDueSettlementPairs(APIstub, TXKEY)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	cycle, status, err := settleSettlementPairs(APIstub, TXKEY, append(pairs, duePairs...))
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	for key, val := range queuedTX.TXIDs {
+		TXStatus, ok := status[val]
+		if ok != true {
+			continue
+		}
+		TXMemo := ""
+		if TXStatus == "Matched" {
+			TXMemo = codeNetSettlementShortfall
+		}
+		queuedTX.Transactions[key].TXStatus = TXStatus
+		queuedTX.Transactions[key].TXMemo = TXMemo
+		queuedTX.Transactions[key].IsFrozen = true
+		queuedTX.Transactions[key].UpdateTime = TimeNow2
+	}
+	for key, val := range historyTX.TXIDs {
+		TXStatus, ok := status[val]
+		if ok != true {
+			continue
+		}
+		TXMemo := ""
+		if TXStatus == "Matched" {
+			TXMemo = codeNetSettlementShortfall
+		}
+		historyTX.Transactions[key].TXStatus = TXStatus
+		historyTX.Transactions[key].TXMemo = TXMemo
+		historyTX.Transactions[key].IsFrozen = true
+		historyTX.Transactions[key].UpdateTime = TimeNow2
+		historyTX.TXStatus[key] = TXStatus
+	}
+	queuedAsBytes, err := json.Marshal(queuedTX)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(TXKEY, queuedAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	historyAsBytes, err := json.Marshal(historyTX)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	err = APIstub.PutState(HTXKEY, historyAsBytes)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	//之前營業日比對、今日到期之交易不在 TXKEY 佇列中，逐筆更新
+	err = putDuePairStates(APIstub, duePairs, status)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+
+	err = putSettlementCycle(APIstub, cycle)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	cycleAsBytes, err := json.Marshal(cycle)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return shim.Success(cycleAsBytes)
+}
+
+//peer chaincode query -n mycc -c '{"Args":["querySettlementCycles","20180415"]}' -C myc
+
+func (s *SmartContract) querySettlementCycles(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(SettlementCycleObjectType, []string{args[0]})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	cycles := []SettlementCycle{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		cycle := SettlementCycle{}
+		err = json.Unmarshal(queryResponse.Value, &cycle)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		cycles = append(cycles, cycle)
+	}
+	return dataResponse(cycles)
+}
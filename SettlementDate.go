@@ -0,0 +1,183 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//預約交割索引：SettlementDue~SettlementDate~TXID(賣方交易)
+const SettlementDueIndex string = "SettlementDue"
+
+/*
+交易日(TradeDate)為登錄之營業日，交割日(SettlementDate)預設同交易日。
+交割日在後之交易於登錄時即比對，比對成功後維持 Matched 並寫入預約交割索引，
+至交割日由 openBusinessDay 或 runSettlementCycle 交割，日終不取消。
+*/
+
+//舊資料沒有交割日，以交易日或交易序號中的日期為準
+func getTXSettlementDate(transaction Transaction) string {
+
+	if transaction.SettlementDate != "" {
+		return transaction.SettlementDate
+	}
+	if transaction.TradeDate != "" {
+		return transaction.TradeDate
+	}
+	return SubString(transaction.TXID, 18, 8)
+}
+
+func putSettlementDue(stub shim.ChaincodeStubInterface, SettlementDate string, TXID string) error {
+
+	dueKey, err := stub.CreateCompositeKey(SettlementDueIndex, []string{SettlementDate, TXID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(dueKey, []byte{0x00})
+}
+
+func delSettlementDue(stub shim.ChaincodeStubInterface, transaction Transaction) error {
+
+	dueKey, err := stub.CreateCompositeKey(SettlementDueIndex, []string{getTXSettlementDate(transaction), transaction.TXID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(dueKey)
+}
+
+//回傳交割日 <= TXKEY 的預約交割索引(賣方交易序號)
+func getDueTXIDs(stub shim.ChaincodeStubInterface, TXKEY string, keys []string) ([]string, error) {
+
+	var TXIDs []string
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SettlementDueIndex, keys)
+	if err != nil {
+		return TXIDs, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return TXIDs, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return TXIDs, err
+		}
+		if compositeKeyParts[0] <= TXKEY {
+			TXIDs = append(TXIDs, compositeKeyParts[1])
+		}
+	}
+	return TXIDs, nil
+}
+
+//之前營業日比對、交割日已到且未暫停交割的交易
+func getDueSettlementPairs(stub shim.ChaincodeStubInterface, TXKEY string) ([]settlementPair, error) {
+
+	var pairs []settlementPair
+	TXIDs, err := getDueTXIDs(stub, TXKEY, []string{})
+	if err != nil {
+		return pairs, err
+	}
+	for _, TXID := range TXIDs {
+		seller, err := getTransactionStructFromID(stub, TXID)
+		if err != nil {
+			return pairs, err
+		}
+		if seller.TXStatus != "Matched" || seller.IsHeld == true {
+			continue
+		}
+		buyer, err := getTransactionStructFromID(stub, seller.MatchedTXID)
+		if err != nil {
+			return pairs, err
+		}
+		if buyer.IsHeld == true {
+			continue
+		}
+		pairs = append(pairs, newSettlementPair(*seller))
+	}
+	return pairs, nil
+}
+
+//預約交割的交易不在當日佇列中，交割後逐筆更新其交易日之佇列及歷史資料
+func putDuePairStates(stub shim.ChaincodeStubInterface, pairs []settlementPair, status map[string]string) error {
+
+	for _, pair := range pairs {
+		if status[pair.seller.TXID] != "Finished" {
+			continue
+		}
+		for _, TXID := range []string{pair.seller.TXID, pair.buyerTXID} {
+			transaction, err := getTransactionStructFromID(stub, TXID)
+			if err != nil {
+				return err
+			}
+			transaction.TXMemo = ""
+			err = putInstructionState(stub, transaction)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//openBusinessDay 完成時交割到期的預約交易
+func settleDueTransactions(stub shim.ChaincodeStubInterface, TXKEY string) error {
+
+	pairs, err := getDueSettlementPairs(stub, TXKEY)
+	if err != nil || len(pairs) == 0 {
+		return err
+	}
+	cycle, status, err := settleSettlementPairs(stub, TXKEY, pairs)
+	if err != nil {
+		return err
+	}
+	err = putDuePairStates(stub, pairs, status)
+	if err != nil {
+		return err
+	}
+	return putSettlementCycle(stub, cycle)
+}
+
+/*
+peer chaincode query -n mycc -c '{"Args":["queryDueTransactions","20180417"]}' -C myc
+
+交割日為指定日期、尚未完成的交易(含之前營業日比對之預約交易)
+*/
+func (s *SmartContract) queryDueTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+	SettlementDate := args[0]
+
+	transactions := []Transaction{}
+	found := make(map[string]bool)
+	TXIDs, err := getDueTXIDs(APIstub, SettlementDate, []string{SettlementDate})
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	for _, TXID := range TXIDs {
+		seller, err := getTransactionStructFromID(APIstub, TXID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		transactions = append(transactions, *seller)
+		found[seller.TXID] = true
+		buyer, err := getTransactionStructFromID(APIstub, seller.MatchedTXID)
+		if err == nil {
+			transactions = append(transactions, *buyer)
+			found[buyer.TXID] = true
+		}
+	}
+
+	queuedTX, err := getQueueStructFromID(APIstub, SettlementDate)
+	if err == nil {
+		for _, val := range queuedTX.Transactions {
+			if found[val.TXID] != true && isQueuedStatus(val.TXStatus) == true && getTXSettlementDate(val) == SettlementDate {
+				transactions = append(transactions, val)
+			}
+		}
+	}
+
+	return dataResponse(transactions)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const TotalDeltaObjectType string = "TotalDelta"
+const BankTotalDeltaIndex string = "BankTotalDelta"
+const SecurityTotalDeltaIndex string = "SecurityTotalDelta"
+const BankAccountIndex string = "BankAccount"
+
+type TotalDelta struct {
+	ObjectType string `json:"docType"`    // default set to "TotalDelta"
+	Scope      string `json:"Scope"`      // BANK or SECURITY
+	BankID     string `json:"BankID"`     // 清算銀行代號(三碼)
+	SecurityID string `json:"SecurityID"` // 公債代號
+	TXID       string `json:"TXID"`       // Fabric TxID
+	Balance    int64  `json:"Balance"`    // 券數異動
+	Amount     int64  `json:"Amount"`     // 款數異動
+	CreateTime string `json:"CreateTime"`
+}
+
+/*
+每筆交易對總計數的異動各寫一筆 delta key，不改寫 Bank / Security 文件，
+查詢時以 checkpoint(BankTotals / SecurityTotals) 加總 delta，
+compactTotals 由 CBC 定期將 delta 併入 checkpoint。
+Key: BankTotalDelta~BankID~SecurityID~TxID~Seq
+     SecurityTotalDelta~SecurityID~BankID~TxID~Seq
+Index: BankAccount~BankID~AccountID (取代 Bank.BankAccounts)
+
+1.BANK 或 SECURITY
+2.清算銀行代號
+3.公債代號
+4.交易序號
+5.券數異動
+6.款數異動
+7.建立時間
+*/
+
+type CompactResult struct {
+	Scope     string `json:"Scope"`     // BANK or SECURITY
+	ID        string `json:"ID"`        // 清算銀行代號或公債代號
+	Compacted int    `json:"Compacted"` // 併入 checkpoint 的 delta 筆數
+}
+
+//同一筆交易寫入多筆 delta 時的序號
+func nextDeltaSeq(stub shim.ChaincodeStubInterface) string {
+
+	var seq int
+	if t, ok := stub.(*txStub); ok == true {
+		t.deltaSeq = t.deltaSeq + 1
+		seq = t.deltaSeq
+	}
+	return fmt.Sprintf("%06d", seq)
+}
+
+func putTotalDelta(stub shim.ChaincodeStubInterface, Scope string, BankID string, SecurityID string, Balance int64, Amount int64) error {
+
+	var attributes []string
+	var objectType string
+	TXID := stub.GetTxID()
+	Seq := nextDeltaSeq(stub)
+	if Scope == reconcileScopeBank {
+		objectType = BankTotalDeltaIndex
+		attributes = []string{BankID, SecurityID, TXID, Seq}
+	} else {
+		objectType = SecurityTotalDeltaIndex
+		attributes = []string{SecurityID, BankID, TXID, Seq}
+	}
+	deltaKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return err
+	}
+
+	var delta TotalDelta
+	delta.ObjectType = TotalDeltaObjectType
+	delta.Scope = Scope
+	delta.BankID = BankID
+	delta.SecurityID = SecurityID
+	delta.TXID = TXID
+	delta.Balance = Balance
+	delta.Amount = Amount
+	delta.CreateTime = time.Now().Format(timelayout2)
+	deltaAsBytes, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(deltaKey, deltaAsBytes)
+}
+
+//回傳 delta 及其 key，keys 為 partial composite key 的屬性
+func getTotalDeltas(stub shim.ChaincodeStubInterface, objectType string, keys []string) ([]TotalDelta, []string, error) {
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	deltas := []TotalDelta{}
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		delta := TotalDelta{}
+		err = json.Unmarshal(queryResponse.Value, &delta)
+		if err != nil {
+			return nil, nil, err
+		}
+		deltas = append(deltas, delta)
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+	return deltas, deltaKeys, nil
+}
+
+func putBankAccountIndex(stub shim.ChaincodeStubInterface, BankID string, AccountID string) error {
+
+	indexKey, err := stub.CreateCompositeKey(BankAccountIndex, []string{SubString(BankID, 0, 3), AccountID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func getBankAccountIDs(stub shim.ChaincodeStubInterface, bank *Bank) ([]string, error) {
+
+	var AccountIDs []string
+	seen := make(map[string]bool)
+	for _, val := range bank.BankAccounts {
+		if seen[val] != true {
+			AccountIDs = append(AccountIDs, val)
+			seen[val] = true
+		}
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(BankAccountIndex, []string{getBankCode(bank.BankID)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[compositeKeyParts[1]] != true {
+			AccountIDs = append(AccountIDs, compositeKeyParts[1])
+			seen[compositeKeyParts[1]] = true
+		}
+	}
+	return AccountIDs, nil
+}
+
+//checkpoint + delta
+func getEffectiveBankTotals(stub shim.ChaincodeStubInterface, bank *Bank) ([]BankTotal, error) {
+
+	totals := append([]BankTotal(nil), bank.BankTotals...)
+	deltas, _, err := getTotalDeltas(stub, BankTotalDeltaIndex, []string{getBankCode(bank.BankID)})
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		totals = applyBankTotalDelta(totals, delta)
+	}
+	return totals, nil
+}
+
+func applyBankTotalDelta(totals []BankTotal, delta TotalDelta) []BankTotal {
+
+	for key, val := range totals {
+		if val.SecurityID == delta.SecurityID {
+			totals[key].TotalBalance += delta.Balance
+			totals[key].TotalAmount += delta.Amount
+			totals[key].UpdateTime = delta.CreateTime
+			return totals
+		}
+	}
+	var bankTotal BankTotal
+	bankTotal.SecurityID = delta.SecurityID
+	bankTotal.TotalBalance = delta.Balance
+	bankTotal.TotalAmount = delta.Amount
+	bankTotal.CreateTime = delta.CreateTime
+	bankTotal.UpdateTime = delta.CreateTime
+	return append(totals, bankTotal)
+}
+
+//checkpoint + delta
+func getEffectiveSecurityTotals(stub shim.ChaincodeStubInterface, security *Security) ([]SecurityTotal, error) {
+
+	totals := append([]SecurityTotal(nil), security.SecurityTotals...)
+	deltas, _, err := getTotalDeltas(stub, SecurityTotalDeltaIndex, []string{security.SecurityID})
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		totals = applySecurityTotalDelta(totals, delta)
+	}
+	return totals, nil
+}
+
+func applySecurityTotalDelta(totals []SecurityTotal, delta TotalDelta) []SecurityTotal {
+
+	for key, val := range totals {
+		if val.BankID == delta.BankID {
+			totals[key].TotalBalance += delta.Balance
+			totals[key].TotalAmount += delta.Amount
+			totals[key].UpdateTime = delta.CreateTime
+			return totals
+		}
+	}
+	var securityTotal SecurityTotal
+	securityTotal.BankID = delta.BankID
+	securityTotal.TotalBalance = delta.Balance
+	securityTotal.TotalAmount = delta.Amount
+	securityTotal.CreateTime = delta.CreateTime
+	securityTotal.UpdateTime = delta.CreateTime
+	return append(totals, securityTotal)
+}
+
+//將 delta 併入 Bank.BankTotals 並刪除 delta，SecurityID 為空時處理全部公債
+func foldBankTotalDeltas(stub shim.ChaincodeStubInterface, bank *Bank, SecurityID string) (int, error) {
+
+	keys := []string{getBankCode(bank.BankID)}
+	if SecurityID != "" {
+		keys = append(keys, SecurityID)
+	}
+	deltas, deltaKeys, err := getTotalDeltas(stub, BankTotalDeltaIndex, keys)
+	if err != nil {
+		return 0, err
+	}
+	for key, delta := range deltas {
+		bank.BankTotals = applyBankTotalDelta(bank.BankTotals, delta)
+		err = stub.DelState(deltaKeys[key])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(deltas), nil
+}
+
+//將 delta 併入 Security.SecurityTotals 並刪除 delta，BankCode 為空時處理全部銀行
+func foldSecurityTotalDeltas(stub shim.ChaincodeStubInterface, security *Security, BankCode string) (int, error) {
+
+	keys := []string{security.SecurityID}
+	if BankCode != "" {
+		keys = append(keys, BankCode)
+	}
+	deltas, deltaKeys, err := getTotalDeltas(stub, SecurityTotalDeltaIndex, keys)
+	if err != nil {
+		return 0, err
+	}
+	for key, delta := range deltas {
+		security.SecurityTotals = applySecurityTotalDelta(security.SecurityTotals, delta)
+		err = stub.DelState(deltaKeys[key])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(deltas), nil
+}
+
+/*
+Folds the outstanding total deltas of a bank or a security into its
+checkpoint (Bank.BankTotals or Security.SecurityTotals) and deletes them.
+
+peer chaincode invoke -n mycc -c '{"Args":["compactTotals","BANK","BANK002","BANKCBC"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["compactTotals","SECURITY","A07103","BANKCBC"]}' -C myc
+*/
+func (s *SmartContract) compactTotals(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	err := checkArgArrayLength(args, 3)
+	if err != nil {
+		return errorResponse(codeArgumentCount, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Scope must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "ID must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
+	}
+	Scope := strings.ToUpper(args[0])
+	ID := strings.ToUpper(args[1])
+	if errMsg := verifyAdminIdentity(APIstub, args[2]); errMsg != "" {
+		return errorResponse(codeUnauthorized, errMsg)
+	}
+
+	var count int
+	if Scope == reconcileScopeBank {
+		BankID := "BANK" + getBankCode(ID)
+		bank, err := getBankStructFromID(APIstub, BankID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		count, err = foldBankTotalDeltas(APIstub, bank, "")
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		bankAsBytes, err := json.Marshal(bank)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		err = APIstub.PutState(BankID, bankAsBytes)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	} else if Scope == reconcileScopeSecurity {
+		security, err := getSecurityStructFromID(APIstub, ID)
+		if err != nil {
+			return errorResponse(codeNotFound, err.Error())
+		}
+		count, err = foldSecurityTotalDeltas(APIstub, security, "")
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		securityAsBytes, err := json.Marshal(security)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+		err = APIstub.PutState(ID, securityAsBytes)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	} else {
+		return errorResponse(codeArgumentFormat, "Scope must be SECURITY or BANK")
+	}
+
+	return dataResponse(CompactResult{Scope, ID, count})
+}
+
+//peer chaincode query -n mycc -c '{"Args":["queryBankAccounts","BANK002"]}' -C myc
+func (s *SmartContract) queryBankAccounts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
+	}
+
+	bank, err := getBankStructFromID(APIstub, args[0])
+	if err != nil {
+		return errorResponse(codeNotFound, err.Error())
+	}
+	AccountIDs, err := getBankAccountIDs(APIstub, bank)
+	if err != nil {
+		return errorResponse(codeStateError, err.Error())
+	}
+	return dataResponse(AccountIDs)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
@@ -55,8 +54,15 @@ type Transaction struct {
 	TXFromAmount         int64  `json:"TXFromAmount"`         //未交易前的帳戶款數
 	TXFromPendingBalance int64  `json:"TXFromPendingBalance"` //未交易前的帳戶尚未比對券數
 	MatchedTXID          string `json:"MatchedTXID"`          //比對序號
-	TXMemo               string `json:"TXMemo"`               //交易說明
-	TXErrMsg             string `json:"TXErrMsg"`             //交易錯誤說明
+	TXMemo               string `json:"TXMemo"`               //交易說明代碼
+	TXErrMsg             string `json:"TXErrMsg"`             //交易錯誤代碼
+	TXPriority           int    `json:"TXPriority"`           //交割優先順序(1:央行 2:跨行 3:客戶)
+	IsHeld               bool   `json:"isHeld"`               //是否暫停交割
+	HoldReason           string `json:"HoldReason"`           //暫停交割原因代碼
+	TradeDate            string `json:"TradeDate"`            //交易日(YYYYMMDD)
+	SettlementDate       string `json:"SettlementDate"`       //交割日(YYYYMMDD)
+	TradeRef             string `json:"TradeRef"`             //雙方共同交易參考編號(選填)
+	TXErrDetail          string `json:"TXErrDetail"`          //錯誤說明(TXErrMsg 為錯誤代碼)
 }
 
 /*
@@ -82,8 +88,15 @@ type Transaction struct {
 20.未交易前的帳戶款數
 21.未交易前的帳戶尚未比對可動用餘額
 22.比對交易序號
-23.交易說明
-24.錯誤訊息
+23.交易說明代碼
+24.錯誤代碼
+25.交割優先順序
+26.是否暫停交割
+27.暫停交割原因代碼
+28.交易日
+29.交割日
+30.共同交易參考編號
+31.錯誤說明
 */
 
 /*
@@ -145,13 +158,13 @@ func (s *SmartContract) submitApproveTransaction(
 
 	err := checkArgArrayLength(args, 2)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 	if len(args[0]) <= 0 {
-		return shim.Error("TXID must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "TXID must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("Admin must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Admin must be a non-empty string")
 	}
 	//BK004S00400000000120180610041355
 	TXID := strings.ToUpper(args[0])
@@ -171,7 +184,7 @@ func (s *SmartContract) submitApproveTransaction(
 	fmt.Printf("1.ApproveFlag=%s\n", ApproveFlag)
 	transaction, err := getTransactionStructFromID(stub, TXID)
 	if err != nil {
-		return shim.Error("TXID transacton does not found.")
+		return errorResponse(codeNotFound, "TXID transacton does not found.")
 	}
 
 	MatchedTXID := transaction.MatchedTXID
@@ -205,7 +218,7 @@ func (s *SmartContract) submitApproveTransaction(
 		isApproved = false
 		NewStatus = "Cancelled2"
 	} else if ApproveFlag == approved5 {
-		_, _, securityamount, _, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
+		_, _, securityamount, _, _, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
 		fmt.Printf("0-1.Account securityamount=%s\n", securityamount)
 		fmt.Printf("0-2.Transaction SecurityAmount=%s\n", SecurityAmount)
 		fmt.Printf("0-3.Approved errMsg=%s\n", errMsg)
@@ -221,6 +234,16 @@ func (s *SmartContract) submitApproveTransaction(
 		}
 	}
 
+	//核准交割於暫停期間或債券狀態不可轉帳時不執行，取消不受影響
+	if isApproved == true {
+		if errMsg := checkHalt(stub, SecurityID, TXFrom, TXTo); errMsg != "" {
+			return errorResponse(codeTradingHalted, errMsg)
+		}
+		if errMsg := checkSecurityIDStatus(stub, SecurityID, securityOpTransfer); errMsg != "" {
+			return errorResponse(codeSecurityStatusNotAllowed, errMsg)
+		}
+	}
+
 	fmt.Printf("1.Approved TXID=%s\n", TXID)
 	fmt.Printf("2.Approved MatchedTXID=%s\n", MatchedTXID)
 	fmt.Printf("3.Approved TXKEY=%s\n", TXKEY)
@@ -229,21 +252,21 @@ func (s *SmartContract) submitApproveTransaction(
 	if isApproved != true {
 		err := updateQueuedTransactionApproveStatus(stub, TXKEY, TXID, MatchedTXID, NewStatus)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		err = updateHistoryTransactionApproveStatus(stub, HTXKEY, TXID, MatchedTXID, NewStatus)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		err = updateTransactionStatus(stub, TXID, NewStatus, MatchedTXID)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 		err = updateTransactionStatus(stub, MatchedTXID, NewStatus, TXID)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		if TXType == "S" {
@@ -252,15 +275,15 @@ func (s *SmartContract) submitApproveTransaction(
 			if BankFrom != BankTo {
 				err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, false)
 				if err != nil {
-					return shim.Error(err.Error())
+					return errorResponse(codeStateError, err.Error())
 				}
 				err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, true)
 				if err != nil {
-					return shim.Error(err.Error())
+					return errorResponse(codeStateError, err.Error())
 				}
 			}
 			if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
-				return shim.Error("senderBalance,receiverBalance,senderPendingBalance,receiverPendingBalance <0")
+				return errorResponse(codeNegativeBalance, "senderBalance,receiverBalance,senderPendingBalance,receiverPendingBalance <0")
 			}
 
 		}
@@ -270,15 +293,15 @@ func (s *SmartContract) submitApproveTransaction(
 			if BankFrom != BankTo {
 				err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, true)
 				if err != nil {
-					return shim.Error(err.Error())
+					return errorResponse(codeStateError, err.Error())
 				}
 				err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, false)
 				if err != nil {
-					return shim.Error(err.Error())
+					return errorResponse(codeStateError, err.Error())
 				}
 			}
 			if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
-				return shim.Error("senderBalance,receiverBalance,senderPendingBalance,receiverPendingBalance <0")
+				return errorResponse(codeNegativeBalance, "senderBalance,receiverBalance,senderPendingBalance,receiverPendingBalance <0")
 			}
 
 		}
@@ -290,22 +313,22 @@ func (s *SmartContract) submitApproveTransaction(
 		fmt.Printf("8.Approved TXKEY=%s\n", HTXKEY)
 		err := updateQueuedTransactionApproveStatus(stub, TXKEY, TXID, MatchedTXID, NewStatus)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		err = updateHistoryTransactionApproveStatus(stub, HTXKEY, TXID, MatchedTXID, NewStatus)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		err = updateTransactionStatus(stub, TXID, NewStatus, MatchedTXID)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 		err = updateTransactionStatus(stub, MatchedTXID, NewStatus, TXID)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 
 	}
@@ -325,14 +348,14 @@ func (s *SmartContract) submitEndDayTransaction(
 
 	err := checkArgArrayLength(args, 2)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeArgumentCount, err.Error())
 	}
 
 	if len(args[0]) <= 0 {
-		return shim.Error("TXID  must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "TXID  must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("Admin  must be a non-empty string")
+		return errorResponse(codeArgumentEmpty, "Admin  must be a non-empty string")
 	}
 
 	TXID := strings.ToUpper(args[0])
@@ -348,16 +371,16 @@ func (s *SmartContract) submitEndDayTransaction(
 
 	MatchedTXID, err2 := updateEndDayTransactionStatus(stub, TXID)
 	if err2 != nil {
-		return shim.Error(err2.Error())
+		return errorResponse(codeStateError, err2.Error())
 	}
 
 	err = updateEndDayQueuedTransactionStatus(stub, TXKEY, TXID, MatchedTXID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = updateEndDayHistoryTransactionStatus(stub, HTXKEY, TXID, MatchedTXID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(nil)
@@ -374,20 +397,27 @@ peer chaincode invoke -n mycc -c '{"Args":["securityTransfer", "S","004000000002
 peer chaincode invoke -n mycc -c '{"Args":["securityTransfer", "S","002000000001" , "002000000002" , "A07103" , "102000","100000","true"]}' -C myc
 peer chaincode invoke -n mycc -c '{"Args":["securityTransfer", "B","002000000002" , "002000000001" , "A07103" , "102000","100000","true"]}' -C myc
 
+//第 8 個參數為交割日(YYYYMMDD)，第 9 個參數為共同交易參考編號
+peer chaincode invoke -n mycc -c '{"Args":["securityTransfer", "S","002000000001" , "002000000002" , "A07103" , "102000","100000","true","20180417"]}' -C myc
+peer chaincode invoke -n mycc -c '{"Args":["securityTransfer", "S","002000000001" , "002000000002" , "A07103" , "102000","100000","true","","REF0001"]}' -C myc
 
 */
 func (s *SmartContract) securityTransfer(
 	stub shim.ChaincodeStubInterface,
 	args []string) peer.Response {
 
-	TimeNow := time.Now().Format(timelayout)
-
+	//暫停期間不受理，見 Halt.go
+	if len(args) > 3 {
+		if errMsg := checkHalt(stub, args[3], args[1], args[2]); errMsg != "" {
+			return errorResponse(codeTradingHalted, errMsg)
+		}
+	}
 	newTX, isPutInQueue, errMsg := validateTransaction(stub, args)
 	if errMsg != "" {
 		//return shim.Error(err.Error())
-		newTX.TXErrMsg = errMsg
+		newTX.TXErrDetail = errMsg
 		newTX.TXStatus = "Cancelled"
-		newTX.TXMemo = "交易被取消"
+		newTX.TXMemo = codeTXCancelled
 	}
 	TXIndex := newTX.TXIndex
 	TXSIndex := newTX.TXSIndex
@@ -405,12 +435,30 @@ func (s *SmartContract) securityTransfer(
 	var doflg bool
 	var TXKinds string
 	doflg = false
-	TXKEY := SubString(TimeNow, 0, 8) //A0710220180326
+	TXKEY, dayErrMsg := getBookingBusinessDate(stub, TXID) //A0710220180326
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
+	//交易日及預設交割日一律為登錄營業日
+	BookingDate := TXKEY
+	newTX.TradeDate = BookingDate
+	if newTX.SettlementDate == "" {
+		newTX.SettlementDate = BookingDate
+	}
 	HTXKEY := "H" + TXKEY
-	TXDAY := SubString(TXID, 18, 8)
-	if TXDAY < TXKEY {
-		TXKEY = TXDAY
-		HTXKEY = "H" + TXKEY
+	if newTX.SettlementDate < BookingDate && newTX.TXStatus != "Cancelled" {
+		newTX.TXErrMsg = codeSettlementDateInvalid
+		newTX.TXStatus = "Cancelled"
+		newTX.TXMemo = codeTXCancelled
+		TXStatus = newTX.TXStatus
+		err := releasePendingBalance(stub, SecurityID, Payment, TXFrom, TXTo)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	//跨日比對：之前營業日有可比對的 Pending 交易時，將其移至登錄營業日的佇列，於登錄營業日比對及交割
+	if err := carryMatchInstruction(stub, newTX, TXKEY); err != nil {
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	if BankFrom != BankTo {
@@ -448,6 +496,8 @@ func (s *SmartContract) securityTransfer(
 		ApproveFlag = string(ValueAsBytes)
 	}
 
+	SettlementMode := getSettlementMode(stub)
+
 	fmt.Printf("2.ApproveFlag=%s\n", ApproveFlag)
 	fmt.Printf("3.isPutInQueue=%s\n", isPutInQueue)
 
@@ -456,18 +506,18 @@ func (s *SmartContract) securityTransfer(
 		queueAsBytes, err := stub.GetState(TXKEY)
 		if err != nil {
 			//return shim.Error(err.Error())
-			newTX.TXErrMsg = TXKEY + ":QueueID does not exits."
+			newTX.TXErrMsg = codeQueueNotFound
 			newTX.TXStatus = "Cancelled"
-			newTX.TXMemo = "交易被取消"
+			newTX.TXMemo = codeTXCancelled
 		}
 		queuedTx := QueuedTransaction{}
 		json.Unmarshal(queueAsBytes, &queuedTx)
 
 		historyAsBytes, err := stub.GetState(HTXKEY)
 		if err != nil {
-			newTX.TXErrMsg = HTXKEY + ":HistoryID does not exits."
+			newTX.TXErrMsg = codeQueueNotFound
 			newTX.TXStatus = "Cancelled"
-			newTX.TXMemo = "交易被取消"
+			newTX.TXMemo = codeTXCancelled
 		}
 		historyNewTX := TransactionHistory{}
 		json.Unmarshal(historyAsBytes, &historyNewTX)
@@ -490,8 +540,10 @@ func (s *SmartContract) securityTransfer(
 				historyNewTX.TXKinds = append(historyNewTX.TXKinds, TXKinds)
 			}
 		} else if queueAsBytes != nil {
-			for key, val := range queuedTx.Transactions {
-				if val.TXIndex == TXIndex && val.TXStatus == TXStatus && val.TXFrom != TXFrom && val.TXType != TXType && val.TXID != TXID {
+			//依優先順序、再依進入佇列先後比對
+			for _, key := range getQueueOrder(queuedTx.Transactions) {
+				val := queuedTx.Transactions[key]
+				if isMatchingInstruction(newTX, val) && val.TXStatus == TXStatus {
 					fmt.Println("1.TXIndex= " + TXIndex + "\n")
 					fmt.Println("2.TXFrom= " + TXFrom + "\n")
 					fmt.Println("3.TXType= " + TXType + "\n")
@@ -503,9 +555,9 @@ func (s *SmartContract) securityTransfer(
 					if TXStatus == "Pending" && val.TXStatus == "Pending" {
 						if doflg == true {
 							//return shim.Error("doflg eq to true")
-							newTX.TXErrMsg = "doflg can not equle to true."
+							newTX.TXErrMsg = codeDuplicateMatch
 							newTX.TXStatus = "Cancelled"
-							newTX.TXMemo = "交易被取消"
+							newTX.TXMemo = codeTXCancelled
 							break
 						}
 						newTX.MatchedTXID = val.TXID
@@ -514,9 +566,9 @@ func (s *SmartContract) securityTransfer(
 						err = updateTransactionStatus(stub, val.TXID, "Matched", TXID)
 						if err != nil {
 							//return shim.Error(err.Error())
-							newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Matched."
+							newTX.TXErrMsg = codeStateError
 							newTX.TXStatus = "Cancelled"
-							newTX.TXMemo = "交易被取消"
+							newTX.TXMemo = codeTXCancelled
 							break
 						}
 						queuedTx.Transactions[key].TXStatus = "Matched"
@@ -526,6 +578,33 @@ func (s *SmartContract) securityTransfer(
 						queuedTx.Transactions[key].TXMemo = ""
 						historyNewTX.Transactions[key].TXMemo = ""
 						newTX.TXMemo = ""
+						//交割日在後之交易維持 Matched，至交割日由 openBusinessDay 或 runSettlementCycle 交割
+						if newTX.SettlementDate > TXKEY {
+							SellerTXID := TXID
+							if TXType != "S" {
+								SellerTXID = val.TXID
+							}
+							err = putSettlementDue(stub, newTX.SettlementDate, SellerTXID)
+							if err != nil {
+								newTX.TXErrMsg = codeStateError
+								newTX.TXStatus = "Cancelled"
+								newTX.TXMemo = codeTXCancelled
+								break
+							}
+							queuedTx.Transactions[key].TXMemo = codeWaitingSettlementDate
+							historyNewTX.Transactions[key].TXMemo = codeWaitingSettlementDate
+							newTX.TXMemo = codeWaitingSettlementDate
+							doflg = true
+							break
+						}
+						//NET 模式 DVP 交易維持 Matched，由 runSettlementCycle 批次交割
+						if SettlementMode == settlementNet && SecurityAmount != 0 {
+							queuedTx.Transactions[key].TXMemo = codeWaitingNetSettlement
+							historyNewTX.Transactions[key].TXMemo = codeWaitingNetSettlement
+							newTX.TXMemo = codeWaitingNetSettlement
+							doflg = true
+							break
+						}
 						if TXType == "S" {
 							//轉出          轉入
 							senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err := updateAccountBalance(stub, SecurityID, SecurityAmount, Payment, TXFrom, TXTo)
@@ -534,25 +613,25 @@ func (s *SmartContract) securityTransfer(
 								err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, true)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals TXFrom:" + TXFrom
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 								err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, false)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals2 TXTo:" + TXTo
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 							}
 							if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
 								//return shim.Error("TxType=S - senderBalance or receiverBalance <0")
-								newTX.TXErrMsg = "TxType=S - senderBalance or receiverBalance or senderPendingBalance or receiverPendingBalance <0"
+								newTX.TXErrMsg = codeNegativeBalance
 								newTX.TXStatus = "Cancelled"
-								newTX.TXMemo = "交易被取消"
+								newTX.TXMemo = codeTXCancelled
 								break
 							}
 
@@ -565,25 +644,25 @@ func (s *SmartContract) securityTransfer(
 								err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, false)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals TXFrom:" + TXFrom
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 								err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, true)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals2 TXTo:" + TXTo
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 							}
 							if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
 								//return shim.Error("TxType=B - senderBalance or receiverBalance <0")
-								newTX.TXErrMsg = "TxType=B - senderBalance or receiverBalance or PendingBalance <0"
+								newTX.TXErrMsg = codeNegativeBalance
 								newTX.TXStatus = "Cancelled"
-								newTX.TXMemo = "交易被取消"
+								newTX.TXMemo = codeTXCancelled
 								break
 							}
 
@@ -604,7 +683,7 @@ func (s *SmartContract) securityTransfer(
 									err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
@@ -613,18 +692,18 @@ func (s *SmartContract) securityTransfer(
 									historyNewTX.Transactions[key].TXStatus = "PaymentError"
 									historyNewTX.TXStatus[key] = "PaymentError"
 									newTX.TXStatus = "PaymentError"
-									queuedTx.Transactions[key].TXMemo = "款不足等待補款"
-									historyNewTX.Transactions[key].TXMemo = "款不足等待補款"
-									newTX.TXMemo = "款不足等待補款"
+									queuedTx.Transactions[key].TXMemo = codeWaitingForCash
+									historyNewTX.Transactions[key].TXMemo = codeWaitingForCash
+									newTX.TXMemo = codeWaitingForCash
 									err := updateTransactionStatus(stub, val.TXID, "PaymentError", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = PaymentError."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
 								} else if ApproveFlag == approved5 {
-									_, _, securityamount, _, _ := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
+									_, _, securityamount, _, _, _ := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
 									fmt.Printf("1-1.Account securityamount=%s\n", securityamount)
 									fmt.Printf("1-2.Transaction SecurityAmount=%s\n", SecurityAmount)
 									fmt.Printf("1-3.Approved errMsg=%s\n", errMsg)
@@ -633,13 +712,13 @@ func (s *SmartContract) securityTransfer(
 										historyNewTX.Transactions[key].TXStatus = "PaymentError"
 										historyNewTX.TXStatus[key] = "PaymentError"
 										newTX.TXStatus = "PaymentError"
-										queuedTx.Transactions[key].TXMemo = "款不足等待補款"
-										historyNewTX.Transactions[key].TXMemo = "款不足等待補款"
-										newTX.TXMemo = "款不足等待補款"
+										queuedTx.Transactions[key].TXMemo = codeWaitingForCash
+										historyNewTX.Transactions[key].TXMemo = codeWaitingForCash
+										newTX.TXMemo = codeWaitingForCash
 										err := updateTransactionStatus(stub, val.TXID, "PaymentError", TXID)
 										if err != nil {
 											//return shim.Error(err.Error())
-											newTX.TXErrMsg = "Failed to execute updateTransactionStatus = PaymentError."
+											newTX.TXErrMsg = codeStateError
 											//newTX.TXStatus = "Cancelled"
 											break
 										}
@@ -654,7 +733,7 @@ func (s *SmartContract) securityTransfer(
 										err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 										if err != nil {
 											//return shim.Error(err.Error())
-											newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+											newTX.TXErrMsg = codeStateError
 											//newTX.TXStatus = "Cancelled"
 											break
 										}
@@ -664,13 +743,13 @@ func (s *SmartContract) securityTransfer(
 									historyNewTX.Transactions[key].TXStatus = "Waiting4Payment"
 									historyNewTX.TXStatus[key] = "Waiting4Payment"
 									newTX.TXStatus = "Waiting4Payment"
-									queuedTx.Transactions[key].TXMemo = "等待回應"
-									historyNewTX.Transactions[key].TXMemo = "等待回應"
-									newTX.TXMemo = "等待回應"
+									queuedTx.Transactions[key].TXMemo = codeWaitingForResponse
+									historyNewTX.Transactions[key].TXMemo = codeWaitingForResponse
+									newTX.TXMemo = codeWaitingForResponse
 									err := updateTransactionStatus(stub, val.TXID, "Waiting4Payment", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Waiting4Payment."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
@@ -686,7 +765,7 @@ func (s *SmartContract) securityTransfer(
 								err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+									newTX.TXErrMsg = codeStateError
 									//newTX.TXStatus = "Cancelled"
 									break
 								}
@@ -702,7 +781,7 @@ func (s *SmartContract) securityTransfer(
 							err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 							if err != nil {
 								//return shim.Error(err.Error())
-								newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+								newTX.TXErrMsg = codeStateError
 								//newTX.TXStatus = "Cancelled"
 								break
 							}
@@ -713,19 +792,19 @@ func (s *SmartContract) securityTransfer(
 					}
 				} else {
 					fmt.Println("1.TXSIndex= " + TXSIndex + "\n")
-					if val.TXSIndex == TXSIndex && val.TXStatus == TXStatus && val.TXIndex != TXIndex && val.TXFrom != TXFrom && val.TXType != TXType && val.TXID != TXID {
+					if isNearMatchInstruction(newTX, val) && val.TXStatus == TXStatus {
 						if TXStatus == "Pending" && val.TXStatus == "Pending" {
 							if (SecurityAmount != val.SecurityAmount) && (Payment == val.Payment) {
 								if SecurityAmount != val.SecurityAmount {
 									newTX.MatchedTXID = val.TXID
 									queuedTx.Transactions[key].MatchedTXID = TXID
 									historyNewTX.Transactions[key].MatchedTXID = TXID
-									newTX.TXMemo = "交易金額疑輸錯"
-									queuedTx.Transactions[key].TXMemo = "交易金額疑輸錯"
-									historyNewTX.Transactions[key].TXMemo = "交易金額疑輸錯"
-									newTX.TXErrMsg = "SecurityAmount != val.SecurityAmount"
-									queuedTx.Transactions[key].TXErrMsg = "SecurityAmount != val.SecurityAmount"
-									historyNewTX.Transactions[key].TXErrMsg = "SecurityAmount != val.SecurityAmount"
+									newTX.TXMemo = codeAmountMismatch
+									queuedTx.Transactions[key].TXMemo = codeAmountMismatch
+									historyNewTX.Transactions[key].TXMemo = codeAmountMismatch
+									newTX.TXErrMsg = codeAmountMismatch
+									queuedTx.Transactions[key].TXErrMsg = codeAmountMismatch
+									historyNewTX.Transactions[key].TXErrMsg = codeAmountMismatch
 								}
 							}
 							if (SecurityAmount == val.SecurityAmount) && (Payment != val.Payment) {
@@ -733,36 +812,36 @@ func (s *SmartContract) securityTransfer(
 									newTX.MatchedTXID = val.TXID
 									queuedTx.Transactions[key].MatchedTXID = TXID
 									historyNewTX.Transactions[key].MatchedTXID = TXID
-									newTX.TXMemo = "交易面額疑輸錯"
-									queuedTx.Transactions[key].TXMemo = "交易面額疑輸錯"
-									historyNewTX.Transactions[key].TXMemo = "交易面額疑輸錯"
-									newTX.TXErrMsg = "Payment != val.Payment"
-									queuedTx.Transactions[key].TXErrMsg = "Payment != val.Payment"
-									historyNewTX.Transactions[key].TXErrMsg = "Payment != val.Payment"
+									newTX.TXMemo = codeFaceValueMismatch
+									queuedTx.Transactions[key].TXMemo = codeFaceValueMismatch
+									historyNewTX.Transactions[key].TXMemo = codeFaceValueMismatch
+									newTX.TXErrMsg = codeFaceValueMismatch
+									queuedTx.Transactions[key].TXErrMsg = codeFaceValueMismatch
+									historyNewTX.Transactions[key].TXErrMsg = codeFaceValueMismatch
 								}
 							}
 						}
-						if val.TXMemo == "轉出方券不足" && val.TXType == "S" {
+						if val.TXMemo == codeInsufficientSecurities && val.TXType == "S" {
 							newTX.MatchedTXID = val.TXID
 							queuedTx.Transactions[key].MatchedTXID = TXID
 							historyNewTX.Transactions[key].MatchedTXID = TXID
-							newTX.TXMemo = "轉出方券不足"
-							queuedTx.Transactions[key].TXMemo = "轉出方券不足"
-							historyNewTX.Transactions[key].TXMemo = "轉出方券不足"
-							newTX.TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							queuedTx.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							historyNewTX.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
+							newTX.TXMemo = codeInsufficientSecurities
+							queuedTx.Transactions[key].TXMemo = codeInsufficientSecurities
+							historyNewTX.Transactions[key].TXMemo = codeInsufficientSecurities
+							newTX.TXErrMsg = val.TXErrMsg
+							queuedTx.Transactions[key].TXErrMsg = val.TXErrMsg
+							historyNewTX.Transactions[key].TXErrMsg = val.TXErrMsg
 						}
-						if val.TXMemo == "轉入方款不足" && val.TXType == "B" {
+						if val.TXMemo == codeInsufficientCash && val.TXType == "B" {
 							newTX.MatchedTXID = val.TXID
 							queuedTx.Transactions[key].MatchedTXID = TXID
 							historyNewTX.Transactions[key].MatchedTXID = TXID
-							newTX.TXMemo = "轉入方款不足"
-							queuedTx.Transactions[key].TXMemo = "轉入方款不足"
-							historyNewTX.Transactions[key].TXMemo = "轉入方款不足"
-							newTX.TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							queuedTx.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							historyNewTX.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
+							newTX.TXMemo = codeInsufficientCash
+							queuedTx.Transactions[key].TXMemo = codeInsufficientCash
+							historyNewTX.Transactions[key].TXMemo = codeInsufficientCash
+							newTX.TXErrMsg = val.TXErrMsg
+							queuedTx.Transactions[key].TXErrMsg = val.TXErrMsg
+							historyNewTX.Transactions[key].TXErrMsg = val.TXErrMsg
 						}
 					}
 				}
@@ -790,21 +869,28 @@ func (s *SmartContract) securityTransfer(
 		QueuedAsBytes, err := json.Marshal(queuedTx)
 		err = stub.PutState(TXKEY, QueuedAsBytes)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 		historyAsBytes, err = json.Marshal(historyNewTX)
 		err = stub.PutState(HTXKEY, historyAsBytes)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	//尚未比對的交易寫入比對索引，供之後營業日跨日比對
+	if newTX.TXStatus == "Pending" {
+		err = putMatchIndex(stub, TXKEY, newTX)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
 		}
 	}
 	TransactionAsBytes, err := json.Marshal(newTX)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = stub.PutState(TXID, TransactionAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(nil)
@@ -821,45 +907,72 @@ func validateTransaction(
 	transaction := Transaction{}
 	transaction.ObjectType = TransactionObjectType
 	transaction.TXStatus = "Cancelled"
-	transaction.TXMemo = "尚未比對"
+	transaction.TXMemo = codeNotMatched
 	transaction.TXErrMsg = ""
 	transaction.TXHcode = ""
 	transaction.IsFrozen = false
 	transaction.CreateTime = TimeNow2
 	transaction.UpdateTime = TimeNow2
 
+	//第 9 個參數為共同交易參考編號
+	if len(args) == 9 {
+		transaction.TradeRef = strings.ToUpper(args[8])
+		args = args[:8]
+	}
+	//第 8 個參數為交割日(YYYYMMDD)，未輸入時同交易日
+	if len(args) == 8 {
+		if args[7] != "" {
+			_, err = time.Parse("20060102", args[7])
+			if err != nil {
+				transaction.TXErrMsg = codeArgumentFormat
+				return transaction, false, "SettlementDate must be a YYYYMMDD string."
+			}
+			transaction.SettlementDate = args[7]
+		}
+		args = args[:7]
+	}
 	err = checkArgArrayLength(args, 7)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentCount
 		return transaction, false, "The args-length must be 7."
 	}
 	if len(args[0]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXType must be a non-empty string."
 	}
 	if len(args[1]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXFrom must be a non-empty string."
 	}
 	if len(args[2]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXTo must be a non-empty string."
 	}
 	if len(args[3]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "SecurityID must be a non-empty string."
 	}
 	if len(args[4]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "SecurityAmount must be a non-empty string."
 	}
 	if len(args[5]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "Payment must be a non-empty string."
 	}
 	if len(args[6]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "isPutToQueue flag must be a non-empty string."
 	}
 	isPutToQueue, err := strconv.ParseBool(strings.ToLower(args[6]))
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "isPutToQueue must be a boolean string."
 	}
 	transaction.isPutToQueue = isPutToQueue
 	TXType := SubString(strings.ToUpper(args[0]), 0, 1)
 	if (TXType != "B") && (TXType != "S") {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "TXType must be a B or S."
 	}
 	transaction.TXType = TXType
@@ -872,43 +985,58 @@ func validateTransaction(
 	transaction.TXFrom = TXFrom
 	transaction.TXTo = TXTo
 	if TXFrom == TXTo {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "TXFrom can not equal to TXTo."
 	}
 	BankFromID := "BANK" + SubString(TXFrom, 0, 3)
 	if verifyIdentity(stub, BankFromID) != "" {
+		transaction.TXErrMsg = codeUnauthorized
 		return transaction, false, "BankFromID does not exits in the BankList."
 	}
 	transaction.BankFrom = BankFrom
 	transaction.BankTo = BankTo
+	transaction.TXPriority = getDefaultTXPriority(BankFrom, BankTo)
 	SecurityID := strings.ToUpper(args[3])
-	_, err = getSecurityStructFromID(stub, SecurityID)
+	security, err := getSecurityStructFromID(stub, SecurityID)
 
 	if err != nil {
+		transaction.TXErrMsg = codeNotFound
 		return transaction, false, "SecurityID does not exits."
 	}
+	if errMsg := checkSecurityStatus(security, securityOpTransfer); errMsg != "" {
+		transaction.TXErrMsg = codeSecurityStatusNotAllowed
+		return transaction, false, errMsg
+	}
 	transaction.SecurityID = SecurityID
 	SecurityAmount, err := strconv.ParseInt(args[4], 10, 64)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "SecurityAmount must be a numeric string."
 	} else if SecurityAmount < 0 {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "SecurityAmount must be a positive value"
 	}
 	transaction.SecurityAmount = SecurityAmount
 	Payment, err := strconv.ParseInt(args[5], 10, 64)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "Payment must be a numeric string"
 	} else if Payment < 0 {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "Payment must be a positive value"
 	}
 	transaction.Payment = Payment
-	senderPendingBalance, receiverPendingBalance, errMsg := updateAccountPendingBalance(stub, SecurityID, Payment, TXFrom, TXTo)
+	senderPendingBalance, receiverPendingBalance, errCode, errMsg := updateAccountPendingBalance(stub, SecurityID, Payment, TXFrom, TXTo)
 	if errMsg != "" {
+		transaction.TXErrMsg = errCode
 		return transaction, true, errMsg
 	}
 	if senderPendingBalance <= 0 {
+		transaction.TXErrMsg = codeInsufficientSecurities
 		return transaction, true, "senderPendingBalance less equle to zero."
 	}
 	if receiverPendingBalance <= 0 {
+		transaction.TXErrMsg = codeInsufficientSecurities
 		return transaction, true, "receiverPendingBalance less equle to zero."
 	}
 
@@ -930,14 +1058,15 @@ func validateTransaction(
 
 	transaction.TXIndex = TXIndex
 	transaction.TXSIndex = TXSIndex
-	balance, position, securityamount, pendingbalance, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, senderPendingBalance)
+	balance, position, securityamount, pendingbalance, errCode, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, senderPendingBalance)
 	transaction.TXFromBalance = balance
 	transaction.TXFromPosition = position
 	transaction.TXFromAmount = securityamount
 	if errMsg != "" && TXType == "S" {
-		transaction.TXMemo = "轉出方券不足"
+		transaction.TXMemo = codeInsufficientSecurities
 		//transaction.TXErrMsg = TXFrom + ":Payment > Balance"
-		transaction.TXErrMsg = errMsg
+		transaction.TXErrMsg = errCode
+		transaction.TXErrDetail = errMsg
 		fmt.Printf("Payment: %s\n", Payment)
 		fmt.Printf("balance: %s\n", balance)
 		fmt.Printf("position: %s\n", position)
@@ -946,9 +1075,10 @@ func validateTransaction(
 		return transaction, true, errMsg
 	}
 	if errMsg != "" && TXType == "B" {
-		transaction.TXMemo = "轉入方券不足"
+		transaction.TXMemo = codeReceiverInsufficientSecurities
 		//transaction.TXErrMsg = TXFrom + ":Payment > Balance"
-		transaction.TXErrMsg = errMsg
+		transaction.TXErrMsg = errCode
+		transaction.TXErrDetail = errMsg
 		fmt.Printf("Payment: %s\n", Payment)
 		fmt.Printf("balance: %s\n", balance)
 		fmt.Printf("position: %s\n", position)
@@ -1080,8 +1210,8 @@ func getMD5Str(myData string) string {
 	return encodeStr
 }
 
-func checkAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, Payment int64, Amount int64, sender string, TXType string, senderPendingBalance int64) (int64, int64, int64, int64, string) {
-	senderAccount, err := getAccountStructFromID(stub, sender)
+func checkAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, Payment int64, Amount int64, sender string, TXType string, senderPendingBalance int64) (int64, int64, int64, int64, string, string) {
+	_, err := getAccountStructFromID(stub, sender)
 	var Balance int64
 	var Position int64
 	var SecurityAmount int64
@@ -1091,71 +1221,66 @@ func checkAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, Pa
 	SecurityAmount = 0
 	PendingBalance = 0
 	if err != nil {
-		return Balance, Position, SecurityAmount, PendingBalance, "getAccountStructFromID error:" + sender
+		return Balance, Position, SecurityAmount, PendingBalance, codeNotFound, "getAccountStructFromID error:" + sender
 	}
 	//if TXType != "S" {
 	//	return Balance, Position, SecurityAmount, TotalPayment, "TXType is not equle to S."
 	//}
-	var doflg bool
-	doflg = false
-	for key, val := range senderAccount.Assets {
-		if val.SecurityID == SecurityID {
-			SecurityAmount = senderAccount.Assets[key].SecurityAmount
-			Balance = senderAccount.Assets[key].Balance
-			Position = senderAccount.Assets[key].Position
-			PendingBalance = senderAccount.Assets[key].PendingBalance
-			if senderPendingBalance >= 0 {
-				PendingBalance = senderPendingBalance
-			}
-			fmt.Printf("1.checkAccountBalance: SecurityAmount=%d\n", SecurityAmount)
-			fmt.Printf("1.checkAccountBalance: Balance=%d\n", Balance)
-			fmt.Printf("1.checkAccountBalance: Position=%d\n", Position)
-			fmt.Printf("1.checkAccountBalance: PendingBalance=%d\n", PendingBalance)
-			fmt.Printf("1.checkAccountBalance: SecurityID=%d\n", SecurityID)
-
-			if Payment > Balance {
-				errMsg := fmt.Sprintf(
-					"Error: Payment: (%s)  > Balance: (%s)",
-					strconv.FormatInt(Payment, 10),
-					strconv.FormatInt(Balance, 10))
-				return Balance, Position, SecurityAmount, PendingBalance, errMsg
-			} else if Payment > Position {
-				errMsg := fmt.Sprintf(
-					"Error: Payment: (%s)  > Position: (%s)",
-					strconv.FormatInt(Payment, 10),
-					strconv.FormatInt(Position, 10))
-				return Balance, Position, SecurityAmount, PendingBalance, errMsg
-			} else if Amount > SecurityAmount {
-				errMsg := fmt.Sprintf(
-					"Error: Amount: (%s)  > SecurityAmount: (%s)",
-					strconv.FormatInt(Amount, 10),
-					strconv.FormatInt(SecurityAmount, 10))
-				return Balance, Position, SecurityAmount, PendingBalance, errMsg
-			} else if Payment > PendingBalance {
-				errMsg := fmt.Sprintf(
-					"Error: Payment: (%s)  > PendingBalance: (%s)",
-					strconv.FormatInt(Payment, 10),
-					strconv.FormatInt(PendingBalance, 10))
-				return Balance, Position, SecurityAmount, PendingBalance, errMsg
-			}
-
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This SecurityID does not exists (%s)",
 			SecurityID)
-		return Balance, Position, SecurityAmount, PendingBalance, errMsg
+		return Balance, Position, SecurityAmount, PendingBalance, codeNotFound, errMsg
+	}
+	SecurityAmount = senderPosition.SecurityAmount
+	Balance = senderPosition.Balance
+	Position = senderPosition.Position
+	PendingBalance = senderPosition.PendingBalance
+	if senderPendingBalance >= 0 {
+		PendingBalance = senderPendingBalance
+	}
+	//質押券數不可動用，見 Pledge.go
+	Balance -= senderPosition.PledgedBalance
+	Position -= senderPosition.PledgedBalance
+	PendingBalance -= senderPosition.PledgedBalance
+	fmt.Printf("1.checkAccountBalance: SecurityAmount=%d\n", SecurityAmount)
+	fmt.Printf("1.checkAccountBalance: Balance=%d\n", Balance)
+	fmt.Printf("1.checkAccountBalance: Position=%d\n", Position)
+	fmt.Printf("1.checkAccountBalance: PendingBalance=%d\n", PendingBalance)
+	fmt.Printf("1.checkAccountBalance: SecurityID=%s\n", SecurityID)
+
+	if Payment > Balance {
+		errMsg := fmt.Sprintf(
+			"Error: Payment: (%s)  > Balance: (%s)",
+			strconv.FormatInt(Payment, 10),
+			strconv.FormatInt(Balance, 10))
+		return Balance, Position, SecurityAmount, PendingBalance, codeInsufficientSecurities, errMsg
+	} else if Payment > Position {
+		errMsg := fmt.Sprintf(
+			"Error: Payment: (%s)  > Position: (%s)",
+			strconv.FormatInt(Payment, 10),
+			strconv.FormatInt(Position, 10))
+		return Balance, Position, SecurityAmount, PendingBalance, codeInsufficientSecurities, errMsg
+	} else if Amount > SecurityAmount {
+		errMsg := fmt.Sprintf(
+			"Error: Amount: (%s)  > SecurityAmount: (%s)",
+			strconv.FormatInt(Amount, 10),
+			strconv.FormatInt(SecurityAmount, 10))
+		return Balance, Position, SecurityAmount, PendingBalance, codeInsufficientCash, errMsg
+	} else if Payment > PendingBalance {
+		errMsg := fmt.Sprintf(
+			"Error: Payment: (%s)  > PendingBalance: (%s)",
+			strconv.FormatInt(Payment, 10),
+			strconv.FormatInt(PendingBalance, 10))
+		return Balance, Position, SecurityAmount, PendingBalance, codeInsufficientSecurities, errMsg
 	}
 
-	return Balance, Position, SecurityAmount, PendingBalance, ""
+	return Balance, Position, SecurityAmount, PendingBalance, "", ""
 }
 
 func updateAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, SecurityAmount int64, Payment int64, sender string, receiver string) (int64, int64, int64, int64, error) {
-	senderAccount, err := getAccountStructFromID(stub, sender)
-	receiverAccount, err := getAccountStructFromID(stub, receiver)
+	_, err := getAccountStructFromID(stub, sender)
 	var senderBalance int64
 	var receiverBalance int64
 	var senderPendingBalance int64
@@ -1163,52 +1288,46 @@ func updateAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, S
 	if err != nil {
 		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 	}
-
-	var doflg bool
-	doflg = false
-	for key, val := range senderAccount.Assets {
-		if val.SecurityID == SecurityID {
-			senderAccount.Assets[key].SecurityAmount += SecurityAmount
-			senderAccount.Assets[key].Balance -= Payment
-			senderAccount.Assets[key].Position -= Payment
-			senderAccount.Assets[key].TotalPayment += Payment
-			senderBalance = senderAccount.Assets[key].Balance
-			senderAccount.Assets[key].PendingBalance = senderBalance
-			senderPendingBalance = senderAccount.Assets[key].PendingBalance
-			doflg = true
-			break
-		}
+	receiverAccount, err := getAccountStructFromID(stub, receiver)
+	if err != nil {
+		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 	}
-	if doflg != true {
+
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This SecurityID does not exists (%s)",
 			SecurityID)
 		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, errors.New(errMsg)
 	}
-	for key, val := range receiverAccount.Assets {
-		if val.SecurityID == SecurityID {
-			receiverAccount.Assets[key].SecurityAmount -= SecurityAmount
-			receiverAccount.Assets[key].Balance += Payment
-			receiverAccount.Assets[key].Position += Payment
-			receiverAccount.Assets[key].TotalPayment -= Payment
-			receiverBalance = receiverAccount.Assets[key].Balance
-			receiverAccount.Assets[key].PendingBalance = receiverBalance
-			receiverPendingBalance = receiverAccount.Assets[key].PendingBalance
-			doflg = true
-			break
-		}
+	senderPosition.SecurityAmount += SecurityAmount
+	senderPosition.Balance -= Payment
+	senderPosition.Position -= Payment
+	senderPosition.TotalPayment += Payment
+	senderBalance = senderPosition.Balance
+	senderPosition.PendingBalance = senderBalance
+	senderPendingBalance = senderPosition.PendingBalance
+
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	if err != nil {
+		receiverPosition = newPosition(receiver, receiverAccount.BankID, SecurityID)
 	}
+	receiverPosition.SecurityAmount -= SecurityAmount
+	receiverPosition.Balance += Payment
+	receiverPosition.Position += Payment
+	receiverPosition.TotalPayment -= Payment
+	receiverBalance = receiverPosition.Balance
+	receiverPosition.PendingBalance = receiverBalance
+	receiverPendingBalance = receiverPosition.PendingBalance
 
 	if senderBalance >= 0 {
-		senderAccountAsBytes, err := json.Marshal(senderAccount)
-		err = stub.PutState(sender, senderAccountAsBytes)
+		err = putPositionStruct(stub, senderPosition)
 		if err != nil {
 			return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 		}
 	}
 	if receiverBalance >= 0 {
-		receiverAccountAsBytes, err := json.Marshal(receiverAccount)
-		err = stub.PutState(receiver, receiverAccountAsBytes)
+		err = putPositionStruct(stub, receiverPosition)
 		if err != nil {
 			return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 		}
@@ -1218,84 +1337,70 @@ func updateAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, S
 	fmt.Printf("3.senderPendingBalance= %d\n", senderPendingBalance)
 	fmt.Printf("4.receiverPendingBalance= %d\n", receiverPendingBalance)
 
-	return senderBalance, receiverBalance, senderPendingBalance, receiverBalance, nil
+	return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, nil
 }
 
-func updateAccountPendingBalance(stub shim.ChaincodeStubInterface, SecurityID string, Payment int64, sender string, receiver string) (int64, int64, string) {
+func updateAccountPendingBalance(stub shim.ChaincodeStubInterface, SecurityID string, Payment int64, sender string, receiver string) (int64, int64, string, string) {
 
 	var senderPendingBalance int64
 	var receiverPendingBalance int64
 
-	senderAccount, err := getAccountStructFromID(stub, sender)
-
+	_, err := getAccountStructFromID(stub, sender)
 	if err != nil {
-		return senderPendingBalance, receiverPendingBalance, "getAccountStructFromID,sender:" + sender
+		return senderPendingBalance, receiverPendingBalance, codeNotFound, "getAccountStructFromID,sender:" + sender
 	}
 
-	receiverAccount, err := getAccountStructFromID(stub, receiver)
+	_, err = getAccountStructFromID(stub, receiver)
 	if err != nil {
-		return senderPendingBalance, receiverPendingBalance, "getAccountStructFromID,receiver:" + receiver
+		return senderPendingBalance, receiverPendingBalance, codeNotFound, "getAccountStructFromID,receiver:" + receiver
 	}
 
-	var doflg bool
-	doflg = false
-	for key, val := range senderAccount.Assets {
-		if val.SecurityID == SecurityID {
-			if senderAccount.Assets[key].PendingBalance <= 0 && senderAccount.Assets[key].Balance > Payment {
-				senderAccount.Assets[key].PendingBalance = senderAccount.Assets[key].Balance
-			} else if senderAccount.Assets[key].PendingBalance > 0 && senderAccount.Assets[key].Balance < Payment {
-				senderAccount.Assets[key].PendingBalance = senderAccount.Assets[key].Balance
-			} else {
-				senderAccount.Assets[key].PendingBalance -= Payment
-			}
-			senderPendingBalance = senderAccount.Assets[key].PendingBalance
-			doflg = true
-			break
-		}
-	}
-	if doflg != true {
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This SecurityID does not exists (%s)",
 			SecurityID)
-		return senderPendingBalance, receiverPendingBalance, errMsg
-	}
-	if doflg == true {
-		for key, val := range receiverAccount.Assets {
-			if val.SecurityID == SecurityID {
-				if senderAccount.Assets[key].PendingBalance <= 0 && senderAccount.Assets[key].Balance > Payment {
-					receiverAccount.Assets[key].PendingBalance = receiverAccount.Assets[key].Balance
-				} else if senderAccount.Assets[key].PendingBalance > 0 && senderAccount.Assets[key].Balance < Payment {
-					receiverAccount.Assets[key].PendingBalance = receiverAccount.Assets[key].Balance
-				} else {
-					receiverAccount.Assets[key].PendingBalance += Payment
-				}
-				receiverPendingBalance = receiverAccount.Assets[key].PendingBalance
-				doflg = true
-				break
-			}
-		}
+		return senderPendingBalance, receiverPendingBalance, codeNotFound, errMsg
+	}
+	var resetflg bool
+	resetflg = false
+	if senderPosition.PendingBalance <= 0 && senderPosition.Balance > Payment {
+		senderPosition.PendingBalance = senderPosition.Balance
+		resetflg = true
+	} else if senderPosition.PendingBalance > 0 && senderPosition.Balance < Payment {
+		senderPosition.PendingBalance = senderPosition.Balance
+		resetflg = true
+	} else {
+		senderPosition.PendingBalance -= Payment
 	}
+	senderPendingBalance = senderPosition.PendingBalance
 
-	//if senderPendingBalance >= 0 {
-	senderAccountAsBytes, err := json.Marshal(senderAccount)
-	err = stub.PutState(sender, senderAccountAsBytes)
+	err = putPositionStruct(stub, senderPosition)
 	if err != nil {
-		return senderPendingBalance, receiverPendingBalance, "updateAccountPendingBalance putstate error,sender:" + sender
+		return senderPendingBalance, receiverPendingBalance, codeStateError, "updateAccountPendingBalance putstate error,sender:" + sender
 	}
-	//}
-	//if receiverPendingBalance >= 0 {
-	receiverAccountAsBytes, err := json.Marshal(receiverAccount)
-	err = stub.PutState(receiver, receiverAccountAsBytes)
-	if err != nil {
-		return senderPendingBalance, receiverPendingBalance, "updateAccountPendingBalance putstate error,receiver:" + receiver
+
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	if err == nil {
+		if resetflg == true {
+			receiverPosition.PendingBalance = receiverPosition.Balance
+		} else {
+			receiverPosition.PendingBalance += Payment
+		}
+		receiverPendingBalance = receiverPosition.PendingBalance
+
+		err = putPositionStruct(stub, receiverPosition)
+		if err != nil {
+			return senderPendingBalance, receiverPendingBalance, codeStateError, "updateAccountPendingBalance putstate error,receiver:" + receiver
+		}
 	}
-	//}
 	fmt.Printf("1.senderPendingBalance= %d\n", senderPendingBalance)
 	fmt.Printf("2.receiverPendingBalance= %d\n", receiverPendingBalance)
 
-	return senderPendingBalance, receiverPendingBalance, ""
+	return senderPendingBalance, receiverPendingBalance, "", ""
 }
 
+//券數已由 updateAccountBalance 異動於 Position,此處僅異動登錄之公債金額及銀行總額(delta)
 func updateSecurityAmount(stub shim.ChaincodeStubInterface, SecurityID string, Balance int64, Amount int64, sender string, receiver string) (int64, int64, error) {
 	Security, err := getSecurityStructFromID(stub, SecurityID)
 	fmt.Printf("updateSecurityAmount, SecurityID=%s,Balance=%d,Amount=%d,sender=%s,receiver=%s\n", SecurityID, Balance, Amount, sender, receiver)
@@ -1308,61 +1413,42 @@ func updateSecurityAmount(stub shim.ChaincodeStubInterface, SecurityID string, B
 	senderBank := SubString(sender, 0, 3)
 	receiverBank := SubString(receiver, 0, 3)
 
-	var doflg bool
-	doflg = false
-	for key, val := range Security.Owners {
-		if val.OwnedAccountID == sender {
-			Security.Owners[key].OwnedBalance -= Balance
-			Security.Owners[key].OwnedAmount -= Amount
-			senderBalance = Security.Owners[key].OwnedBalance
-			doflg = true
-		}
-		if val.OwnedAccountID == receiver {
-			Security.Owners[key].OwnedBalance += Balance
-			Security.Owners[key].OwnedAmount += Amount
-			receiverBalance = Security.Owners[key].OwnedBalance
-			doflg = true
-		}
-	}
-	if doflg != true {
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This OwnedAccountID does not exists (%s)",
 			sender)
 		return senderBalance, receiverBalance, errors.New(errMsg)
 	}
-	doflg = false
-	TimeNow2 := time.Now().Format(timelayout2)
-
-	if senderBank != receiverBank {
-		for key, val := range Security.SecurityTotals {
-			fmt.Printf("1.Skey: %d\n", key)
-			fmt.Printf("2.Sval: %s\n", val)
-			if val.BankID == senderBank {
-				fmt.Printf("3.Skey: %d\n", key)
-				fmt.Printf("4.Sval: %s\n", val)
-				Security.SecurityTotals[key].TotalBalance -= Balance
-				Security.SecurityTotals[key].TotalAmount -= Amount
-				Security.SecurityTotals[key].UpdateTime = TimeNow2
-				doflg = true
-			}
-			if val.BankID == receiverBank {
-				fmt.Printf("5.Skey: %d\n", key)
-				fmt.Printf("6.Sval: %s\n", val)
-				Security.SecurityTotals[key].TotalBalance += Balance
-				Security.SecurityTotals[key].TotalAmount += Amount
-				Security.SecurityTotals[key].UpdateTime = TimeNow2
-				doflg = true
-			}
-		}
+	senderPosition.OwnedAmount -= Amount
+	senderBalance = senderPosition.Balance
+	err = putPositionStruct(stub, senderPosition)
+	if err != nil {
+		return senderBalance, receiverBalance, err
 	}
 
-	//if senderBalance >= 0 || receiverBalance >= 0 {
-	SecurityAsBytes, err := json.Marshal(Security)
-	err = stub.PutState(SecurityID, SecurityAsBytes)
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	if err != nil {
+		receiverPosition = newPosition(receiver, receiverBank, SecurityID)
+	}
+	receiverPosition.OwnedAmount += Amount
+	receiverBalance = receiverPosition.Balance
+	err = putPositionStruct(stub, receiverPosition)
 	if err != nil {
 		return senderBalance, receiverBalance, err
 	}
-	//}
+
+	//SecurityTotals 以 delta key 記錄，不改寫 Security 文件
+	if senderBank != receiverBank {
+		err = putTotalDelta(stub, reconcileScopeSecurity, senderBank, Security.SecurityID, -Balance, -Amount)
+		if err != nil {
+			return senderBalance, receiverBalance, err
+		}
+		err = putTotalDelta(stub, reconcileScopeSecurity, receiverBank, Security.SecurityID, Balance, Amount)
+		if err != nil {
+			return senderBalance, receiverBalance, err
+		}
+	}
 
 	fmt.Printf("7.senderBalance= %d\n", senderBalance)
 	fmt.Printf("8.receiverBalance= %d\n", receiverBalance)
@@ -1371,6 +1457,7 @@ func updateSecurityAmount(stub shim.ChaincodeStubInterface, SecurityID string, B
 	return senderBalance, receiverBalance, nil
 }
 
+//券數已由 resetAccountBalance 回復於 Position,此處僅回復登錄之公債金額及銀行總額(delta)
 func resetSecurityAmount(stub shim.ChaincodeStubInterface, SecurityID string, Balance int64, Amount int64, sender string, receiver string) (int64, int64, error) {
 
 	fmt.Printf("resetSecurityAmount, SecurityID=%s,Balance=%d,Amount=%d,sender=%s,receiver=%s\n", SecurityID, Balance, Amount, sender, receiver)
@@ -1384,60 +1471,41 @@ func resetSecurityAmount(stub shim.ChaincodeStubInterface, SecurityID string, Ba
 	senderBank := SubString(sender, 0, 3)
 	receiverBank := SubString(receiver, 0, 3)
 
-	var doflg bool
-	doflg = false
-	for key, val := range Security.Owners {
-		if val.OwnedAccountID == sender {
-			Security.Owners[key].OwnedBalance += Balance
-			Security.Owners[key].OwnedAmount += Amount
-			senderBalance = Security.Owners[key].OwnedBalance
-			doflg = true
-		}
-		if val.OwnedAccountID == receiver {
-			Security.Owners[key].OwnedBalance -= Balance
-			Security.Owners[key].OwnedAmount -= Amount
-			receiverBalance = Security.Owners[key].OwnedBalance
-			doflg = true
-		}
-	}
-	if doflg != true {
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This OwnedAccountID does not exists (%s)",
 			sender)
 		return senderBalance, receiverBalance, errors.New(errMsg)
 	}
-	doflg = false
-	TimeNow2 := time.Now().Format(timelayout2)
-	if senderBank != receiverBank {
-		for key, val := range Security.SecurityTotals {
-			fmt.Printf("1.Skey: %d\n", key)
-			fmt.Printf("2.Sval: %s\n", val)
-			if val.BankID == senderBank {
-				fmt.Printf("3.Skey: %d\n", key)
-				fmt.Printf("4.Sval: %s\n", val)
-				Security.SecurityTotals[key].TotalBalance += Balance
-				Security.SecurityTotals[key].TotalAmount += Amount
-				Security.SecurityTotals[key].UpdateTime = TimeNow2
-				doflg = true
-			}
-			if val.BankID == receiverBank {
-				fmt.Printf("5.Skey: %d\n", key)
-				fmt.Printf("6.Sval: %s\n", val)
-				Security.SecurityTotals[key].TotalBalance -= Balance
-				Security.SecurityTotals[key].TotalAmount -= Amount
-				Security.SecurityTotals[key].UpdateTime = TimeNow2
-				doflg = true
-			}
+	senderPosition.OwnedAmount += Amount
+	senderBalance = senderPosition.Balance
+	err = putPositionStruct(stub, senderPosition)
+	if err != nil {
+		return senderBalance, receiverBalance, err
+	}
+
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	if err == nil {
+		receiverPosition.OwnedAmount -= Amount
+		receiverBalance = receiverPosition.Balance
+		err = putPositionStruct(stub, receiverPosition)
+		if err != nil {
+			return senderBalance, receiverBalance, err
 		}
 	}
 
-	//if senderBalance >= 0 || receiverBalance >= 0 {
-	SecurityAsBytes, err := json.Marshal(Security)
-	err = stub.PutState(SecurityID, SecurityAsBytes)
-	if err != nil {
-		return senderBalance, receiverBalance, err
+	//SecurityTotals 以 delta key 記錄，不改寫 Security 文件
+	if senderBank != receiverBank {
+		err = putTotalDelta(stub, reconcileScopeSecurity, senderBank, Security.SecurityID, Balance, Amount)
+		if err != nil {
+			return senderBalance, receiverBalance, err
+		}
+		err = putTotalDelta(stub, reconcileScopeSecurity, receiverBank, Security.SecurityID, -Balance, -Amount)
+		if err != nil {
+			return senderBalance, receiverBalance, err
+		}
 	}
-	//}
 
 	fmt.Printf("7.senderBalance= %d\n", senderBalance)
 	fmt.Printf("8.receiverBalance= %d\n", receiverBalance)
@@ -1456,27 +1524,27 @@ func updateTransactionStatus(stub shim.ChaincodeStubInterface, TXID string, TXSt
 	TXErrMsg = ""
 
 	if TXStatus == "Waiting4Payment" {
-		TXMemo = "等待回應"
+		TXMemo = codeWaitingForResponse
 		transaction.TXMemo = TXMemo
 		transaction.TXStatus = "Waiting4Payment"
 	}
 	if TXStatus == "PaymentError" {
-		TXMemo = "款不足等待補款"
+		TXMemo = codeWaitingForCash
 		transaction.TXMemo = TXMemo
 		transaction.TXStatus = "PaymentError"
 	}
 	if TXStatus == "Cancelled" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		transaction.TXMemo = TXMemo
 		transaction.TXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled1" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		transaction.TXMemo = TXMemo
 		transaction.TXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled2" {
-		TXMemo = "系統錯誤"
+		TXMemo = codeSystemError
 		transaction.TXMemo = TXMemo
 		transaction.TXStatus = "Cancelled"
 	}
@@ -1546,6 +1614,112 @@ func updateQueuedTransactionStatus(stub shim.ChaincodeStubInterface, TXKEY strin
 	return nil
 }
 
+func appendQueuedTransaction(stub shim.ChaincodeStubInterface, TXKEY string, transaction Transaction) error {
+
+	queueAsBytes, err := stub.GetState(TXKEY)
+	if err != nil {
+		return err
+	}
+	queuedTX := QueuedTransaction{}
+	if queueAsBytes != nil {
+		err = json.Unmarshal(queueAsBytes, &queuedTX)
+		if err != nil {
+			return err
+		}
+	}
+	queuedTX.ObjectType = QueuedTXObjectType
+	queuedTX.TXKEY = TXKEY
+	queuedTX.Transactions = append(queuedTX.Transactions, transaction)
+	queuedTX.TXIndexs = append(queuedTX.TXIndexs, transaction.TXIndex)
+	queuedTX.TXSIndexs = append(queuedTX.TXSIndexs, transaction.TXSIndex)
+	queuedTX.TXIDs = append(queuedTX.TXIDs, transaction.TXID)
+	queueAsBytes, err = json.Marshal(queuedTX)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(TXKEY, queueAsBytes)
+}
+
+//自佇列移除交易，回傳被移除的交易
+func removeQueuedTransaction(stub shim.ChaincodeStubInterface, TXKEY string, TXID string) (*Transaction, error) {
+
+	queuedTX, err := getQueueStructFromID(stub, TXKEY)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range queuedTX.TXIDs {
+		if val != TXID {
+			continue
+		}
+		transaction := queuedTX.Transactions[key]
+		queuedTX.Transactions = append(queuedTX.Transactions[:key], queuedTX.Transactions[key+1:]...)
+		queuedTX.TXIndexs = append(queuedTX.TXIndexs[:key], queuedTX.TXIndexs[key+1:]...)
+		queuedTX.TXSIndexs = append(queuedTX.TXSIndexs[:key], queuedTX.TXSIndexs[key+1:]...)
+		queuedTX.TXIDs = append(queuedTX.TXIDs[:key], queuedTX.TXIDs[key+1:]...)
+		queueAsBytes, err := json.Marshal(queuedTX)
+		if err != nil {
+			return nil, err
+		}
+		return &transaction, stub.PutState(TXKEY, queueAsBytes)
+	}
+	return nil, errors.New("Failed to find Queued TXID " + TXID)
+}
+
+//自歷史交易移除交易，回傳其交易種類(TXKinds)
+func removeHistoryTransaction(stub shim.ChaincodeStubInterface, HTXKEY string, TXID string) (string, error) {
+
+	historyTX, err := getHistoryTransactionStructFromID(stub, HTXKEY)
+	if err != nil {
+		return "", err
+	}
+	for key, val := range historyTX.TXIDs {
+		if val != TXID {
+			continue
+		}
+		TXKinds := historyTX.TXKinds[key]
+		historyTX.Transactions = append(historyTX.Transactions[:key], historyTX.Transactions[key+1:]...)
+		historyTX.TXIndexs = append(historyTX.TXIndexs[:key], historyTX.TXIndexs[key+1:]...)
+		historyTX.TXSIndexs = append(historyTX.TXSIndexs[:key], historyTX.TXSIndexs[key+1:]...)
+		historyTX.TXIDs = append(historyTX.TXIDs[:key], historyTX.TXIDs[key+1:]...)
+		historyTX.TXStatus = append(historyTX.TXStatus[:key], historyTX.TXStatus[key+1:]...)
+		historyTX.TXKinds = append(historyTX.TXKinds[:key], historyTX.TXKinds[key+1:]...)
+		historyAsBytes, err := json.Marshal(historyTX)
+		if err != nil {
+			return "", err
+		}
+		return TXKinds, stub.PutState(HTXKEY, historyAsBytes)
+	}
+	return "", errors.New("Failed to find History TXID " + TXID)
+}
+
+func appendHistoryTransaction(stub shim.ChaincodeStubInterface, HTXKEY string, transaction Transaction, TXKinds string) error {
+
+	historyAsBytes, err := stub.GetState(HTXKEY)
+	if err != nil {
+		return err
+	}
+	historyTX := TransactionHistory{}
+	if historyAsBytes != nil {
+		err = json.Unmarshal(historyAsBytes, &historyTX)
+		if err != nil {
+			return err
+		}
+	}
+	historyTX.ObjectType = HistoryTXObjectType
+	historyTX.TXKEY = HTXKEY
+	historyTX.Transactions = append(historyTX.Transactions, transaction)
+	historyTX.TXIndexs = append(historyTX.TXIndexs, transaction.TXIndex)
+	historyTX.TXSIndexs = append(historyTX.TXSIndexs, transaction.TXSIndex)
+	historyTX.TXIDs = append(historyTX.TXIDs, transaction.TXID)
+	historyTX.TXStatus = append(historyTX.TXStatus, transaction.TXStatus)
+	historyTX.TXKinds = append(historyTX.TXKinds, TXKinds)
+	historyAsBytes, err = json.Marshal(historyTX)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(HTXKEY, historyAsBytes)
+}
+
 func updateHistoryTransactionStatus(stub shim.ChaincodeStubInterface, HTXKEY string, TXID string, TXStatus string) error {
 
 	TimeNow2 := time.Now().Format(timelayout2)
@@ -1597,23 +1771,23 @@ func updateQueuedTransactionApproveStatus(stub shim.ChaincodeStubInterface, TXKE
 	TXErrMsg = ""
 
 	if TXStatus == "Waiting4Payment" {
-		TXMemo = "等待回應"
+		TXMemo = codeWaitingForResponse
 		NewTXStatus = "Waiting4Payment"
 	}
 	if TXStatus == "PaymentError" {
-		TXMemo = "款不足等待補款"
+		TXMemo = codeWaitingForCash
 		NewTXStatus = "PaymentError"
 	}
 	if TXStatus == "Cancelled" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled1" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled2" {
-		TXMemo = "系統錯誤"
+		TXMemo = codeSystemError
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Matched" {
@@ -1693,23 +1867,23 @@ func updateHistoryTransactionApproveStatus(stub shim.ChaincodeStubInterface, HTX
 	TXErrMsg = ""
 
 	if TXStatus == "Waiting4Payment" {
-		TXMemo = "等待回應"
+		TXMemo = codeWaitingForResponse
 		NewTXStatus = "Waiting4Payment"
 	}
 	if TXStatus == "PaymentError" {
-		TXMemo = "款不足等待補款"
+		TXMemo = codeWaitingForCash
 		NewTXStatus = "PaymentError"
 	}
 	if TXStatus == "Cancelled" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled1" {
-		TXMemo = "交易取消"
+		TXMemo = codeTXRevoked
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Cancelled2" {
-		TXMemo = "系統錯誤"
+		TXMemo = codeSystemError
 		NewTXStatus = "Cancelled"
 	}
 	if TXStatus == "Matched" {
@@ -1775,8 +1949,7 @@ func updateHistoryTransactionApproveStatus(stub shim.ChaincodeStubInterface, HTX
 }
 
 func resetAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, SecurityAmount int64, Payment int64, sender string, receiver string) (int64, int64, int64, int64, error) {
-	senderAccount, err := getAccountStructFromID(stub, sender)
-	receiverAccount, err := getAccountStructFromID(stub, receiver)
+	_, err := getAccountStructFromID(stub, sender)
 	var senderBalance int64
 	var receiverBalance int64
 	var senderPendingBalance int64
@@ -1784,53 +1957,48 @@ func resetAccountBalance(stub shim.ChaincodeStubInterface, SecurityID string, Se
 	if err != nil {
 		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 	}
-
-	var doflg bool
-	doflg = false
-	for key, val := range senderAccount.Assets {
-		if val.SecurityID == SecurityID {
-			senderAccount.Assets[key].SecurityAmount -= SecurityAmount
-			senderAccount.Assets[key].Balance += Payment
-			senderAccount.Assets[key].Position += Payment
-			senderAccount.Assets[key].TotalPayment -= Payment
-			senderBalance = senderAccount.Assets[key].Balance
-			senderAccount.Assets[key].PendingBalance = senderBalance
-			senderPendingBalance = senderAccount.Assets[key].PendingBalance
-			doflg = true
-			break
-		}
+	_, err = getAccountStructFromID(stub, receiver)
+	if err != nil {
+		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 	}
-	if doflg != true {
+
+	senderPosition, err := getPositionStruct(stub, sender, SecurityID)
+	if err != nil {
 		errMsg := fmt.Sprintf(
 			"Error: This SecurityID does not exists (%s)",
 			SecurityID)
 		return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, errors.New(errMsg)
 	}
-
-	for key, val := range receiverAccount.Assets {
-		if val.SecurityID == SecurityID {
-			receiverAccount.Assets[key].SecurityAmount += SecurityAmount
-			receiverAccount.Assets[key].Balance -= Payment
-			receiverAccount.Assets[key].Position -= Payment
-			receiverAccount.Assets[key].TotalPayment += Payment
-			receiverBalance = receiverAccount.Assets[key].Balance
-			receiverAccount.Assets[key].PendingBalance = receiverBalance
-			receiverPendingBalance = receiverAccount.Assets[key].PendingBalance
-			doflg = true
-			break
-		}
+	senderPosition.SecurityAmount -= SecurityAmount
+	senderPosition.Balance += Payment
+	senderPosition.Position += Payment
+	senderPosition.TotalPayment -= Payment
+	senderBalance = senderPosition.Balance
+	senderPosition.PendingBalance = senderBalance
+	senderPendingBalance = senderPosition.PendingBalance
+
+	receiverPosition, err := getPositionStruct(stub, receiver, SecurityID)
+	var receiverflg bool
+	receiverflg = false
+	if err == nil {
+		receiverPosition.SecurityAmount += SecurityAmount
+		receiverPosition.Balance -= Payment
+		receiverPosition.Position -= Payment
+		receiverPosition.TotalPayment += Payment
+		receiverBalance = receiverPosition.Balance
+		receiverPosition.PendingBalance = receiverBalance
+		receiverPendingBalance = receiverPosition.PendingBalance
+		receiverflg = true
 	}
 
 	if senderBalance >= 0 {
-		senderAccountAsBytes, err := json.Marshal(senderAccount)
-		err = stub.PutState(sender, senderAccountAsBytes)
+		err = putPositionStruct(stub, senderPosition)
 		if err != nil {
 			return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 		}
 	}
-	if receiverBalance >= 0 {
-		receiverAccountAsBytes, err := json.Marshal(receiverAccount)
-		err = stub.PutState(receiver, receiverAccountAsBytes)
+	if receiverflg == true && receiverBalance >= 0 {
+		err = putPositionStruct(stub, receiverPosition)
 		if err != nil {
 			return senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err
 		}
@@ -1849,7 +2017,7 @@ func updateTransactionTXHcode(stub shim.ChaincodeStubInterface, TXID string, TXH
 	}
 	transaction.TXHcode = TXHcode
 	transaction.TXStatus = "Cancelled"
-	transaction.TXMemo = "交易更正"
+	transaction.TXMemo = codeTXCorrected
 	transaction.UpdateTime = TimeNow2
 
 	transactionAsBytes, err := json.Marshal(transaction)
@@ -1881,7 +2049,7 @@ func updateQueuedTransactionTXHcode(stub shim.ChaincodeStubInterface, TXKEY stri
 			fmt.Printf("4.Qval: %s\n", val)
 			queuedTX.Transactions[key].TXHcode = TXHcode
 			queuedTX.Transactions[key].TXStatus = "Cancelled"
-			queuedTX.Transactions[key].TXMemo = "交易更正"
+			queuedTX.Transactions[key].TXMemo = codeTXCorrected
 			queuedTX.Transactions[key].UpdateTime = TimeNow2
 			doflg = true
 			break
@@ -1922,7 +2090,7 @@ func updateHistoryTransactionTXHcode(stub shim.ChaincodeStubInterface, HTXKEY st
 			fmt.Printf("4.Hval: %s\n", val)
 			historyTX.Transactions[key].TXHcode = TXHcode
 			historyTX.Transactions[key].TXStatus = "Cancelled"
-			historyTX.Transactions[key].TXMemo = "交易更正"
+			historyTX.Transactions[key].TXMemo = codeTXCorrected
 			historyTX.TXStatus[key] = "Cancelled"
 			historyTX.Transactions[key].UpdateTime = TimeNow2
 			doflg = true
@@ -1947,7 +2115,7 @@ func updateHistoryTransactionTXHcode(stub shim.ChaincodeStubInterface, HTXKEY st
 func (s *SmartContract) updateQueuedTransactionHcode(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 	TXKEY := args[0]
 	TXID := args[1]
@@ -1957,7 +2125,7 @@ func (s *SmartContract) updateQueuedTransactionHcode(stub shim.ChaincodeStubInte
 	TimeNow2 := time.Now().Format(timelayout2)
 	queuedTX, err := getQueueStructFromID(stub, TXKEY)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeNotFound, err.Error())
 	}
 	var doflg bool
 	doflg = false
@@ -1970,23 +2138,23 @@ func (s *SmartContract) updateQueuedTransactionHcode(stub shim.ChaincodeStubInte
 			fmt.Printf("4.Qval: %s\n", val)
 			queuedTX.Transactions[key].TXHcode = TXHcode
 			queuedTX.Transactions[key].TXStatus = "Cancelled"
-			queuedTX.Transactions[key].TXMemo = "交易更正"
+			queuedTX.Transactions[key].TXMemo = codeTXCorrected
 			queuedTX.Transactions[key].UpdateTime = TimeNow2
 			doflg = true
 			break
 		}
 	}
 	if doflg != true {
-		return shim.Error("Failed to find Queued TXID ")
+		return errorResponse(codeNotFound, "Failed to find Queued TXID ")
 	}
 
 	queuedAsBytes, err := json.Marshal(queuedTX)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = stub.PutState(TXKEY, queuedAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	return shim.Success(queuedAsBytes)
 }
@@ -1994,7 +2162,7 @@ func (s *SmartContract) updateQueuedTransactionHcode(stub shim.ChaincodeStubInte
 func (s *SmartContract) updateHistoryTransactionHcode(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 	HTXKEY := args[0]
 	TXID := args[1]
@@ -2004,7 +2172,7 @@ func (s *SmartContract) updateHistoryTransactionHcode(stub shim.ChaincodeStubInt
 	TimeNow2 := time.Now().Format(timelayout2)
 	historyTX, err := getHistoryTransactionStructFromID(stub, HTXKEY)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeNotFound, err.Error())
 	}
 
 	var doflg bool
@@ -2018,7 +2186,7 @@ func (s *SmartContract) updateHistoryTransactionHcode(stub shim.ChaincodeStubInt
 			fmt.Printf("4.Hval: %s\n", val)
 			historyTX.Transactions[key].TXHcode = TXHcode
 			historyTX.Transactions[key].TXStatus = "Cancelled"
-			historyTX.Transactions[key].TXMemo = "交易更正"
+			historyTX.Transactions[key].TXMemo = codeTXCorrected
 			historyTX.TXStatus[key] = "Cancelled"
 			historyTX.Transactions[key].UpdateTime = TimeNow2
 			doflg = true
@@ -2026,16 +2194,16 @@ func (s *SmartContract) updateHistoryTransactionHcode(stub shim.ChaincodeStubInt
 		}
 	}
 	if doflg != true {
-		return shim.Error("Failed to find History TXID ")
+		return errorResponse(codeNotFound, "Failed to find History TXID ")
 	}
 
 	historyAsBytes, err := json.Marshal(historyTX)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = stub.PutState(HTXKEY, historyAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	return shim.Success(historyAsBytes)
 }
@@ -2045,14 +2213,18 @@ func (s *SmartContract) securityCorrectTransfer(
 	stub shim.ChaincodeStubInterface,
 	args []string) peer.Response {
 
-	TimeNow := time.Now().Format(timelayout)
-
+	//暫停期間不受理，見 Halt.go
+	if len(args) > 3 {
+		if errMsg := checkHalt(stub, args[3], args[1], args[2]); errMsg != "" {
+			return errorResponse(codeTradingHalted, errMsg)
+		}
+	}
 	newTX, isPutInQueue, errMsg := validateCorrectTransaction(stub, args)
 	if errMsg != "" {
 		//return shim.Error(err.Error())
-		newTX.TXErrMsg = errMsg
+		newTX.TXErrDetail = errMsg
 		newTX.TXStatus = "Cancelled"
-		newTX.TXMemo = "交易被取消"
+		newTX.TXMemo = codeTXCancelled
 	}
 	TXIndex := newTX.TXIndex
 	TXSIndex := newTX.TXSIndex
@@ -2071,12 +2243,15 @@ func (s *SmartContract) securityCorrectTransfer(
 	var doflg bool
 	var TXKinds string
 	doflg = false
-	TXKEY := SubString(TimeNow, 0, 8) //A0710220180326
+	TXKEY, dayErrMsg := getBookingBusinessDate(stub, TXID) //A0710220180326
+	if dayErrMsg != "" {
+		return errorResponse(codeBusinessDayClosed, dayErrMsg)
+	}
 	HTXKEY := "H" + TXKEY
-	TXDAY := SubString(TXID, 18, 8)
-	if TXDAY < TXKEY {
-		TXKEY = TXDAY
-		HTXKEY = "H" + TXKEY
+	//更正交易沿用原交易的交割日，已過期者同交易日
+	newTX.TradeDate = TXKEY
+	if newTX.SettlementDate < TXKEY {
+		newTX.SettlementDate = TXKEY
 	}
 	if BankFrom != BankTo {
 		if SecurityAmount == 0 {
@@ -2121,9 +2296,9 @@ func (s *SmartContract) securityCorrectTransfer(
 		queueAsBytes, err := stub.GetState(TXKEY)
 		if err != nil {
 			//return shim.Error(err.Error())
-			newTX.TXErrMsg = TXKEY + ":QueueID does not exits."
+			newTX.TXErrMsg = codeQueueNotFound
 			newTX.TXStatus = "Cancelled"
-			newTX.TXMemo = "交易被取消"
+			newTX.TXMemo = codeTXCancelled
 		}
 		queuedTx := QueuedTransaction{}
 		json.Unmarshal(queueAsBytes, &queuedTx)
@@ -2132,9 +2307,9 @@ func (s *SmartContract) securityCorrectTransfer(
 		historyAsBytes, err := stub.GetState(HTXKEY)
 		if err != nil {
 			//return shim.Error(err.Error())
-			newTX.TXErrMsg = TXKEY + ":HistoryID does not exits."
+			newTX.TXErrMsg = codeQueueNotFound
 			newTX.TXStatus = "Cancelled"
-			newTX.TXMemo = "交易被取消"
+			newTX.TXMemo = codeTXCancelled
 		}
 		historyNewTX := TransactionHistory{}
 		json.Unmarshal(historyAsBytes, &historyNewTX)
@@ -2164,8 +2339,10 @@ func (s *SmartContract) securityCorrectTransfer(
 				historyNewTX.TXKinds = append(historyNewTX.TXKinds, TXKinds)
 			}
 		} else if queueAsBytes != nil {
-			for key, val := range queuedTx.Transactions {
-				if val.TXIndex == TXIndex && val.TXStatus == TXStatus && val.TXFrom != TXFrom && val.TXType != TXType && val.TXID != TXID {
+			//依優先順序、再依進入佇列先後比對
+			for _, key := range getQueueOrder(queuedTx.Transactions) {
+				val := queuedTx.Transactions[key]
+				if isMatchingInstruction(newTX, val) && val.TXStatus == TXStatus {
 					fmt.Println("1.TXIndex= " + TXIndex + "\n")
 					fmt.Println("2.TXFrom= " + TXFrom + "\n")
 					fmt.Println("3.TXType= " + TXType + "\n")
@@ -2177,9 +2354,9 @@ func (s *SmartContract) securityCorrectTransfer(
 					if TXStatus == "Pending" && val.TXStatus == "Pending" {
 						if doflg == true {
 							//return shim.Error("doflg eq to true")
-							newTX.TXErrMsg = "doflg can not equle to true."
+							newTX.TXErrMsg = codeDuplicateMatch
 							newTX.TXStatus = "Cancelled"
-							newTX.TXMemo = "交易被取消"
+							newTX.TXMemo = codeTXCancelled
 							break
 						}
 						newTX.MatchedTXID = val.TXID
@@ -2188,9 +2365,9 @@ func (s *SmartContract) securityCorrectTransfer(
 						err = updateTransactionStatus(stub, val.TXID, "Matched", TXID)
 						if err != nil {
 							//return shim.Error(err.Error())
-							newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Matched."
+							newTX.TXErrMsg = codeStateError
 							newTX.TXStatus = "Cancelled"
-							newTX.TXMemo = "交易被取消"
+							newTX.TXMemo = codeTXCancelled
 							break
 						}
 						queuedTx.Transactions[key].TXStatus = "Matched"
@@ -2200,6 +2377,25 @@ func (s *SmartContract) securityCorrectTransfer(
 						queuedTx.Transactions[key].TXMemo = ""
 						historyNewTX.Transactions[key].TXMemo = ""
 						newTX.TXMemo = ""
+						//交割日在後之交易維持 Matched，至交割日由 openBusinessDay 或 runSettlementCycle 交割
+						if newTX.SettlementDate > TXKEY {
+							SellerTXID := TXID
+							if TXType != "S" {
+								SellerTXID = val.TXID
+							}
+							err = putSettlementDue(stub, newTX.SettlementDate, SellerTXID)
+							if err != nil {
+								newTX.TXErrMsg = codeStateError
+								newTX.TXStatus = "Cancelled"
+								newTX.TXMemo = codeTXCancelled
+								break
+							}
+							queuedTx.Transactions[key].TXMemo = codeWaitingSettlementDate
+							historyNewTX.Transactions[key].TXMemo = codeWaitingSettlementDate
+							newTX.TXMemo = codeWaitingSettlementDate
+							doflg = true
+							break
+						}
 						if TXType == "S" {
 							//轉出          轉入
 							senderBalance, receiverBalance, senderPendingBalance, receiverPendingBalance, err := updateAccountBalance(stub, SecurityID, SecurityAmount, Payment, TXFrom, TXTo)
@@ -2208,25 +2404,25 @@ func (s *SmartContract) securityCorrectTransfer(
 								err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, true)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals TXFrom:" + TXFrom
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 								err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, false)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals2 TXTo:" + TXTo
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 							}
 							if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
 								//return shim.Error("TxType=S - senderBalance or receiverBalance <0")
-								newTX.TXErrMsg = "TxType=S - senderBalance or receiverBalance or senderPendingBalance or receiverPendingBalance <0"
+								newTX.TXErrMsg = codeNegativeBalance
 								newTX.TXStatus = "Cancelled"
-								newTX.TXMemo = "交易被取消"
+								newTX.TXMemo = codeTXCancelled
 								break
 							}
 
@@ -2239,25 +2435,25 @@ func (s *SmartContract) securityCorrectTransfer(
 								err = updateBankTotals(stub, TXFrom, SecurityID, TXFrom, Payment, SecurityAmount, false)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals TXFrom:" + TXFrom
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 								err = updateBankTotals(stub, TXTo, SecurityID, TXTo, Payment, SecurityAmount, true)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateBankTotals2 TXTo:" + TXTo
+									newTX.TXErrMsg = codeStateError
 									newTX.TXStatus = "Cancelled"
-									newTX.TXMemo = "交易被取消"
+									newTX.TXMemo = codeTXCancelled
 									break
 								}
 							}
 							if (senderBalance < 0) || (receiverBalance < 0) || (senderPendingBalance < 0) || (receiverPendingBalance < 0) {
 								//return shim.Error("TxType=B - senderBalance or receiverBalance <0")
-								newTX.TXErrMsg = "TxType=B - senderBalance or receiverBalance or senderPendingBalance or receiverPendingBalance <0"
+								newTX.TXErrMsg = codeNegativeBalance
 								newTX.TXStatus = "Cancelled"
-								newTX.TXMemo = "交易被取消"
+								newTX.TXMemo = codeTXCancelled
 								break
 							}
 
@@ -2278,7 +2474,7 @@ func (s *SmartContract) securityCorrectTransfer(
 									err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
@@ -2287,18 +2483,18 @@ func (s *SmartContract) securityCorrectTransfer(
 									historyNewTX.Transactions[key].TXStatus = "PaymentError"
 									historyNewTX.TXStatus[key] = "PaymentError"
 									newTX.TXStatus = "PaymentError"
-									queuedTx.Transactions[key].TXMemo = "款不足等待補款"
-									historyNewTX.Transactions[key].TXMemo = "款不足等待補款"
-									newTX.TXMemo = "款不足等待補款"
+									queuedTx.Transactions[key].TXMemo = codeWaitingForCash
+									historyNewTX.Transactions[key].TXMemo = codeWaitingForCash
+									newTX.TXMemo = codeWaitingForCash
 									err := updateTransactionStatus(stub, val.TXID, "PaymentError", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = PaymentError."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
 								} else if ApproveFlag == approved5 {
-									_, _, securityamount, _, _ := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
+									_, _, securityamount, _, _, _ := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, -1)
 									fmt.Printf("1-1.Account securityamount=%s\n", securityamount)
 									fmt.Printf("1-2.Transaction SecurityAmount=%s\n", SecurityAmount)
 									fmt.Printf("1-3.Approved errMsg=%s\n", errMsg)
@@ -2307,13 +2503,13 @@ func (s *SmartContract) securityCorrectTransfer(
 										historyNewTX.Transactions[key].TXStatus = "PaymentError"
 										historyNewTX.TXStatus[key] = "PaymentError"
 										newTX.TXStatus = "PaymentError"
-										queuedTx.Transactions[key].TXMemo = "款不足等待補款"
-										historyNewTX.Transactions[key].TXMemo = "款不足等待補款"
-										newTX.TXMemo = "款不足等待補款"
+										queuedTx.Transactions[key].TXMemo = codeWaitingForCash
+										historyNewTX.Transactions[key].TXMemo = codeWaitingForCash
+										newTX.TXMemo = codeWaitingForCash
 										err := updateTransactionStatus(stub, val.TXID, "PaymentError", TXID)
 										if err != nil {
 											//return shim.Error(err.Error())
-											newTX.TXErrMsg = "Failed to execute updateTransactionStatus = PaymentError."
+											newTX.TXErrMsg = codeStateError
 											//newTX.TXStatus = "Cancelled"
 											break
 										}
@@ -2328,7 +2524,7 @@ func (s *SmartContract) securityCorrectTransfer(
 										err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 										if err != nil {
 											//return shim.Error(err.Error())
-											newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+											newTX.TXErrMsg = codeStateError
 											//newTX.TXStatus = "Cancelled"
 											break
 										}
@@ -2338,13 +2534,13 @@ func (s *SmartContract) securityCorrectTransfer(
 									historyNewTX.Transactions[key].TXStatus = "Waiting4Payment"
 									historyNewTX.TXStatus[key] = "Waiting4Payment"
 									newTX.TXStatus = "Waiting4Payment"
-									queuedTx.Transactions[key].TXMemo = "等待回應"
-									historyNewTX.Transactions[key].TXMemo = "等待回應"
-									newTX.TXMemo = "等待回應"
+									queuedTx.Transactions[key].TXMemo = codeWaitingForResponse
+									historyNewTX.Transactions[key].TXMemo = codeWaitingForResponse
+									newTX.TXMemo = codeWaitingForResponse
 									err := updateTransactionStatus(stub, val.TXID, "Waiting4Payment", TXID)
 									if err != nil {
 										//return shim.Error(err.Error())
-										newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Waiting4Payment."
+										newTX.TXErrMsg = codeStateError
 										//newTX.TXStatus = "Cancelled"
 										break
 									}
@@ -2360,7 +2556,7 @@ func (s *SmartContract) securityCorrectTransfer(
 								err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 								if err != nil {
 									//return shim.Error(err.Error())
-									newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+									newTX.TXErrMsg = codeStateError
 									//newTX.TXStatus = "Cancelled"
 									break
 								}
@@ -2376,7 +2572,7 @@ func (s *SmartContract) securityCorrectTransfer(
 							err := updateTransactionStatus(stub, val.TXID, "Finished", TXID)
 							if err != nil {
 								//return shim.Error(err.Error())
-								newTX.TXErrMsg = "Failed to execute updateTransactionStatus = Finished."
+								newTX.TXErrMsg = codeStateError
 								//newTX.TXStatus = "Cancelled"
 								break
 							}
@@ -2387,19 +2583,19 @@ func (s *SmartContract) securityCorrectTransfer(
 					}
 				} else {
 					fmt.Println("1.TXSIndex= " + TXSIndex + "\n")
-					if val.TXSIndex == TXSIndex && val.TXStatus == TXStatus && val.TXIndex != TXIndex && val.TXFrom != TXFrom && val.TXType != TXType && val.TXID != TXID {
+					if isNearMatchInstruction(newTX, val) && val.TXStatus == TXStatus {
 						if TXStatus == "Pending" && val.TXStatus == "Pending" {
 							if (SecurityAmount != val.SecurityAmount) && (Payment == val.Payment) {
 								if SecurityAmount != val.SecurityAmount {
 									newTX.MatchedTXID = val.TXID
 									queuedTx.Transactions[key].MatchedTXID = TXID
 									historyNewTX.Transactions[key].MatchedTXID = TXID
-									newTX.TXMemo = "交易金額疑輸錯"
-									queuedTx.Transactions[key].TXMemo = "交易金額疑輸錯"
-									historyNewTX.Transactions[key].TXMemo = "交易金額疑輸錯"
-									newTX.TXErrMsg = "SecurityAmount != val.SecurityAmount"
-									queuedTx.Transactions[key].TXErrMsg = "SecurityAmount != val.SecurityAmount"
-									historyNewTX.Transactions[key].TXErrMsg = "SecurityAmount != val.SecurityAmount"
+									newTX.TXMemo = codeAmountMismatch
+									queuedTx.Transactions[key].TXMemo = codeAmountMismatch
+									historyNewTX.Transactions[key].TXMemo = codeAmountMismatch
+									newTX.TXErrMsg = codeAmountMismatch
+									queuedTx.Transactions[key].TXErrMsg = codeAmountMismatch
+									historyNewTX.Transactions[key].TXErrMsg = codeAmountMismatch
 								}
 							}
 							if (SecurityAmount == val.SecurityAmount) && (Payment != val.Payment) {
@@ -2407,36 +2603,36 @@ func (s *SmartContract) securityCorrectTransfer(
 									newTX.MatchedTXID = val.TXID
 									queuedTx.Transactions[key].MatchedTXID = TXID
 									historyNewTX.Transactions[key].MatchedTXID = TXID
-									newTX.TXMemo = "交易面額疑輸錯"
-									queuedTx.Transactions[key].TXMemo = "交易面額疑輸錯"
-									historyNewTX.Transactions[key].TXMemo = "交易面額疑輸錯"
-									newTX.TXErrMsg = "Payment != val.Payment"
-									queuedTx.Transactions[key].TXErrMsg = "Payment != val.Payment"
-									historyNewTX.Transactions[key].TXErrMsg = "Payment != val.Payment"
+									newTX.TXMemo = codeFaceValueMismatch
+									queuedTx.Transactions[key].TXMemo = codeFaceValueMismatch
+									historyNewTX.Transactions[key].TXMemo = codeFaceValueMismatch
+									newTX.TXErrMsg = codeFaceValueMismatch
+									queuedTx.Transactions[key].TXErrMsg = codeFaceValueMismatch
+									historyNewTX.Transactions[key].TXErrMsg = codeFaceValueMismatch
 								}
 							}
 						}
-						if val.TXMemo == "轉出方券不足" && val.TXType == "S" {
+						if val.TXMemo == codeInsufficientSecurities && val.TXType == "S" {
 							newTX.MatchedTXID = val.TXID
 							queuedTx.Transactions[key].MatchedTXID = TXID
 							historyNewTX.Transactions[key].MatchedTXID = TXID
-							newTX.TXMemo = "轉出方券不足"
-							queuedTx.Transactions[key].TXMemo = "轉出方券不足"
-							historyNewTX.Transactions[key].TXMemo = "轉出方券不足"
-							newTX.TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							queuedTx.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							historyNewTX.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
+							newTX.TXMemo = codeInsufficientSecurities
+							queuedTx.Transactions[key].TXMemo = codeInsufficientSecurities
+							historyNewTX.Transactions[key].TXMemo = codeInsufficientSecurities
+							newTX.TXErrMsg = val.TXErrMsg
+							queuedTx.Transactions[key].TXErrMsg = val.TXErrMsg
+							historyNewTX.Transactions[key].TXErrMsg = val.TXErrMsg
 						}
-						if val.TXMemo == "轉入方款不足" && val.TXType == "B" {
+						if val.TXMemo == codeInsufficientCash && val.TXType == "B" {
 							newTX.MatchedTXID = val.TXID
 							queuedTx.Transactions[key].MatchedTXID = TXID
 							historyNewTX.Transactions[key].MatchedTXID = TXID
-							newTX.TXMemo = "轉入方款不足"
-							queuedTx.Transactions[key].TXMemo = "轉入方款不足"
-							historyNewTX.Transactions[key].TXMemo = "轉入方款不足"
-							newTX.TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							queuedTx.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
-							historyNewTX.Transactions[key].TXErrMsg = val.TXFrom + ":" + val.TXErrMsg
+							newTX.TXMemo = codeInsufficientCash
+							queuedTx.Transactions[key].TXMemo = codeInsufficientCash
+							historyNewTX.Transactions[key].TXMemo = codeInsufficientCash
+							newTX.TXErrMsg = val.TXErrMsg
+							queuedTx.Transactions[key].TXErrMsg = val.TXErrMsg
+							historyNewTX.Transactions[key].TXErrMsg = val.TXErrMsg
 						}
 					}
 				}
@@ -2464,21 +2660,28 @@ func (s *SmartContract) securityCorrectTransfer(
 		QueuedAsBytes, err := json.Marshal(queuedTx)
 		err = stub.PutState(TXKEY, QueuedAsBytes)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
 		}
 		historyAsBytes, err = json.Marshal(historyNewTX)
 		err = stub.PutState(HTXKEY, historyAsBytes)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(codeStateError, err.Error())
+		}
+	}
+	//尚未比對的交易寫入比對索引，供之後營業日跨日比對
+	if newTX.TXStatus == "Pending" {
+		err = putMatchIndex(stub, TXKEY, newTX)
+		if err != nil {
+			return errorResponse(codeStateError, err.Error())
 		}
 	}
 	TransactionAsBytes, err := json.Marshal(newTX)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 	err = stub.PutState(TXID, TransactionAsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(codeStateError, err.Error())
 	}
 
 	return shim.Success(nil)
@@ -2497,7 +2700,7 @@ func validateCorrectTransaction(
 	transaction := Transaction{}
 	transaction.ObjectType = TransactionObjectType
 	transaction.TXStatus = "Cancelled"
-	transaction.TXMemo = "交易更正"
+	transaction.TXMemo = codeTXCorrected
 	transaction.TXErrMsg = ""
 	transaction.TXHcode = ""
 	transaction.IsFrozen = false
@@ -2507,44 +2710,56 @@ func validateCorrectTransaction(
 
 	err = checkArgArrayLength(args, 8)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentCount
 		return transaction, false, "The args-length must be 8."
 	}
 	if len(args[0]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXType must be a non-empty string."
 	}
 	if len(args[1]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXFrom must be a non-empty string."
 	}
 	if len(args[2]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXTo must be a non-empty string."
 	}
 	if len(args[3]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "SecurityID must be a non-empty string."
 	}
 	if len(args[4]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "SecurityAmount must be a non-empty string."
 	}
 	if len(args[5]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "Payment must be a non-empty string."
 	}
 	if len(args[6]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "isPutToQueue flag must be a non-empty string."
 	}
 	if len(args[7]) <= 0 {
+		transaction.TXErrMsg = codeArgumentEmpty
 		return transaction, false, "TXID flag must be a non-empty string."
 	}
 
 	TXID = strings.ToUpper(args[7])
 	sourceTX, err := getTransactionStructFromID(stub, TXID)
 	if sourceTX.TXStatus != "Pending" {
+		transaction.TXErrMsg = codeInvalidStatus
 		return transaction, false, "Failed to find Transaction Pending TXStatus."
 	}
 	if sourceTX.TXStatus == "Cancelled" {
+		transaction.TXErrMsg = codeInvalidStatus
 		return transaction, false, "TXStatus of transaction was Cancelled. TXHcode:" + sourceTX.TXHcode
 	}
 
 	TXType := args[0]
 	if (TXType != "B") && (TXType != "S") {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "TXType must be a B or S."
 	}
 	transaction.TXType = TXType
@@ -2558,41 +2773,61 @@ func validateCorrectTransaction(
 	BankTo := "BK" + SubString(TXTo, 0, 3)
 	transaction.TXTo = TXTo
 	transaction.BankTo = BankTo
+	transaction.TXPriority = getDefaultTXPriority(BankFrom, BankTo)
+	if sourceTX.TXPriority > 0 {
+		transaction.TXPriority = sourceTX.TXPriority
+	}
+	transaction.SettlementDate = sourceTX.SettlementDate
+	transaction.TradeRef = sourceTX.TradeRef
 	if TXFrom == TXTo {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "TXFrom equal to TXTo."
 	}
 	BankFromID := "BANK" + SubString(TXFrom, 0, 3)
 	if verifyIdentity(stub, BankFromID) != "" {
+		transaction.TXErrMsg = codeUnauthorized
 		return transaction, false, "BankFromID does not exits in the BankList."
 	}
 	SecurityID := strings.ToUpper(args[3])
-	_, err = getSecurityStructFromID(stub, SecurityID)
+	security, err := getSecurityStructFromID(stub, SecurityID)
 	if err != nil {
+		transaction.TXErrMsg = codeNotFound
 		return transaction, false, "SecurityID does not exits."
 	}
+	if errMsg := checkSecurityStatus(security, securityOpTransfer); errMsg != "" {
+		transaction.TXErrMsg = codeSecurityStatusNotAllowed
+		return transaction, false, errMsg
+	}
 	transaction.SecurityID = SecurityID
 	SecurityAmount, err := strconv.ParseInt(args[4], 10, 64)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "SecurityAmount must be a numeric string."
 	} else if SecurityAmount < 0 {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "SecurityAmount must be a positive value."
 	}
 	transaction.SecurityAmount = SecurityAmount
 	Payment, err := strconv.ParseInt(args[5], 10, 64)
 	if err != nil {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "Payment must be a numeric string."
 	} else if Payment < 0 {
+		transaction.TXErrMsg = codeArgumentFormat
 		return transaction, false, "Payment must be a positive value."
 	}
 	transaction.Payment = Payment
-	senderPendingBalance, receiverPendingBalance, errMsg := updateAccountPendingBalance(stub, SecurityID, Payment, TXFrom, TXTo)
+	senderPendingBalance, receiverPendingBalance, errCode, errMsg := updateAccountPendingBalance(stub, SecurityID, Payment, TXFrom, TXTo)
 	if errMsg != "" {
+		transaction.TXErrMsg = errCode
 		return transaction, true, errMsg
 	}
 	if senderPendingBalance <= 0 {
+		transaction.TXErrMsg = codeInsufficientSecurities
 		return transaction, true, "senderPendingBalance less equle to zero."
 	}
 	if receiverPendingBalance <= 0 {
+		transaction.TXErrMsg = codeInsufficientSecurities
 		return transaction, true, "receiverPendingBalance less equle to zero."
 	}
 
@@ -2614,14 +2849,15 @@ func validateCorrectTransaction(
 
 	transaction.TXIndex = TXIndex
 	transaction.TXSIndex = TXSIndex
-	balance, position, securityamount, pendingbalance, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, senderPendingBalance)
+	balance, position, securityamount, pendingbalance, errCode, errMsg := checkAccountBalance(stub, SecurityID, Payment, SecurityAmount, TXFrom, TXType, senderPendingBalance)
 	transaction.TXFromBalance = balance
 	transaction.TXFromPosition = position
 	transaction.TXFromAmount = securityamount
 	if errMsg != "" && TXType == "S" {
-		transaction.TXMemo = "轉出方券不足"
+		transaction.TXMemo = codeInsufficientSecurities
 		//transaction.TXErrMsg = TXFrom + ":Payment > Balance"
-		transaction.TXErrMsg = errMsg
+		transaction.TXErrMsg = errCode
+		transaction.TXErrDetail = errMsg
 		fmt.Printf("Payment: %s\n", Payment)
 		fmt.Printf("balance: %s\n", balance)
 		fmt.Printf("position: %s\n", position)
@@ -2630,9 +2866,10 @@ func validateCorrectTransaction(
 		return transaction, true, errMsg
 	}
 	if errMsg != "" && TXType == "B" {
-		transaction.TXMemo = "轉入方款不足"
+		transaction.TXMemo = codeInsufficientCash
 		//transaction.TXErrMsg = TXFrom + ":Payment > Balance"
-		transaction.TXErrMsg = errMsg
+		transaction.TXErrMsg = errCode
+		transaction.TXErrDetail = errMsg
 		fmt.Printf("Payment: %s\n", Payment)
 		fmt.Printf("balance: %s\n", balance)
 		fmt.Printf("position: %s\n", position)
@@ -2647,8 +2884,8 @@ func validateCorrectTransaction(
 	err2 := updateTransactionTXHcode(stub, TXID, TXHcode)
 	if err2 != nil {
 		//return transaction, false, err2
-		transaction.TXMemo = "更正失敗"
-		transaction.TXErrMsg = TXID + ":updateTransactionTXHcode execution failed."
+		transaction.TXMemo = codeCorrectionFailed
+		transaction.TXErrMsg = codeStateError
 		return transaction, false, TXID + ":updateTransactionTXHcode execution failed."
 	}
 
@@ -2667,13 +2904,13 @@ func updateEndDayTransactionStatus(stub shim.ChaincodeStubInterface, TXID string
 	TXStatus := transaction.TXStatus
 	TXMemo := ""
 	if TXStatus == "Waiting4Payment" {
-		TXMemo = "日終交易取消"
+		TXMemo = codeEndOfDayCancelled
 	}
 	if TXStatus == "PaymentError" {
-		TXMemo = "款不足"
+		TXMemo = codeCashShortfall
 	}
 	if TXStatus == "Pending" {
-		TXMemo = "尚未比對"
+		TXMemo = codeNotMatched
 	}
 
 	transaction.TXStatus = "Cancelled"
@@ -2772,13 +3009,13 @@ func updateEndDayQueuedTransactionStatus(stub shim.ChaincodeStubInterface, TXKEY
 		TXStatus := queuedTX.Transactions[key].TXStatus
 		TXMemo := ""
 		if TXStatus == "Waiting4Payment" {
-			TXMemo = "日終交易取消"
+			TXMemo = codeEndOfDayCancelled
 		}
 		if TXStatus == "PaymentError" {
-			TXMemo = "款不足"
+			TXMemo = codeCashShortfall
 		}
 		if TXStatus == "Pending" {
-			TXMemo = "尚未比對"
+			TXMemo = codeNotMatched
 		}
 
 		if val == TXID {
@@ -2837,13 +3074,13 @@ func updateEndDayHistoryTransactionStatus(stub shim.ChaincodeStubInterface, HTXK
 		TXStatus := historyTX.Transactions[key].TXStatus
 		TXMemo := ""
 		if TXStatus == "Waiting4Payment" {
-			TXMemo = "日終交易取消"
+			TXMemo = codeEndOfDayCancelled
 		}
 		if TXStatus == "PaymentError" {
-			TXMemo = "款不足"
+			TXMemo = codeCashShortfall
 		}
 		if TXStatus == "Pending" {
-			TXMemo = "尚未比對"
+			TXMemo = codeNotMatched
 		}
 
 		if val == TXID {
@@ -2890,19 +3127,17 @@ func updateEndDayHistoryTransactionStatus(stub shim.ChaincodeStubInterface, HTXK
 func (s *SmartContract) queryTXIDTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	NewTXAsBytes, _ := APIstub.GetState(args[0])
+	if NewTXAsBytes == nil {
+		return errorResponse(codeNotFound, "Failed to find Transaction "+args[0])
+	}
 	NewTX := Transaction{}
 	json.Unmarshal(NewTXAsBytes, &NewTX)
 
-	NewTXAsBytes, err := json.Marshal(NewTX)
-	if err != nil {
-		return shim.Error("Failed to query NewTX state")
-	}
-
-	return shim.Success(NewTXAsBytes)
+	return dataResponse(NewTX)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryTXKEYTransactions", "20180408"]}' -C myc
@@ -2910,38 +3145,34 @@ func (s *SmartContract) queryTXIDTransactions(APIstub shim.ChaincodeStubInterfac
 func (s *SmartContract) queryTXKEYTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	QueuedTXAsBytes, _ := APIstub.GetState(args[0])
+	if QueuedTXAsBytes == nil {
+		return errorResponse(codeQueueNotFound, "Failed to find QueuedTransaction "+args[0])
+	}
 	QueuedTX := QueuedTransaction{}
 	json.Unmarshal(QueuedTXAsBytes, &QueuedTX)
 
-	QueuedTXAsBytes, err := json.Marshal(QueuedTX.Transactions)
-	if err != nil {
-		return shim.Error("Failed to query QueuedTX state")
-	}
-
-	return shim.Success(QueuedTXAsBytes)
+	return dataResponse(QueuedTX.Transactions)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryHistoryTXKEYTransactions", "H20180408"]}' -C myc
 func (s *SmartContract) queryHistoryTXKEYTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	HistoryNewTXAsBytes, _ := APIstub.GetState(args[0])
+	if HistoryNewTXAsBytes == nil {
+		return errorResponse(codeQueueNotFound, "Failed to find HistoryTransaction "+args[0])
+	}
 	HistoryNewTX := TransactionHistory{}
 	json.Unmarshal(HistoryNewTXAsBytes, &HistoryNewTX)
 
-	HistoryNewTXAsBytes, err := json.Marshal(HistoryNewTX.Transactions)
-	if err != nil {
-		return shim.Error("Failed to query HistoryNewTX state")
-	}
-
-	return shim.Success(HistoryNewTXAsBytes)
+	return dataResponse(HistoryNewTX.Transactions)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryForTransaction", "BANK002B00200000000120180408050918"]}' -C myc
@@ -2949,73 +3180,21 @@ func (s *SmartContract) queryHistoryTXKEYTransactions(APIstub shim.ChaincodeStub
 func (s *SmartContract) getHistoryForTransaction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	TXID := args[0]
 
 	fmt.Printf("- start getHistoryForTransaction: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(TXID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForTransaction returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, TXID, "")
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryTXIDForTransaction","BK004S00400000000120180610041355","9476d9983cd1914d0c041b810d99dbbeee9f710bd03ee73ba71ff6770dc34b7a"]}' -C myc
 func (s *SmartContract) getHistoryTXIDForTransaction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	TransactionID := args[0]
@@ -3024,56 +3203,7 @@ func (s *SmartContract) getHistoryTXIDForTransaction(APIstub shim.ChaincodeStubI
 	fmt.Printf("- start getHistoryTXIDForTransaction: %s\n", TransactionID)
 	fmt.Printf("- start getHistoryTXIDForTransaction: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(TransactionID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		if response.TxId == TXID {
-			buffer.WriteString("{\"TxId\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(response.TxId)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Value\":")
-			// if it was a delete operation on given key, then we need to set the
-			//corresponding value null. Else, we will write the response.Value
-			//as-is (as the Value itself a JSON marble)
-			if response.IsDelete {
-				buffer.WriteString("null")
-			} else {
-				buffer.WriteString(string(response.Value))
-			}
-
-			buffer.WriteString(", \"Timestamp\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-			buffer.WriteString("\"")
-
-			buffer.WriteString(", \"IsDelete\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatBool(response.IsDelete))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-
-			break
-		}
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryTXIDForTransaction returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, TransactionID, TXID)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryForQueuedTransaction", "H20180415"]}' -C myc
@@ -3081,73 +3211,21 @@ func (s *SmartContract) getHistoryTXIDForTransaction(APIstub shim.ChaincodeStubI
 func (s *SmartContract) getHistoryForQueuedTransaction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 1")
 	}
 
 	TXKEY := args[0]
 
 	fmt.Printf("- start getHistoryForQueuedTransaction: %s\n", TXKEY)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(TXKEY)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForQueuedTransaction returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, TXKEY, "")
 }
 
 //peer chaincode query -n mycc -c '{"Args":["getHistoryTXIDForQueuedTransaction","20180610","a4723f60d5c85d29a2107382fb8e3c8c1624924b970efa04f313727a0dfaa0ff"]}' -C myc
 func (s *SmartContract) getHistoryTXIDForQueuedTransaction(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 2")
 	}
 
 	QueuedTransactionID := args[0]
@@ -3156,212 +3234,40 @@ func (s *SmartContract) getHistoryTXIDForQueuedTransaction(APIstub shim.Chaincod
 	fmt.Printf("- start getHistoryTXIDForQueuedTransaction: %s\n", QueuedTransactionID)
 	fmt.Printf("- start getHistoryTXIDForQueuedTransaction: %s\n", TXID)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(QueuedTransactionID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		if response.TxId == TXID {
-			buffer.WriteString("{\"TxId\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(response.TxId)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Value\":")
-			// if it was a delete operation on given key, then we need to set the
-			//corresponding value null. Else, we will write the response.Value
-			//as-is (as the Value itself a JSON marble)
-			if response.IsDelete {
-				buffer.WriteString("null")
-			} else {
-				buffer.WriteString(string(response.Value))
-			}
-
-			buffer.WriteString(", \"Timestamp\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-			buffer.WriteString("\"")
-
-			buffer.WriteString(", \"IsDelete\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatBool(response.IsDelete))
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-
-			break
-		}
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryTXIDForQueuedTransaction returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getHistoryResponse(APIstub, QueuedTransactionID, TXID)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryAllTransactions", "BANK002B00200000000120180408050918","BANK002B00200000000120180408051246"]}' -C myc
 
 func (s *SmartContract) queryAllTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
 	//TXID = BankFrom + TXType + TXFrom + TimeNow
 	//BANK002B00200000000120180406143001
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as/is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryAllQueuedTransactions", "20180408","20180409"]}' -C myc
 
 func (s *SmartContract) queryAllQueuedTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
 	//TXKEY = SubString(TimeNow,0,8)
 	//20180406
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as/is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryAllHistoryTransactions", "20180415","20180416"]}' -C myc -v 1.0
 func (s *SmartContract) queryAllHistoryTransactions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
 	//TXKEY = SubString(TimeNow,0,8)
 	//20180406
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as/is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
-	}
-	buffer.WriteString("]")
-
-	fmt.Printf("%s", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return getRangeResponse(APIstub, args)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryAllTransactionKeys", "BANK002" , "BANK009"]}' -C myc
 func (s *SmartContract) queryAllTransactionKeys(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 2 {
-		return shim.Error("Keys operation must include two arguments, startKey and endKey")
+		return errorResponse(codeArgumentCount, "Keys operation must include two arguments, startKey and endKey")
 	}
 	startKey := args[0]
 	endKey := args[1]
@@ -3374,11 +3280,11 @@ func (s *SmartContract) queryAllTransactionKeys(APIstub shim.ChaincodeStubInterf
 
 	keysIter, err := APIstub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+		return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 	}
 	defer keysIter.Close()
 
-	var keys []string
+	keys := []string{}
 	for keysIter.HasNext() {
 		//if sleeptime is specied, take a nap
 		if stime > 0 {
@@ -3387,7 +3293,7 @@ func (s *SmartContract) queryAllTransactionKeys(APIstub shim.ChaincodeStubInterf
 
 		response, iterErr := keysIter.Next()
 		if iterErr != nil {
-			return shim.Error(fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
+			return errorResponse(codeStateError, fmt.Sprintf("keys operation failed. Error accessing state: %s", err))
 		}
 		keys = append(keys, response.Key)
 	}
@@ -3396,20 +3302,14 @@ func (s *SmartContract) queryAllTransactionKeys(APIstub shim.ChaincodeStubInterf
 		fmt.Printf("key %d contains %s\n", key, value)
 	}
 
-	jsonKeys, err := json.Marshal(keys)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("keys operation failed. Error marshaling JSON: %s", err))
-	}
-
-	return shim.Success(jsonKeys)
-
+	return envelopeResponse(keys, &Pagination{StartKey: startKey, EndKey: endKey, Count: len(keys)}, nil)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryQueuedTransactionStatus","20180609","Finished"]}' -C myc
 func (s *SmartContract) queryQueuedTransactionStatus(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 	TXKEY := args[0]
 	TXStatus := args[1]
@@ -3419,120 +3319,24 @@ func (s *SmartContract) queryQueuedTransactionStatus(APIstub shim.ChaincodeStubI
 	QueuedTX := QueuedTransaction{}
 	json.Unmarshal(QueuedAsBytes, &QueuedTX)
 
-	var doflg bool
-	doflg = false
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"TXKEY\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(QueuedTX.TXKEY)
-	buffer.WriteString("\"")
-	buffer.WriteString(",\"Transactions\":[")
-	bArrayMemberAlreadyWritten := false
+	QueuedStatus := QueuedTransactionStatus{TXKEY: QueuedTX.TXKEY, Transactions: []QueuedTransactionRecord{}}
 	for key, val := range QueuedTX.Transactions {
 		if (val.TXStatus == TXStatus || TXStatus == "All") && (val.BankFrom == BankID || val.BankTo == BankID || BankID == "All") {
-			// Add a comma before array members, suppress it for the first array member
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			buffer.WriteString("{\"QueuedKey\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(key + 1))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXType\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXType)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXFrom\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXFrom)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXTo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXTo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"BankFrom\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].BankFrom)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"BankTo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].BankTo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].SecurityID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityAmount\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(QueuedTX.Transactions[key].SecurityAmount, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Payment\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(QueuedTX.Transactions[key].Payment, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXStatus\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXStatus)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXMemo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXMemo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXErrMsg\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXErrMsg)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXHcode\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXHcode)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"MatchedTXID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].MatchedTXID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"CreateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].CreateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"UpdateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].UpdateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXIndex\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXIndex)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXSIndex\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(QueuedTX.Transactions[key].TXSIndex)
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
-			doflg = true
+			QueuedStatus.Transactions = append(QueuedStatus.Transactions, QueuedTransactionRecord{key + 1, val})
 		}
 	}
-	buffer.WriteString("]")
-	if doflg != true {
-		//return shim.Error("Failed to find QueuedTransaction ")
-		buffer.WriteString(", \"Value\":")
-		buffer.WriteString("Failed to find QueuedTransaction")
+	if len(QueuedStatus.Transactions) == 0 {
+		return dataResponse(QueuedStatus, "Failed to find QueuedTransaction")
 	}
-	buffer.WriteString("}]")
-	fmt.Printf("%s", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return dataResponse(QueuedStatus)
 }
 
 //peer chaincode query -n mycc -c '{"Args":["queryHistoryTransactionStatus","H20180609","Finished"]}' -C myc
 func (s *SmartContract) queryHistoryTransactionStatus(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+		return errorResponse(codeArgumentCount, "Incorrect number of arguments. Expecting 3")
 	}
 	HTXKEY := args[0]
 	TXStatus := args[1]
@@ -3542,114 +3346,15 @@ func (s *SmartContract) queryHistoryTransactionStatus(APIstub shim.ChaincodeStub
 	HistoryTX := TransactionHistory{}
 	json.Unmarshal(HistoryAsBytes, &HistoryTX)
 
-	var doflg bool
-	doflg = false
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	buffer.WriteString("{\"HTXKEY\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(HistoryTX.TXKEY)
-	buffer.WriteString("\"")
-	buffer.WriteString(",\"Transactions\":[")
-	bArrayMemberAlreadyWritten := false
+	HistoryStatus := HistoryTransactionStatus{HTXKEY: HistoryTX.TXKEY, Transactions: []HistoryTransactionRecord{}}
 	for key, val := range HistoryTX.Transactions {
 		if (val.TXStatus == TXStatus || TXStatus == "All") && (val.BankFrom == BankID || val.BankTo == BankID || BankID == "All") {
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			buffer.WriteString("{\"HistoryKey\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.Itoa(key + 1))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXType\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXType)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXKinds\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.TXKinds[key])
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXFrom\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXFrom)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXTo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXTo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"BankFrom\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].BankFrom)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"BankTo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].BankTo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].SecurityID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"SecurityAmount\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(HistoryTX.Transactions[key].SecurityAmount, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"Payment\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(strconv.FormatInt(HistoryTX.Transactions[key].Payment, 10))
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXStatus\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXStatus)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXMemo\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXMemo)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXErrMsg\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXErrMsg)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXHcode\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXHcode)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"MatchedTXID\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].MatchedTXID)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"CreateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].CreateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"UpdateTime\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].UpdateTime)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXIndex\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXIndex)
-			buffer.WriteString("\"")
-			buffer.WriteString(", \"TXSIndex\":")
-			buffer.WriteString("\"")
-			buffer.WriteString(HistoryTX.Transactions[key].TXSIndex)
-			buffer.WriteString("\"")
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
-			doflg = true
+			HistoryStatus.Transactions = append(HistoryStatus.Transactions, HistoryTransactionRecord{key + 1, HistoryTX.TXKinds[key], val})
 		}
 	}
-	buffer.WriteString("]")
-	if doflg != true {
-		//return shim.Error("Failed to find TransactionHistory ")
-		buffer.WriteString(", \"Value\":")
-		buffer.WriteString("Failed to find HistoryTransaction")
+	if len(HistoryStatus.Transactions) == 0 {
+		return dataResponse(HistoryStatus, "Failed to find HistoryTransaction")
 	}
-	buffer.WriteString("}]")
-	fmt.Printf("%s", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return dataResponse(HistoryStatus)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+GetState does not return values written earlier in the same transaction.
+txStub keeps the transaction's own writes so that helpers which read, modify
+and write the same key one after another (e.g. updateAccountBalance followed by
+updateSecurityAmount on a Position) see each other's changes.
+Range and composite-key queries still return committed state only.
+*/
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes    map[string][]byte
+	deletes   map[string]bool
+	deltaSeq  int             // see nextDeltaSeq
+	increased map[string]bool // see notePositionIncrease
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+
+	return &txStub{
+		ChaincodeStubInterface: stub,
+		writes:                 make(map[string][]byte),
+		deletes:                make(map[string]bool),
+		increased:              make(map[string]bool),
+	}
+}
+
+func (t *txStub) GetState(key string) ([]byte, error) {
+
+	if t.deletes[key] == true {
+		return nil, nil
+	}
+	if value, ok := t.writes[key]; ok == true {
+		return value, nil
+	}
+	return t.ChaincodeStubInterface.GetState(key)
+}
+
+func (t *txStub) PutState(key string, value []byte) error {
+
+	err := t.ChaincodeStubInterface.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	t.writes[key] = append([]byte(nil), value...)
+	delete(t.deletes, key)
+	return nil
+}
+
+func (t *txStub) DelState(key string) error {
+
+	err := t.ChaincodeStubInterface.DelState(key)
+	if err != nil {
+		return err
+	}
+	t.deletes[key] = true
+	delete(t.writes, key)
+	return nil
+}